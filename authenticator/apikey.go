@@ -0,0 +1,54 @@
+package authenticator
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// APIKeyPrefix starts every API key, it tells keys apart from JWTs in the Authorization header
+const APIKeyPrefix = "aat_"
+
+// API key scopes
+const (
+	ScopeIssuesRead   = "issues:read"   // Read vehicle and station issues
+	ScopeIssuesWrite  = "issues:write"  // Report vehicle and station issues
+	ScopeVehiclesRead = "vehicles:read" // Read vehicle issues
+)
+
+var scopes = map[string]bool{
+	ScopeIssuesRead:   true,
+	ScopeIssuesWrite:  true,
+	ScopeVehiclesRead: true,
+}
+
+// ValidScope reports whether s is a known API key scope.
+func ValidScope(s string) bool {
+	return scopes[s]
+}
+
+// GenerateAPIKey generates a new random API key.
+// It returns the key, to be shown once to its creator, and its hash, the only value to be stored.
+func GenerateAPIKey() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	key := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the hex encoded SHA-256 of the key.
+// Keys are random and long, so a plain hash is enough to make a dump of the stored hashes useless.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsAPIKey reports whether the token looks like an API key rather than a JWT.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
@@ -0,0 +1,62 @@
+package authenticator
+
+import (
+	"testing"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, hash, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey() error = %v", err)
+	}
+
+	if !IsAPIKey(key) {
+		t.Errorf("GenerateAPIKey() key = %v, want %s prefix", key, APIKeyPrefix)
+	}
+	if hash != HashAPIKey(key) || hash == key {
+		t.Errorf("GenerateAPIKey() hash = %v, want HashAPIKey(key)", hash)
+	}
+
+	other, _, _ := GenerateAPIKey()
+	if other == key {
+		t.Errorf("GenerateAPIKey() generated the same key twice")
+	}
+}
+
+func TestIsAPIKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{name: "API key", token: "aat_abcdef", want: true},
+		{name: "JWT", token: "eyJhbGciOiJIUzI1NiJ9.e30.sig", want: false},
+		{name: "Empty", token: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsAPIKey(tt.token); got != tt.want {
+				t.Errorf("IsAPIKey(%q) = %v, want %v", tt.token, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidScope(t *testing.T) {
+	tests := []struct {
+		scope string
+		want  bool
+	}{
+		{scope: ScopeIssuesRead, want: true},
+		{scope: ScopeIssuesWrite, want: true},
+		{scope: "issues:*", want: false},
+		{scope: "", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidScope(tt.scope); got != tt.want {
+			t.Errorf("ValidScope(%q) = %v, want %v", tt.scope, got, tt.want)
+		}
+	}
+}
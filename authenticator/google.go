@@ -0,0 +1,71 @@
+package authenticator
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/mail"
+	"strings"
+)
+
+var (
+	ErrInvalidIssuer  = errors.New("id token not issued by google")
+	ErrInvalidNonce   = errors.New("id token nonce mismatch")
+	ErrUnverifiedMail = errors.New("mail not verified by google")
+)
+
+// Google ID token issuers
+var googleIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
+// CheckGoogleIdentity checks the claims of a validated Google ID token and returns the signed in mail address.
+// The token must be issued by Google for the nonce of the login request, with a verified mail
+// accepted by the current mail policy, see CurrentMailPolicy. Addresses of the authorized domains must belong
+// to a Google Workspace account of the same domain, that is with hd claim equal to the mail domain,
+// explicitly allowed addresses may be of any account.
+func CheckGoogleIdentity(claims map[string]interface{}, nonce string) (mail.Address, error) {
+	iss, _ := claims["iss"].(string)
+	if !validGoogleIssuer(iss) {
+		return mail.Address{}, ErrInvalidIssuer
+	}
+
+	tokenNonce, _ := claims["nonce"].(string)
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return mail.Address{}, ErrInvalidNonce
+	}
+
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		return mail.Address{}, ErrUnverifiedMail
+	}
+
+	email, _ := claims["email"].(string)
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return mail.Address{}, ErrMalformedMail
+	}
+
+	policy, err := CurrentMailPolicy()
+	if err != nil {
+		return mail.Address{}, err
+	}
+	mailbox, err := policy.Check(*addr)
+	if err != nil {
+		return mail.Address{}, err
+	}
+
+	// Personal accounts have no hosted domain, Workspace accounts of other domains have their own
+	hd, _ := claims["hd"].(string)
+	if !policy.Allowed[mailbox] && !strings.HasSuffix(mailbox, "@"+strings.ToLower(hd)) {
+		return mail.Address{}, ErrUnauthorizedDomain
+	}
+
+	return mail.Address{Address: addr.Address}, nil
+}
+
+// validGoogleIssuer reports whether iss is one of the Google ID token issuers.
+func validGoogleIssuer(iss string) bool {
+	for _, i := range googleIssuers {
+		if iss == i {
+			return true
+		}
+	}
+	return false
+}
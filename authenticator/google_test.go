@@ -0,0 +1,64 @@
+package authenticator
+
+import (
+	"aat-manager/db"
+	"aat-manager/utils"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestCheckGoogleIdentity(t *testing.T) {
+	os.Setenv(utils.AUTHORIZEDDOMAIN, "test.com")
+	UseMailPolicyStore(&fakePolicyStore{entries: []db.LoginPolicyEntry{
+		{Kind: db.PolicyAllow, Value: "volunteer@gmail.com"},
+		{Kind: db.PolicyBlock, Value: "ex-staff@test.com"},
+	}})
+	defer UseMailPolicyStore(nil)
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":            "https://accounts.google.com",
+			"nonce":          "nonce",
+			"email":          "user@test.com",
+			"email_verified": true,
+			"hd":             "test.com",
+		}
+	}
+
+	tests := []struct {
+		name    string
+		edit    func(claims map[string]interface{})
+		nonce   string
+		want    string
+		wantErr error
+	}{
+		{name: "Valid claims", edit: func(c map[string]interface{}) {}, nonce: "nonce", want: "user@test.com"},
+		{name: "Short issuer", edit: func(c map[string]interface{}) { c["iss"] = "accounts.google.com" }, nonce: "nonce", want: "user@test.com"},
+		{name: "Foreign issuer", edit: func(c map[string]interface{}) { c["iss"] = "https://evil.com" }, nonce: "nonce", wantErr: ErrInvalidIssuer},
+		{name: "Nonce mismatch", edit: func(c map[string]interface{}) {}, nonce: "other", wantErr: ErrInvalidNonce},
+		{name: "Blank nonce", edit: func(c map[string]interface{}) { c["nonce"] = "" }, nonce: "", wantErr: ErrInvalidNonce},
+		{name: "Unverified mail", edit: func(c map[string]interface{}) { c["email_verified"] = false }, nonce: "nonce", wantErr: ErrUnverifiedMail},
+		{name: "Malformed mail", edit: func(c map[string]interface{}) { c["email"] = "user" }, nonce: "nonce", wantErr: ErrMalformedMail},
+		{name: "Personal account", edit: func(c map[string]interface{}) { delete(c, "hd") }, nonce: "nonce", wantErr: ErrUnauthorizedDomain},
+		{name: "Other workspace", edit: func(c map[string]interface{}) { c["hd"] = "other.com" }, nonce: "nonce", wantErr: ErrUnauthorizedDomain},
+		{name: "Mail outside hosted domain", edit: func(c map[string]interface{}) { c["email"] = "user@other.com" }, nonce: "nonce", wantErr: ErrUnauthorizedDomain},
+		{name: "Allowed personal account", edit: func(c map[string]interface{}) { c["email"] = "volunteer@gmail.com"; delete(c, "hd") }, nonce: "nonce", want: "volunteer@gmail.com"},
+		{name: "Blocked account", edit: func(c map[string]interface{}) { c["email"] = "ex-staff@test.com" }, nonce: "nonce", wantErr: ErrBlockedMail},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.edit(claims)
+
+			got, err := CheckGoogleIdentity(claims, tt.nonce)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CheckGoogleIdentity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.Address != tt.want {
+				t.Errorf("CheckGoogleIdentity() = %v, want %v", got.Address, tt.want)
+			}
+		})
+	}
+}
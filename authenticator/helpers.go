@@ -2,18 +2,81 @@ package authenticator
 
 import (
 	"errors"
+	"fmt"
 	"math/rand"
+	"net/mail"
+	"strings"
+	"time"
 )
 
 // Error definition
 var (
 	ErrMalformedMail      = errors.New("malformed mail")
 	ErrUnauthorizedDomain = errors.New("domain not authorized")
+	ErrBlockedMail        = errors.New("mail address blocked")
 	ErrNonNumericValue    = errors.New("value is not a number")
 	ErrUserNotFound       = errors.New("user not found")
 	ErrBlankSecret        = errors.New("blank secret key used")
+	ErrTooManyAttempts    = errors.New("too many failed attempts")
+	ErrLockedOut          = errors.New("mailbox temporarily locked")
+	ErrOtpBackoff         = errors.New("otp requested too often")
 )
 
+// RetryError is returned when an action is refused for a limited time.
+// It wraps the reason of the refusal and holds the time to wait before retrying.
+type RetryError struct {
+	Err        error         // Reason of the refusal
+	RetryAfter time.Duration // Time to wait before retrying
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%s, retry in %s", e.Err, e.RetryAfter.Round(time.Second))
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// CheckMailDomain returns the mailbox of the mail address if the current mail policy lets it log in,
+// that is if its domain is utils.AUTHORIZEDDOMAIN or another authorized domain, or the address is explicitly allowed,
+// and the address is not blocked, see CurrentMailPolicy.
+// It returns ErrMalformedMail if the address has no "@", ErrBlockedMail for blocked addresses
+// and ErrUnauthorizedDomain for other domains.
+func CheckMailDomain(mail mail.Address) (string, error) {
+	policy, err := CurrentMailPolicy()
+	if err != nil {
+		return "", err
+	}
+
+	return policy.Check(mail)
+}
+
+// CheckNotBlocked returns ErrBlockedMail if the current mail policy blocks the mail address.
+// Unlike CheckMailDomain it accepts the addresses of unauthorized domains, it guards the steps
+// following a successful login request, like the OTP check and the token renewal.
+func CheckNotBlocked(mail mail.Address) error {
+	policy, err := CurrentMailPolicy()
+	if err != nil {
+		return err
+	}
+
+	if _, err := policy.Check(mail); errors.Is(err, ErrBlockedMail) {
+		return err
+	}
+	return nil
+}
+
+// mailboxKey returns the mailbox of the mail address, that is the lowercase full address,
+// used to key the OTP state in the store. Keeping the domain avoids collisions between
+// the same user name of different domains.
+// It returns ErrMalformedMail if the address has no "@".
+func mailboxKey(mail mail.Address) (string, error) {
+	if !strings.Contains(mail.Address, "@") {
+		return "", ErrMalformedMail
+	}
+	return strings.ToLower(mail.Address), nil
+}
+
 // Secret generator dictionary
 const secretBytes = "0123456789"
 
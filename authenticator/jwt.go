@@ -1,37 +1,69 @@
 package authenticator
 
 import (
-	"aat-manager/utils"
+	"aat-manager/db"
+	cryptorand "crypto/rand"
+	"encoding/hex"
 	"github.com/golang-jwt/jwt/v4"
 	"strconv"
 	"time"
 )
 
-// CreateAndSignJWT creates and signs a JSON Web Token (JWT) with the specified user and manager information.
-// It returns the generated token as a string, along with any error encountered.
-func CreateAndSignJWT(user string, manager bool) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
+// User roles
+const (
+	RoleCrew    = "crew"
+	RoleManager = "manager"
+)
 
-	// Expire days from env
-	expiredays, err := strconv.Atoi(utils.ReadEnvOrPanic(utils.JWTEXPIREINMONTH))
+// NewSessionID generates a random session ID, to be used as JWT jti claim.
+func NewSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := cryptorand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CreateAndSignJWT creates and signs a short-lived JSON Web Token (JWT) for the given user and session.
+// The sub claim carries the user ID, the name claim its display name, or the e-mail if blank,
+// and the email claim its full e-mail. The role and manager claims are derived from the user role
+// and the session ID is written in the jti claim.
+// The token expires after the access token lifetime of CurrentSessionLimits, never after its session.
+// The token is signed with the active key of the current key ring, see CurrentKeyRing.
+// It returns the generated token as a string, along with any error encountered.
+func CreateAndSignJWT(user db.User, session db.Session) (string, error) {
+	limits, err := CurrentSessionLimits()
 	if err != nil {
-		return "", ErrNonNumericValue
+		return "", err
+	}
+	expires := time.Now().Add(limits.AccessTTL)
+	if session.ExpiresAt.Before(expires) {
+		expires = session.ExpiresAt
 	}
 
 	// Token claims
-	claims := token.Claims.(jwt.MapClaims)
-	claims["name"] = user
-	claims["manager"] = manager
-	claims["exp"] = time.Now().AddDate(0, expiredays, 0).Unix()
+	claims := jwt.MapClaims{}
+	claims["sub"] = strconv.FormatInt(user.ID, 10)
+	claims["name"] = user.DisplayName
+	if user.DisplayName == "" {
+		claims["name"] = user.Email
+	}
+	claims["email"] = user.Email
+	claims["manager"] = user.Role == RoleManager
+	claims["role"] = RoleCrew
+	if user.Role == RoleManager {
+		claims["role"] = RoleManager
+	}
+	claims["jti"] = session.ID
+	claims["exp"] = expires.Unix()
 
-	// Read secret from env and sig the token
-	jwtSecret := utils.ReadEnvOrPanic(utils.JWTSECRET)
-	// Check if blank secret is used and return error
-	if jwtSecret == "" {
-		return "", ErrBlankSecret
+	// Read key ring from env, a blank secret without key ring returns error
+	ring, err := CurrentKeyRing()
+	if err != nil {
+		return "", err
 	}
-	// Sign the token using provided secret
-	t, err := token.SignedString([]byte(jwtSecret))
+	// Sign the token using the active key
+	t, err := ring.Sign(claims)
 	if err != nil {
 		return "", err
 	}
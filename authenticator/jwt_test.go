@@ -1,15 +1,17 @@
 package authenticator
 
 import (
+	"aat-manager/db"
 	"aat-manager/utils"
+	"github.com/golang-jwt/jwt/v4"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestCreateAndSignJWT(t *testing.T) {
 	type args struct {
-		user    string
-		manager bool
+		user db.User
 	}
 	tests := []struct {
 		name      string
@@ -19,28 +21,28 @@ func TestCreateAndSignJWT(t *testing.T) {
 	}{
 		{
 			name: "Happy Path",
-			args: args{user: "TestUser", manager: true},
+			args: args{user: db.User{ID: 1, Email: "testuser@test.com", Role: RoleManager}},
 			setupFunc: func() {
 				os.Setenv(utils.JWTSECRET, "test_secret")
-				os.Setenv(utils.JWTEXPIREINMONTH, "30")
+				os.Setenv(utils.JWTACCESSTTL, "15m")
 			},
 			wantErr: false,
 		},
 		{
 			name: "Missing JWT Secret",
-			args: args{user: "TestUser", manager: true},
+			args: args{user: db.User{ID: 1, Email: "testuser@test.com", Role: RoleManager}},
 			setupFunc: func() {
 				os.Setenv(utils.JWTSECRET, "")
-				os.Setenv(utils.JWTEXPIREINMONTH, "30")
+				os.Setenv(utils.JWTACCESSTTL, "15m")
 			},
 			wantErr: true,
 		},
 		{
-			name: "Non Numeric Expire Days",
-			args: args{user: "TestUser", manager: true},
+			name: "Malformed Access TTL",
+			args: args{user: db.User{ID: 1, Email: "testuser@test.com", Role: RoleManager}},
 			setupFunc: func() {
 				os.Setenv(utils.JWTSECRET, "test_secret")
-				os.Setenv(utils.JWTEXPIREINMONTH, "invalid_days")
+				os.Setenv(utils.JWTACCESSTTL, "invalid_ttl")
 			},
 			wantErr: true,
 		},
@@ -50,7 +52,7 @@ func TestCreateAndSignJWT(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// Setup function for environment.
 			tt.setupFunc()
-			_, err := CreateAndSignJWT(tt.args.user, tt.args.manager)
+			_, err := CreateAndSignJWT(tt.args.user, testSession())
 			if (err != nil) != tt.wantErr {
 				t.Errorf("CreateAndSignJWT() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -58,3 +60,101 @@ func TestCreateAndSignJWT(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateAndSignJWTRoleClaim(t *testing.T) {
+	os.Setenv(utils.JWTSECRET, "test_secret")
+	os.Setenv(utils.JWTACCESSTTL, "15m")
+
+	tests := []struct {
+		name     string
+		user     db.User
+		wantRole string
+		wantName string
+	}{
+		{name: "Crew", user: db.User{ID: 7, Email: "crew@test.com", Role: RoleCrew}, wantRole: RoleCrew, wantName: "crew@test.com"},
+		{name: "Manager", user: db.User{ID: 7, Email: "manager@test.com", DisplayName: "Mario Rossi", Role: RoleManager}, wantRole: RoleManager, wantName: "Mario Rossi"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signed, err := CreateAndSignJWT(tt.user, testSession())
+			if err != nil {
+				t.Fatalf("CreateAndSignJWT() error = %v", err)
+			}
+
+			token, err := jwt.Parse(signed, func(token *jwt.Token) (interface{}, error) {
+				return []byte("test_secret"), nil
+			})
+			if err != nil {
+				t.Fatalf("jwt.Parse() error = %v", err)
+			}
+
+			claims := token.Claims.(jwt.MapClaims)
+			if claims["role"] != tt.wantRole {
+				t.Errorf("role claim = %v, want %v", claims["role"], tt.wantRole)
+			}
+			if claims["sub"] != "7" {
+				t.Errorf("sub claim = %v, want 7", claims["sub"])
+			}
+			if claims["name"] != tt.wantName || claims["email"] != tt.user.Email {
+				t.Errorf("name, email claims = %v, %v, want %v, %v", claims["name"], claims["email"], tt.wantName, tt.user.Email)
+			}
+			if claims["jti"] != "session" {
+				t.Errorf("jti claim = %v, want session", claims["jti"])
+			}
+		})
+	}
+}
+
+func TestCreateAndSignJWTExpiration(t *testing.T) {
+	os.Setenv(utils.JWTSECRET, "test_secret")
+	os.Setenv(utils.JWTACCESSTTL, "15m")
+
+	tests := []struct {
+		name           string
+		sessionExpires time.Time
+		wantExp        time.Time
+	}{
+		{name: "Access token lifetime", sessionExpires: time.Now().Add(time.Hour), wantExp: time.Now().Add(15 * time.Minute)},
+		{name: "Session ending first", sessionExpires: time.Now().Add(5 * time.Minute), wantExp: time.Now().Add(5 * time.Minute)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := db.Session{ID: "session", ExpiresAt: tt.sessionExpires}
+			signed, err := CreateAndSignJWT(db.User{ID: 1, Email: "user@test.com"}, session)
+			if err != nil {
+				t.Fatalf("CreateAndSignJWT() error = %v", err)
+			}
+
+			claims := jwt.MapClaims{}
+			if _, err := jwt.ParseWithClaims(signed, claims, func(token *jwt.Token) (interface{}, error) {
+				return []byte("test_secret"), nil
+			}); err != nil {
+				t.Fatalf("jwt.Parse() error = %v", err)
+			}
+
+			exp := time.Unix(int64(claims["exp"].(float64)), 0)
+			if diff := exp.Sub(tt.wantExp); diff < -time.Second || diff > time.Second {
+				t.Errorf("exp claim = %v, want %v", exp, tt.wantExp)
+			}
+		})
+	}
+}
+
+// testSession returns a session lasting longer than the access tokens.
+func testSession() db.Session {
+	return db.Session{ID: "session", ExpiresAt: time.Now().Add(24 * time.Hour)}
+}
+
+func TestNewSessionID(t *testing.T) {
+	a, err := NewSessionID()
+	if err != nil {
+		t.Fatalf("NewSessionID() error = %v", err)
+	}
+	b, _ := NewSessionID()
+
+	if len(a) != 32 || a == b {
+		t.Errorf("NewSessionID() = %v, %v, want distinct 32 hex characters IDs", a, b)
+	}
+}
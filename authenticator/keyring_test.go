@@ -0,0 +1,219 @@
+package authenticator
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	"testing"
+	"time"
+)
+
+// pemKey returns the PKCS8 PEM encoding of a private key.
+func pemKey(t *testing.T, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey() error = %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+// keysJSON returns the JWTKEYS value for the key configurations.
+func keysJSON(t *testing.T, configs ...keyConfig) string {
+	t.Helper()
+	raw, err := json.Marshal(configs)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	return string(raw)
+}
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{"name": "TestUser", "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+func TestNewKeyRing(t *testing.T) {
+	hs := keyConfig{ID: "hs", Alg: "HS256", Secret: "secret"}
+
+	tests := []struct {
+		name     string
+		keys     string
+		activeID string
+		legacy   string
+		wantKid  string
+		wantErr  error
+	}{
+		{name: "Legacy secret only", legacy: "secret", wantKid: LegacyKeyID},
+		{name: "No keys", wantErr: ErrBlankSecret},
+		{name: "Key ring", keys: keysJSON(t, hs), activeID: "hs", legacy: "secret", wantKid: "hs"},
+		{name: "Missing active key", keys: keysJSON(t, hs), wantErr: ErrMissingActiveKey},
+		{name: "Unknown active key", keys: keysJSON(t, hs), activeID: "other", wantErr: ErrUnknownKeyID},
+		{name: "Duplicate key", keys: keysJSON(t, hs, hs), activeID: "hs", wantErr: ErrDuplicateKeyID},
+		{name: "Unsupported algorithm", keys: keysJSON(t, keyConfig{ID: "none", Alg: "none"}), activeID: "none", wantErr: ErrUnsupportedAlg},
+		{name: "Missing secret", keys: keysJSON(t, keyConfig{ID: "hs", Alg: "HS256"}), activeID: "hs", wantErr: ErrMissingKeyContent},
+		{name: "Malformed JSON", keys: "{", activeID: "hs", wantErr: ErrMalformedKeyRing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ring, err := NewKeyRing(tt.keys, tt.activeID, tt.legacy)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewKeyRing() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && ring.ActiveKeyID() != tt.wantKid {
+				t.Errorf("ActiveKeyID() = %v, want %v", ring.ActiveKeyID(), tt.wantKid)
+			}
+		})
+	}
+}
+
+func TestKeyRingSignAndParse(t *testing.T) {
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey() error = %v", err)
+	}
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		config keyConfig
+	}{
+		{name: "HS256", config: keyConfig{ID: "hs", Alg: "HS256", Secret: "secret"}},
+		{name: "RS256", config: keyConfig{ID: "rs", Alg: "RS256", PEM: pemKey(t, rsaPrivate)}},
+		{name: "EdDSA", config: keyConfig{ID: "ed", Alg: "EdDSA", PEM: pemKey(t, edPrivate)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ring, err := NewKeyRing(keysJSON(t, tt.config), tt.config.ID, "")
+			if err != nil {
+				t.Fatalf("NewKeyRing() error = %v", err)
+			}
+
+			signed, err := ring.Sign(testClaims())
+			if err != nil {
+				t.Fatalf("Sign() error = %v", err)
+			}
+			token, err := ring.Parse(signed)
+			if err != nil || !token.Valid {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if token.Header["kid"] != tt.config.ID || token.Method.Alg() != tt.config.Alg {
+				t.Errorf("Parse() header = %v, want kid %v and alg %v", token.Header, tt.config.ID, tt.config.Alg)
+			}
+		})
+	}
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	old := keyConfig{ID: "old", Alg: "HS256", Secret: "old_secret"}
+	current := keyConfig{ID: "new", Alg: "HS256", Secret: "new_secret"}
+
+	// Tokens signed with the JWTSECRET before the key ring, without kid
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims()).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("SignedString() error = %v", err)
+	}
+
+	before, err := NewKeyRing(keysJSON(t, old), "old", "secret")
+	if err != nil {
+		t.Fatalf("NewKeyRing() error = %v", err)
+	}
+	signedOld, _ := before.Sign(testClaims())
+
+	// New key added and made active, old key still in the ring
+	after, err := NewKeyRing(keysJSON(t, old, current), "new", "secret")
+	if err != nil {
+		t.Fatalf("NewKeyRing() error = %v", err)
+	}
+	signedNew, _ := after.Sign(testClaims())
+
+	// Old key retired
+	retired, err := NewKeyRing(keysJSON(t, current), "new", "")
+	if err != nil {
+		t.Fatalf("NewKeyRing() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		ring    *KeyRing
+		token   string
+		wantErr bool
+	}{
+		{name: "Old token after rotation", ring: after, token: signedOld, wantErr: false},
+		{name: "New token after rotation", ring: after, token: signedNew, wantErr: false},
+		{name: "Legacy token without kid", ring: after, token: legacy, wantErr: false},
+		{name: "Old token after retirement", ring: retired, token: signedOld, wantErr: true},
+		{name: "Legacy token without legacy secret", ring: retired, token: legacy, wantErr: true},
+		{name: "New token on old ring", ring: before, token: signedNew, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.ring.Parse(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyRingRejectsAlgorithmConfusion(t *testing.T) {
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+	ring, err := NewKeyRing(keysJSON(t, keyConfig{ID: "rs", Alg: "RS256", PEM: pemKey(t, rsaPrivate)}), "rs", "")
+	if err != nil {
+		t.Fatalf("NewKeyRing() error = %v", err)
+	}
+
+	// HS256 token using the published public key as HMAC secret
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	forged.Header["kid"] = "rs"
+	publicDER, _ := x509.MarshalPKIXPublicKey(&rsaPrivate.PublicKey)
+	signed, err := forged.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+	if err != nil {
+		t.Fatalf("SignedString() error = %v", err)
+	}
+
+	if _, err := ring.Parse(signed); !errors.Is(err, ErrUnexpectedAlg) {
+		t.Errorf("Parse() error = %v, want %v", err, ErrUnexpectedAlg)
+	}
+}
+
+func TestKeyRingJWKS(t *testing.T) {
+	_, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+
+	ring, err := NewKeyRing(keysJSON(t,
+		keyConfig{ID: "hs", Alg: "HS256", Secret: "secret"},
+		keyConfig{ID: "rs", Alg: "RS256", PEM: pemKey(t, rsaPrivate)},
+		keyConfig{ID: "ed", Alg: "EdDSA", PEM: pemKey(t, edPrivate)},
+	), "ed", "secret")
+	if err != nil {
+		t.Fatalf("NewKeyRing() error = %v", err)
+	}
+
+	keys := ring.JWKS().Keys
+	if len(keys) != 2 {
+		t.Fatalf("JWKS() has %d keys, want 2 public keys: %+v", len(keys), keys)
+	}
+	if keys[0].Kid != "ed" || keys[0].Kty != "OKP" || keys[0].X == "" {
+		t.Errorf("JWKS() key 0 = %+v, want ed OKP key", keys[0])
+	}
+	if keys[1].Kid != "rs" || keys[1].Kty != "RSA" || keys[1].N == "" || keys[1].E != "AQAB" {
+		t.Errorf("JWKS() key 1 = %+v, want rs RSA key", keys[1])
+	}
+}
@@ -0,0 +1,139 @@
+package authenticator
+
+import (
+	"aat-manager/db"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformedMagicLink = errors.New("malformed magic link")
+	ErrExpiredMagicLink   = errors.New("magic link expired")
+	ErrInvalidMagicLink   = errors.New("magic link invalid or already used")
+)
+
+const (
+	magicLinkPrefix = "magic-link:" // Key prefix of the pending magic link nonce hash in the OTP store
+	magicLinkTTL    = db.DefaultTTL // Magic link lifetime, the same of the OTP sent in the same mail
+)
+
+// GenMagicLinkAndSave generates a single-use login link token for the mail address, to be sent next to its OTP.
+// The token carries the mailbox, a random nonce and the expiration, signed with HMAC-SHA256 keyed with a key derived from the OTP secret.
+// The link is bound to the pending auth nonce, kept by the browser requesting the link and never sent by mail:
+// only the hash of both nonces is saved in the store, so the link alone can't log in. A new link replaces the previous one.
+func GenMagicLinkAndSave(mail mail.Address, pendingNonce string, db db.OtpStore) (string, error) {
+	mailbox, err := CheckMailDomain(mail)
+	if err != nil {
+		return "", err
+	}
+
+	nonce, err := NewSessionID()
+	if err != nil {
+		return "", err
+	}
+	expires := time.Now().Add(magicLinkTTL)
+	payload := mailbox + ":" + nonce + ":" + strconv.FormatInt(expires.Unix(), 10)
+
+	signature, err := signMagicLink(payload)
+	if err != nil {
+		return "", err
+	}
+	hash, err := signMagicLink(magicLinkPrefix + nonce + ":" + pendingNonce)
+	if err != nil {
+		return "", err
+	}
+
+	attemptsMux.Lock()
+	defer attemptsMux.Unlock()
+	db.SetWithTTL(magicLinkPrefix+mailbox, hash, magicLinkTTL)
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + signature, nil
+}
+
+// CheckMagicLinkAndDelete checks a magic link token for the mail address and nonce of the pending auth.
+// The token must be signed, not expired, issued for the same mailbox and pending auth nonce and still pending in the store.
+// On success the link and the OTP sent with it are deleted, so both can be used only once.
+// Checks failing before the store lookup don't consume the link, so mail scanners opening it
+// without the pending auth can't invalidate it.
+func CheckMagicLinkAndDelete(mail mail.Address, pendingNonce string, token string, db db.OtpStore) (bool, error) {
+	mailbox, err := CheckMailDomain(mail)
+	if err != nil {
+		return false, err
+	}
+
+	encodedPayload, signature, found := strings.Cut(token, ".")
+	if !found {
+		return false, ErrMalformedMagicLink
+	}
+	rawPayload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return false, ErrMalformedMagicLink
+	}
+	payload := string(rawPayload)
+
+	wantSignature, err := signMagicLink(payload)
+	if err != nil {
+		return false, err
+	}
+	if !hmac.Equal([]byte(signature), []byte(wantSignature)) {
+		return false, ErrInvalidMagicLink
+	}
+
+	// Quoted mailboxes may contain ":", nonce and expiration can't
+	parts := strings.Split(payload, ":")
+	if len(parts) < 3 {
+		return false, ErrMalformedMagicLink
+	}
+	linkMailbox, nonce := strings.Join(parts[:len(parts)-2], ":"), parts[len(parts)-2]
+	expires, err := strconv.ParseInt(parts[len(parts)-1], 10, 64)
+	if err != nil {
+		return false, ErrMalformedMagicLink
+	}
+	if !time.Now().Before(time.Unix(expires, 0)) {
+		return false, ErrExpiredMagicLink
+	}
+	if linkMailbox != mailbox {
+		return false, ErrInvalidMagicLink
+	}
+
+	hash, err := signMagicLink(magicLinkPrefix + nonce + ":" + pendingNonce)
+	if err != nil {
+		return false, err
+	}
+
+	attemptsMux.Lock()
+	defer attemptsMux.Unlock()
+
+	// Consume the link in a single store operation, so concurrent checks on any instance can't all pass
+	consumed, err := db.CompareAndDelete(magicLinkPrefix+mailbox, hash)
+	if err != nil {
+		return false, err
+	}
+	if !consumed {
+		return false, ErrInvalidMagicLink
+	}
+
+	db.Delete(mailbox)
+	db.Delete(otpAttemptsPrefix + mailbox)
+	db.Delete(otpRequestsPrefix + mailbox)
+	return true, nil
+}
+
+// signMagicLink returns the hex encoded HMAC-SHA256 of the value, keyed with a key derived from the OTP secret.
+func signMagicLink(value string) (string, error) {
+	key, err := deriveOtpKey(magicLinkPurpose)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
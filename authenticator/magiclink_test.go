@@ -0,0 +1,145 @@
+package authenticator
+
+import (
+	"aat-manager/db"
+	"aat-manager/utils"
+	"encoding/base64"
+	"errors"
+	"net/mail"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCheckMagicLinkAndDelete(t *testing.T) {
+	os.Setenv(utils.OTPSECRET, "test_secret")
+	os.Setenv(utils.AUTHORIZEDDOMAIN, "test.com")
+	user := mail.Address{Address: "user@test.com"}
+
+	tests := []struct {
+		name    string
+		mail    mail.Address
+		nonce   string
+		token   func(t *testing.T, d *db.InMemoryDb) string
+		want    bool
+		wantErr error
+	}{
+		{
+			name: "Valid link",
+			mail: user,
+			token: func(t *testing.T, d *db.InMemoryDb) string {
+				return mustMagicLink(t, user, d)
+			},
+			want: true,
+		},
+		{
+			name: "Other pending mailbox",
+			mail: mail.Address{Address: "other@test.com"},
+			token: func(t *testing.T, d *db.InMemoryDb) string {
+				return mustMagicLink(t, user, d)
+			},
+			wantErr: ErrInvalidMagicLink,
+		},
+		{
+			name:  "Other pending auth nonce",
+			mail:  user,
+			nonce: "other-nonce",
+			token: func(t *testing.T, d *db.InMemoryDb) string {
+				return mustMagicLink(t, user, d)
+			},
+			wantErr: ErrInvalidMagicLink,
+		},
+		{
+			name: "Replaced link",
+			mail: user,
+			token: func(t *testing.T, d *db.InMemoryDb) string {
+				old := mustMagicLink(t, user, d)
+				mustMagicLink(t, user, d)
+				return old
+			},
+			wantErr: ErrInvalidMagicLink,
+		},
+		{
+			name: "Tampered payload",
+			mail: user,
+			token: func(t *testing.T, d *db.InMemoryDb) string {
+				_, signature, _ := strings.Cut(mustMagicLink(t, user, d), ".")
+				payload := base64.RawURLEncoding.EncodeToString([]byte("user@test.com:nonce:99999999999"))
+				return payload + "." + signature
+			},
+			wantErr: ErrInvalidMagicLink,
+		},
+		{
+			name: "Expired link",
+			mail: user,
+			token: func(t *testing.T, d *db.InMemoryDb) string {
+				payload := "user@test.com:nonce:1"
+				signature, _ := signMagicLink(payload)
+				return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + signature
+			},
+			wantErr: ErrExpiredMagicLink,
+		},
+		{
+			name: "Malformed token",
+			mail: user,
+			token: func(t *testing.T, d *db.InMemoryDb) string {
+				return "not-a-token"
+			},
+			wantErr: ErrMalformedMagicLink,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := db.NewDB()
+			defer d.Close()
+
+			nonce := tt.nonce
+			if nonce == "" {
+				nonce = testPendingNonce
+			}
+
+			got, err := CheckMagicLinkAndDelete(tt.mail, nonce, tt.token(t, d), d)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CheckMagicLinkAndDelete() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("CheckMagicLinkAndDelete() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMagicLinkSingleUse(t *testing.T) {
+	os.Setenv(utils.OTPSECRET, "test_secret")
+	os.Setenv(utils.AUTHORIZEDDOMAIN, "test.com")
+	user := mail.Address{Address: "user@test.com"}
+
+	d := db.NewDB()
+	defer d.Close()
+	d.Set("user@test.com", mustHashOtp("user@test.com", "1234"))
+	token := mustMagicLink(t, user, d)
+
+	if ok, err := CheckMagicLinkAndDelete(user, testPendingNonce, token, d); !ok || err != nil {
+		t.Fatalf("CheckMagicLinkAndDelete() first use = %v, %v, want true", ok, err)
+	}
+	if ok, err := CheckMagicLinkAndDelete(user, testPendingNonce, token, d); ok || !errors.Is(err, ErrInvalidMagicLink) {
+		t.Errorf("CheckMagicLinkAndDelete() second use = %v, %v, want %v", ok, err, ErrInvalidMagicLink)
+	}
+	// The OTP sent in the same mail is consumed too
+	if _, exist, _ := d.Get("user@test.com"); exist {
+		t.Errorf("OTP still pending after magic link login")
+	}
+}
+
+// testPendingNonce is the pending auth nonce the test links are bound to
+const testPendingNonce = "pending-nonce"
+
+func mustMagicLink(t *testing.T, addr mail.Address, d *db.InMemoryDb) string {
+	t.Helper()
+	token, err := GenMagicLinkAndSave(addr, testPendingNonce, d)
+	if err != nil {
+		t.Fatalf("GenMagicLinkAndSave() error = %v", err)
+	}
+	return token
+}
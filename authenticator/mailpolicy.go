@@ -0,0 +1,139 @@
+package authenticator
+
+import (
+	"aat-manager/db"
+	"aat-manager/utils"
+	"log"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mailPolicyRefresh is the time a stored policy is used before reloading it,
+// so changes made on another instance take effect without a restart
+const mailPolicyRefresh = 30 * time.Second
+
+// MailPolicy decides which mail addresses may log in.
+// Blocked addresses are always refused, explicitly allowed addresses are accepted whatever their domain,
+// any other address is accepted only if its domain is authorized.
+type MailPolicy struct {
+	Domains map[string]bool // Authorized lowercase domains
+	Allowed map[string]bool // Allowed lowercase addresses
+	Blocked map[string]bool // Blocked lowercase addresses
+}
+
+// MailPolicyStore loads the stored login policy entries, see db.LoginPolicies.
+type MailPolicyStore interface {
+	ListPolicyEntries() ([]db.LoginPolicyEntry, error)
+}
+
+// NewMailPolicy builds a mail policy from the authorized domain and the stored policy entries.
+// Entries of unknown kind are ignored.
+func NewMailPolicy(domain string, entries []db.LoginPolicyEntry) MailPolicy {
+	policy := MailPolicy{
+		Domains: map[string]bool{strings.ToLower(domain): true},
+		Allowed: make(map[string]bool),
+		Blocked: make(map[string]bool),
+	}
+	for _, entry := range entries {
+		value := strings.ToLower(entry.Value)
+		switch entry.Kind {
+		case db.PolicyDomain:
+			policy.Domains[value] = true
+		case db.PolicyAllow:
+			policy.Allowed[value] = true
+		case db.PolicyBlock:
+			policy.Blocked[value] = true
+		}
+	}
+	return policy
+}
+
+// Check returns the mailbox of the mail address if the policy lets it log in.
+// It returns ErrMalformedMail if the address has no "@", ErrBlockedMail for blocked addresses
+// and ErrUnauthorizedDomain for other domains.
+func (p MailPolicy) Check(mail mail.Address) (string, error) {
+	mailbox, err := mailboxKey(mail)
+	if err != nil {
+		return "", err
+	}
+
+	if p.Blocked[mailbox] {
+		return "", ErrBlockedMail
+	}
+	if p.Allowed[mailbox] || p.Domains[mailbox[strings.LastIndex(mailbox, "@")+1:]] {
+		return mailbox, nil
+	}
+	return "", ErrUnauthorizedDomain
+}
+
+// HostedDomain returns the value of the Google hd parameter restricting the account chooser:
+// the authorized domain if there is only one, "*" to accept any Workspace domain otherwise.
+func (p MailPolicy) HostedDomain() string {
+	if len(p.Domains) == 1 {
+		for domain := range p.Domains {
+			return domain
+		}
+	}
+	return "*"
+}
+
+// mailPolicyCache holds the policy loaded from the store, reloaded every mailPolicyRefresh
+var mailPolicyCache struct {
+	mux      sync.Mutex
+	store    MailPolicyStore
+	domain   string
+	policy   *MailPolicy
+	loadedAt time.Time
+}
+
+// UseMailPolicyStore sets the store of the login policy entries.
+// Without a store only the utils.AUTHORIZEDDOMAIN domain is authorized.
+func UseMailPolicyStore(store MailPolicyStore) {
+	mailPolicyCache.mux.Lock()
+	defer mailPolicyCache.mux.Unlock()
+
+	mailPolicyCache.store = store
+	mailPolicyCache.policy = nil
+}
+
+// InvalidateMailPolicy marks the cached policy as stale, the next check reloads it from the store.
+func InvalidateMailPolicy() {
+	mailPolicyCache.mux.Lock()
+	defer mailPolicyCache.mux.Unlock()
+
+	mailPolicyCache.loadedAt = time.Time{}
+}
+
+// CurrentMailPolicy returns the policy made of the utils.AUTHORIZEDDOMAIN domain and the stored entries.
+// If reloading the entries fails, the last loaded policy is kept and the error is only logged.
+func CurrentMailPolicy() (MailPolicy, error) {
+	domain := utils.ReadEnvOrPanic(utils.AUTHORIZEDDOMAIN)
+
+	mailPolicyCache.mux.Lock()
+	defer mailPolicyCache.mux.Unlock()
+
+	if mailPolicyCache.store == nil {
+		return NewMailPolicy(domain, nil), nil
+	}
+	if mailPolicyCache.policy != nil && mailPolicyCache.domain == domain && time.Since(mailPolicyCache.loadedAt) < mailPolicyRefresh {
+		return *mailPolicyCache.policy, nil
+	}
+
+	entries, err := mailPolicyCache.store.ListPolicyEntries()
+	if err != nil {
+		if mailPolicyCache.policy != nil && mailPolicyCache.domain == domain {
+			log.Printf("Failed to reload login policy, keeping the previous one: %v", err)
+			return *mailPolicyCache.policy, nil
+		}
+		return MailPolicy{}, err
+	}
+
+	policy := NewMailPolicy(domain, entries)
+	mailPolicyCache.domain = domain
+	mailPolicyCache.policy = &policy
+	mailPolicyCache.loadedAt = time.Now()
+
+	return policy, nil
+}
@@ -0,0 +1,104 @@
+package authenticator
+
+import (
+	"aat-manager/db"
+	"aat-manager/utils"
+	"errors"
+	"net/mail"
+	"os"
+	"testing"
+)
+
+func TestMailPolicyCheck(t *testing.T) {
+	policy := NewMailPolicy("Test.com", []db.LoginPolicyEntry{
+		{Kind: db.PolicyDomain, Value: "partner.org"},
+		{Kind: db.PolicyAllow, Value: "Volunteer@Gmail.com"},
+		{Kind: db.PolicyBlock, Value: "ex-staff@test.com"},
+		{Kind: "unknown", Value: "other.com"},
+	})
+
+	tests := []struct {
+		name    string
+		address string
+		want    string
+		wantErr error
+	}{
+		{name: "Authorized domain", address: "User@Test.com", want: "user@test.com"},
+		{name: "Stored domain", address: "user@partner.org", want: "user@partner.org"},
+		{name: "Allowed address", address: "volunteer@gmail.com", want: "volunteer@gmail.com"},
+		{name: "Blocked address", address: "Ex-Staff@test.com", wantErr: ErrBlockedMail},
+		{name: "Other domain", address: "user@gmail.com", wantErr: ErrUnauthorizedDomain},
+		{name: "Unknown kind ignored", address: "user@other.com", wantErr: ErrUnauthorizedDomain},
+		{name: "Malformed mail", address: "user", wantErr: ErrMalformedMail},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := policy.Check(mail.Address{Address: tt.address})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Check() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMailPolicyHostedDomain(t *testing.T) {
+	if got := NewMailPolicy("test.com", nil).HostedDomain(); got != "test.com" {
+		t.Errorf("HostedDomain() = %v, want test.com", got)
+	}
+
+	policy := NewMailPolicy("test.com", []db.LoginPolicyEntry{{Kind: db.PolicyDomain, Value: "partner.org"}})
+	if got := policy.HostedDomain(); got != "*" {
+		t.Errorf("HostedDomain() = %v, want *", got)
+	}
+}
+
+// fakePolicyStore is a MailPolicyStore counting the loads
+type fakePolicyStore struct {
+	entries []db.LoginPolicyEntry
+	err     error
+	loads   int
+}
+
+func (s *fakePolicyStore) ListPolicyEntries() ([]db.LoginPolicyEntry, error) {
+	s.loads++
+	return s.entries, s.err
+}
+
+func TestCurrentMailPolicy(t *testing.T) {
+	os.Setenv(utils.AUTHORIZEDDOMAIN, "test.com")
+	store := &fakePolicyStore{entries: []db.LoginPolicyEntry{{Kind: db.PolicyDomain, Value: "partner.org"}}}
+	UseMailPolicyStore(store)
+	defer UseMailPolicyStore(nil)
+
+	policy, err := CurrentMailPolicy()
+	if err != nil || !policy.Domains["test.com"] || !policy.Domains["partner.org"] {
+		t.Fatalf("CurrentMailPolicy() = %v, %v, want test.com and partner.org domains", policy.Domains, err)
+	}
+
+	// Cached policy is used until invalidated
+	store.entries = nil
+	if policy, _ := CurrentMailPolicy(); !policy.Domains["partner.org"] || store.loads != 1 {
+		t.Errorf("CurrentMailPolicy() reloaded the policy before the refresh, loads = %d", store.loads)
+	}
+	InvalidateMailPolicy()
+	if policy, _ := CurrentMailPolicy(); policy.Domains["partner.org"] || store.loads != 2 {
+		t.Errorf("CurrentMailPolicy() kept the policy after invalidation, loads = %d", store.loads)
+	}
+
+	// A failed reload keeps the previous policy
+	store.err = errors.New("connection refused")
+	InvalidateMailPolicy()
+	if policy, err := CurrentMailPolicy(); err != nil || !policy.Domains["test.com"] {
+		t.Errorf("CurrentMailPolicy() = %v, %v, want the previous policy", policy.Domains, err)
+	}
+
+	// Without a previous policy the failure is returned
+	UseMailPolicyStore(store)
+	if _, err := CurrentMailPolicy(); err == nil {
+		t.Errorf("CurrentMailPolicy() error = nil, want error without a previous policy")
+	}
+}
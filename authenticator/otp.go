@@ -3,43 +3,66 @@ package authenticator
 import (
 	"aat-manager/db"
 	"aat-manager/utils"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"net/mail"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-// GenOtpAndSave generates a one-time password (OTP) and saves it in an in-memory database.
-// The function takes a mail address and an in-memory database as input parameters.
+const (
+	MaxOtpAttempts     = 5                // Failed checks allowed for a pending OTP before it is invalidated
+	otpLockoutDuration = 15 * time.Minute // Time a mailbox is locked after too many failed checks
+	otpBackoffBase     = 15 * time.Second // Wait required before the second OTP request, doubled at every request
+	otpBackoffMax      = 15 * time.Minute // Maximum wait between two OTP requests
+	otpRequestsMemory  = time.Hour        // Time the issued OTP count is remembered after the last request
+	otpAttemptsMemory  = db.DefaultTTL    // Time failed checks are counted, the lifetime of the pending OTP
+)
+
+// Key prefixes used to store the OTP attempt state beside the OTP in the OTP store
+const (
+	otpAttemptsPrefix = "otp-attempts:" // Failed checks for the pending OTP
+	otpLockPrefix     = "otp-lock:"     // Lockout expiration as unix nano
+	otpRequestsPrefix = "otp-requests:" // Issued OTP count and last issue time as "count:unixnano"
+)
+
+// attemptsMux serializes the updates of the attempts state made by this instance.
+// Failed checks are counted with the atomic db.OtpStore.Incr, so the count is exact whatever the number of
+// instances sharing the store. The OTP request backoff is still read and written back: with a shared store,
+// concurrent requests for the same mailbox reaching different instances may each be issued an OTP.
+var attemptsMux sync.Mutex
+
+// GenOtpAndSave generates a one-time password (OTP) and saves its hash in an OTP store.
+// The function takes a mail address and an OTP store as input parameters.
 // It returns the generated OTP on success or an error if any occurs.
 //
-// The function first searches for the last occurrence of "@" in the mail address. If "@" doesn't exist,
+// The function first checks the mail address with CheckMailDomain. If "@" doesn't exist,
 // it returns an error of ErrMalformedMail.
+// If the mail policy refuses the address, it returns an error of ErrUnauthorizedDomain or ErrBlockedMail.
 //
-// It then checks if the domain of the mail address is authorized by comparing it with the value of the environment variable utils.AUTHORIZEDDOMAIN.
-// If the domain is not authorized, it returns an error of ErrUnauthorizedDomain.
+// Before generating a new OTP it checks the mailbox attempt state:
+// if the mailbox is locked after too many failed checks it returns a *RetryError wrapping ErrLockedOut,
+// if a previous OTP was issued too recently it returns a *RetryError wrapping ErrOtpBackoff.
+// The wait between two requests doubles at every request, starting from otpBackoffBase.
 //
 // Next, it generates a new OTP based on the value of the environment variable utils.OTPLENGTH.
 // If the value is not a numeric value, it returns an error of ErrNonNumericValue.
 // The OTP length is determined by converting the environment variable value to an integer.
 // The OTP is generated using the randSecret function.
 //
-// After generating the OTP, the function extracts the user part of the mail address (before the "@" character)
-// and saves the user and OTP pair in the in-memory database using the db.Set method.
-// The user is used as the key and the OTP as the value.
+// After generating the OTP, the function saves the mailbox, that is the lowercase full mail address,
+// and OTP hash pair in the store using the db.Set method, see hashOtp.
+// The mailbox is used as the key and the OTP hash as the value. The failed attempts of any previous OTP are reset.
 //
 // Finally, the function returns the generated OTP and a nil error.
-func GenOtpAndSave(mail mail.Address, db *db.InMemoryDb) (string, error) {
-	// Search for last @ occurrence
-	atIndex := strings.LastIndex(mail.Address, "@")
-	if atIndex == -1 {
-		return "", ErrMalformedMail
-	}
-
-	// Check if the domain is authorized
-	authDomain := utils.ReadEnvOrPanic(utils.AUTHORIZEDDOMAIN)
-	domain := mail.Address[atIndex+1:]
-	if domain != authDomain {
-		return "", ErrUnauthorizedDomain
+func GenOtpAndSave(mail mail.Address, db db.OtpStore) (string, error) {
+	// Check if the address is authorized
+	mailbox, err := CheckMailDomain(mail)
+	if err != nil {
+		return "", err
 	}
 
 	// Generate new otp
@@ -47,41 +70,219 @@ func GenOtpAndSave(mail mail.Address, db *db.InMemoryDb) (string, error) {
 	if err != nil {
 		return "", ErrNonNumericValue
 	}
+
+	attemptsMux.Lock()
+	defer attemptsMux.Unlock()
+
+	// Refuse locked mailbox
+	now := time.Now()
+	wait, err := lockoutLeft(mailbox, db, now)
+	if err != nil {
+		return "", err
+	}
+	if wait > 0 {
+		return "", &RetryError{Err: ErrLockedOut, RetryAfter: wait}
+	}
+
+	// Refuse too frequent requests
+	count, last, err := otpRequests(mailbox, db)
+	if err != nil {
+		return "", err
+	}
+	if count > 0 {
+		if wait := last.Add(otpBackoff(count)).Sub(now); wait > 0 {
+			return "", &RetryError{Err: ErrOtpBackoff, RetryAfter: wait}
+		}
+	}
+
 	otp := randSecret(otpLength)
+	hash, err := hashOtp(mailbox, otp)
+	if err != nil {
+		return "", err
+	}
 
-	// Save user (mailbox from mail) and OTP to in memory db
-	user := mail.Address[:atIndex]
-	db.Set(user, otp)
+	// Save mailbox and OTP hash to store, new OTP starts with no failed attempts
+	db.Set(mailbox, hash)
+	db.Delete(otpAttemptsPrefix + mailbox)
+	db.SetWithTTL(otpRequestsPrefix+mailbox, strconv.Itoa(count+1)+":"+strconv.FormatInt(now.UnixNano(), 10), otpRequestsMemory)
 
 	// Return OTP
 	return otp, nil
 }
 
-// CheckOtpAndDelete checks if the passed OTP is equal to the stored OTP for a given user in the in-memory database
-// deletes the user from the database if the OTP check is passed
-func CheckOtpAndDelete(mail mail.Address, otp int, db *db.InMemoryDb) (bool, error) {
-	// Extract user from mail address
-	// Search for last @ occurrence
-	atIndex := strings.LastIndex(mail.Address, "@")
-	if atIndex == -1 {
-		return false, ErrMalformedMail
+// CheckOtpAndDelete checks if the passed OTP hash is equal to the stored OTP hash for a given mailbox in the OTP store
+// deletes the mailbox from the store if the OTP check is passed.
+// The OTP is consumed with db.OtpStore.CompareAndDelete, so it passes a single check even with a store shared by several instances.
+//
+// A passed check also deletes the magic link sent with the OTP, see GenMagicLinkAndSave.
+//
+// Every failed check is counted against the pending OTP. When MaxOtpAttempts is reached the OTP and its magic link are deleted,
+// the mailbox is locked for otpLockoutDuration and ErrTooManyAttempts is returned.
+// Checks on a locked mailbox return ErrLockedOut, checks of an address blocked since the OTP was sent return ErrBlockedMail.
+func CheckOtpAndDelete(mail mail.Address, otp int, db db.OtpStore) (bool, error) {
+	// Extract mailbox from mail address
+	mailbox, err := mailboxKey(mail)
+	if err != nil {
+		return false, err
+	}
+
+	// Refuse addresses blocked after the OTP was sent
+	if err := CheckNotBlocked(mail); err != nil {
+		return false, err
 	}
-	user := mail.Address[:atIndex]
 
-	// Retrieve user/OTP pair from in memory db and convert to int
-	storedOtp, exist := db.Get(user)
-	if !exist {
-		return false, ErrUserNotFound
+	attemptsMux.Lock()
+	defer attemptsMux.Unlock()
+
+	// Refuse locked mailbox
+	now := time.Now()
+	wait, err := lockoutLeft(mailbox, db, now)
+	if err != nil {
+		return false, err
+	}
+	if wait > 0 {
+		return false, ErrLockedOut
 	}
-	storedIntOtp, err := strconv.Atoi(storedOtp)
+
+	// Hash passed OTP and consume the stored one if it is equal, in a single store operation
+	// so concurrent checks of the same OTP, on any instance, can't all pass
+	hash, err := hashOtp(mailbox, strconv.Itoa(otp))
 	if err != nil {
-		return false, ErrNonNumericValue
+		return false, err
+	}
+	consumed, err := db.CompareAndDelete(mailbox, hash)
+	if err != nil {
+		return false, err
 	}
 
-	// Check if passed OTP is equal to stored one
-	// Return if check is passed
-	if otp == storedIntOtp {
+	// Return if check is passed and clear attempts state
+	if consumed {
+		db.Delete(otpAttemptsPrefix + mailbox)
+		db.Delete(otpRequestsPrefix + mailbox)
+		db.Delete(magicLinkPrefix + mailbox)
 		return true, nil
 	}
+
+	// Tell a missing OTP from a wrong one
+	_, exist, err := db.Get(mailbox)
+	if err != nil {
+		return false, err
+	}
+	if !exist {
+		return false, ErrUserNotFound
+	}
+
+	// Count failed attempt and invalidate OTP when limit is reached
+	attempts, err := db.Incr(otpAttemptsPrefix+mailbox, 1, otpAttemptsMemory)
+	if err != nil {
+		return false, err
+	}
+	if attempts >= MaxOtpAttempts {
+		db.Delete(mailbox)
+		db.Delete(otpAttemptsPrefix + mailbox)
+		db.Delete(magicLinkPrefix + mailbox)
+		db.SetWithTTL(otpLockPrefix+mailbox, strconv.FormatInt(now.Add(otpLockoutDuration).UnixNano(), 10), otpLockoutDuration)
+		return false, ErrTooManyAttempts
+	}
+
 	return false, nil
 }
+
+// Purposes of the keys derived from the OTP secret, see deriveOtpKey
+const (
+	otpHashPurpose   = "otp-hash"
+	magicLinkPurpose = "magic-link"
+)
+
+// deriveOtpKey returns the HMAC-SHA256 key of the purpose derived from the utils.OTPSECRET env variable,
+// so the OTP hashes and the magic links use keys of their own, apart from the JWT signing keys.
+func deriveOtpKey(purpose string) ([]byte, error) {
+	secret := utils.ReadEnvOrPanic(utils.OTPSECRET)
+	if secret == "" {
+		return nil, ErrBlankSecret
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil), nil
+}
+
+// hashOtp returns the hex encoded HMAC-SHA256 of the mailbox OTP, keyed with a key derived from the OTP secret.
+// The OTP is hashed in its numeric form, so codes with leading zeroes match the integer read from the form.
+// Keying the hash makes a dump of the store useless without the secret, despite the small OTP space.
+func hashOtp(mailbox string, otp string) (string, error) {
+	n, err := strconv.Atoi(otp)
+	if err != nil {
+		return "", ErrNonNumericValue
+	}
+
+	key, err := deriveOtpKey(otpHashPurpose)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(mailbox + ":" + strconv.Itoa(n)))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// lockoutLeft returns the remaining lockout time for the mailbox, or 0 if the mailbox isn't locked.
+// Store errors are returned, so the lockout fails closed.
+func lockoutLeft(mailbox string, db db.OtpStore, now time.Time) (time.Duration, error) {
+	value, err := getOrEmpty(db, otpLockPrefix+mailbox)
+	if err != nil {
+		return 0, err
+	}
+	until, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, nil
+	}
+
+	left := time.Unix(0, until).Sub(now)
+	if left < 0 {
+		return 0, nil
+	}
+	return left, nil
+}
+
+// otpRequests returns how many OTP have been issued to the mailbox and when the last one was issued.
+// It returns a zero count if no OTP was recently issued, and the store errors so the backoff fails closed.
+func otpRequests(mailbox string, db db.OtpStore) (int, time.Time, error) {
+	value, err := getOrEmpty(db, otpRequestsPrefix+mailbox)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	count, last, found := strings.Cut(value, ":")
+	if !found {
+		return 0, time.Time{}, nil
+	}
+
+	c, err := strconv.Atoi(count)
+	if err != nil {
+		return 0, time.Time{}, nil
+	}
+	l, err := strconv.ParseInt(last, 10, 64)
+	if err != nil {
+		return 0, time.Time{}, nil
+	}
+
+	return c, time.Unix(0, l), nil
+}
+
+// otpBackoff returns the wait required after the count-th OTP request before issuing a new one.
+func otpBackoff(count int) time.Duration {
+	wait := otpBackoffBase
+	for i := 1; i < count; i++ {
+		wait *= 2
+		if wait >= otpBackoffMax {
+			return otpBackoffMax
+		}
+	}
+	return wait
+}
+
+// getOrEmpty reads a key from the store, missing keys are returned as empty string.
+func getOrEmpty(db db.OtpStore, key string) (string, error) {
+	value, _, err := db.Get(key)
+	return value, err
+}
@@ -2,9 +2,12 @@ package authenticator
 
 import (
 	"aat-manager/db"
+	"aat-manager/utils"
 	"errors"
 	"net/mail"
+	"os"
 	"testing"
+	"time"
 )
 
 func TestCheckOtpAndDelete(t *testing.T) {
@@ -20,7 +23,7 @@ func TestCheckOtpAndDelete(t *testing.T) {
 			name:    "valid OTP",
 			email:   mail.Address{Address: "user1@test.com"},
 			otp:     1234,
-			setup:   func(d *db.InMemoryDb) { d.Set("user1", "1234") },
+			setup:   func(d *db.InMemoryDb) { d.Set("user1@test.com", mustHashOtp("user1@test.com", "1234")) },
 			want:    true,
 			wantErr: nil,
 		},
@@ -44,20 +47,47 @@ func TestCheckOtpAndDelete(t *testing.T) {
 			name:    "invalid OTP",
 			email:   mail.Address{Address: "user3@test.com"},
 			otp:     1234,
-			setup:   func(d *db.InMemoryDb) { d.Set("user3", "5678") },
+			setup:   func(d *db.InMemoryDb) { d.Set("user3@test.com", mustHashOtp("user3@test.com", "5678")) },
 			want:    false,
 			wantErr: nil,
 		},
 		{
-			name:    "non-numeric stored OTP",
+			name:    "plain text stored OTP",
 			email:   mail.Address{Address: "user4@test.com"},
 			otp:     1234,
-			setup:   func(d *db.InMemoryDb) { d.Set("user4", "abcd") },
+			setup:   func(d *db.InMemoryDb) { d.Set("user4@test.com", "1234") },
 			want:    false,
-			wantErr: ErrNonNumericValue,
+			wantErr: nil,
+		},
+		{
+			name:    "leading zero OTP",
+			email:   mail.Address{Address: "user5@test.com"},
+			otp:     123,
+			setup:   func(d *db.InMemoryDb) { d.Set("user5@test.com", mustHashOtp("user5@test.com", "0123")) },
+			want:    true,
+			wantErr: nil,
+		},
+		{
+			name:    "mixed case email",
+			email:   mail.Address{Address: "User6@Test.com"},
+			otp:     1234,
+			setup:   func(d *db.InMemoryDb) { d.Set("user6@test.com", mustHashOtp("user6@test.com", "1234")) },
+			want:    true,
+			wantErr: nil,
+		},
+		{
+			name:    "same user of another domain",
+			email:   mail.Address{Address: "user7@other.com"},
+			otp:     1234,
+			setup:   func(d *db.InMemoryDb) { d.Set("user7@test.com", mustHashOtp("user7@test.com", "1234")) },
+			want:    false,
+			wantErr: ErrUserNotFound,
 		},
 	}
 
+	os.Setenv(utils.OTPSECRET, "test_secret")
+	os.Setenv(utils.AUTHORIZEDDOMAIN, "test.com")
+
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
 			memoryDb := db.NewDB()
@@ -75,3 +105,173 @@ func TestCheckOtpAndDelete(t *testing.T) {
 		})
 	}
 }
+
+// mustHashOtp hashes the OTP as GenOtpAndSave does, with the test secret.
+func mustHashOtp(mailbox string, otp string) string {
+	os.Setenv(utils.OTPSECRET, "test_secret")
+	hash, err := hashOtp(mailbox, otp)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
+
+func TestHashOtp(t *testing.T) {
+	os.Setenv(utils.OTPSECRET, "test_secret")
+
+	hash, err := hashOtp("user", "123456")
+	if err != nil {
+		t.Fatalf("hashOtp() error = %v", err)
+	}
+	if hash == "123456" || len(hash) != 64 {
+		t.Errorf("hashOtp() = %v, want hex encoded HMAC-SHA256", hash)
+	}
+
+	// Same code for another user must not give the same hash
+	other, _ := hashOtp("other", "123456")
+	if other == hash {
+		t.Errorf("hashOtp() gives the same hash for different users")
+	}
+
+	if _, err := hashOtp("user", "abcd"); !errors.Is(err, ErrNonNumericValue) {
+		t.Errorf("hashOtp() error = %v, want %v", err, ErrNonNumericValue)
+	}
+
+	// The legacy JWT secret can be retired without affecting the OTP hashes
+	os.Setenv(utils.JWTSECRET, "")
+	defer os.Setenv(utils.JWTSECRET, "test_secret")
+	if same, err := hashOtp("user", "123456"); err != nil || same != hash {
+		t.Errorf("hashOtp() with blank JWT secret = %v, %v, want %v", same, err, hash)
+	}
+
+	os.Setenv(utils.OTPSECRET, "")
+	defer os.Setenv(utils.OTPSECRET, "test_secret")
+	if _, err := hashOtp("user", "123456"); !errors.Is(err, ErrBlankSecret) {
+		t.Errorf("hashOtp() error = %v, want %v", err, ErrBlankSecret)
+	}
+}
+
+func TestCheckOtpAndDeleteLockout(t *testing.T) {
+	memoryDb := db.NewDB()
+	email := mail.Address{Address: "locked@test.com"}
+	memoryDb.Set("locked@test.com", mustHashOtp("locked@test.com", "1234"))
+
+	// Every failed check before the limit keeps the OTP pending
+	for i := 1; i < MaxOtpAttempts; i++ {
+		valid, err := CheckOtpAndDelete(email, 9999, memoryDb)
+		if valid || err != nil {
+			t.Fatalf("attempt %d: CheckOtpAndDelete() = %v, %v, want false, nil", i, valid, err)
+		}
+	}
+
+	// Last allowed failure invalidates the OTP
+	valid, err := CheckOtpAndDelete(email, 9999, memoryDb)
+	if valid || !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("CheckOtpAndDelete() = %v, %v, want false, %v", valid, err, ErrTooManyAttempts)
+	}
+	if _, exist, _ := memoryDb.Get("locked@test.com"); exist {
+		t.Errorf("OTP should have been deleted after %d failed attempts", MaxOtpAttempts)
+	}
+
+	// Right OTP is refused while locked
+	valid, err = CheckOtpAndDelete(email, 1234, memoryDb)
+	if valid || !errors.Is(err, ErrLockedOut) {
+		t.Errorf("CheckOtpAndDelete() = %v, %v, want false, %v", valid, err, ErrLockedOut)
+	}
+}
+
+func TestCheckOtpAndDeleteBlocked(t *testing.T) {
+	os.Setenv(utils.AUTHORIZEDDOMAIN, "test.com")
+	UseMailPolicyStore(&fakePolicyStore{entries: []db.LoginPolicyEntry{{Kind: db.PolicyBlock, Value: "blocked@test.com"}}})
+	defer UseMailPolicyStore(nil)
+
+	memoryDb := db.NewDB()
+	email := mail.Address{Address: "Blocked@test.com"}
+	memoryDb.Set("blocked@test.com", mustHashOtp("blocked@test.com", "1234"))
+
+	// The address is blocked after the OTP was sent
+	valid, err := CheckOtpAndDelete(email, 1234, memoryDb)
+	if valid || !errors.Is(err, ErrBlockedMail) {
+		t.Errorf("CheckOtpAndDelete() = %v, %v, want false, %v", valid, err, ErrBlockedMail)
+	}
+}
+
+func TestCheckOtpAndDeleteClearsOtp(t *testing.T) {
+	memoryDb := db.NewDB()
+	email := mail.Address{Address: "once@test.com"}
+	memoryDb.Set("once@test.com", mustHashOtp("once@test.com", "1234"))
+
+	if valid, err := CheckOtpAndDelete(email, 1234, memoryDb); !valid || err != nil {
+		t.Fatalf("CheckOtpAndDelete() = %v, %v, want true, nil", valid, err)
+	}
+
+	// OTP can't be used twice
+	if _, err := CheckOtpAndDelete(email, 1234, memoryDb); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("CheckOtpAndDelete() error = %v, want %v", err, ErrUserNotFound)
+	}
+}
+
+func TestGenOtpAndSaveLimits(t *testing.T) {
+	os.Setenv(utils.AUTHORIZEDDOMAIN, "test.com")
+	os.Setenv(utils.OTPLENGTH, "6")
+	os.Setenv(utils.OTPSECRET, "test_secret")
+
+	t.Run("backoff on repeated requests", func(t *testing.T) {
+		memoryDb := db.NewDB()
+		email := mail.Address{Address: "backoff@test.com"}
+
+		otp, err := GenOtpAndSave(email, memoryDb)
+		if err != nil || len(otp) != 6 {
+			t.Fatalf("GenOtpAndSave() = %v, %v, want 6 digits OTP", otp, err)
+		}
+
+		_, err = GenOtpAndSave(email, memoryDb)
+		var retryErr *RetryError
+		if !errors.As(err, &retryErr) || !errors.Is(err, ErrOtpBackoff) {
+			t.Fatalf("GenOtpAndSave() error = %v, want %v", err, ErrOtpBackoff)
+		}
+		if retryErr.RetryAfter <= 0 || retryErr.RetryAfter > otpBackoffBase {
+			t.Errorf("RetryAfter = %v, want in (0, %v]", retryErr.RetryAfter, otpBackoffBase)
+		}
+	})
+
+	t.Run("locked mailbox", func(t *testing.T) {
+		memoryDb := db.NewDB()
+		email := mail.Address{Address: "lockgen@test.com"}
+		memoryDb.Set("lockgen@test.com", mustHashOtp("lockgen@test.com", "1234"))
+
+		for i := 0; i < MaxOtpAttempts; i++ {
+			_, _ = CheckOtpAndDelete(email, 9999, memoryDb)
+		}
+
+		_, err := GenOtpAndSave(email, memoryDb)
+		if !errors.Is(err, ErrLockedOut) {
+			t.Errorf("GenOtpAndSave() error = %v, want %v", err, ErrLockedOut)
+		}
+	})
+
+	t.Run("unauthorized domain", func(t *testing.T) {
+		_, err := GenOtpAndSave(mail.Address{Address: "user@other.com"}, db.NewDB())
+		if !errors.Is(err, ErrUnauthorizedDomain) {
+			t.Errorf("GenOtpAndSave() error = %v, want %v", err, ErrUnauthorizedDomain)
+		}
+	})
+}
+
+func TestOtpBackoff(t *testing.T) {
+	tests := []struct {
+		count int
+		want  time.Duration
+	}{
+		{count: 1, want: otpBackoffBase},
+		{count: 2, want: 2 * otpBackoffBase},
+		{count: 3, want: 4 * otpBackoffBase},
+		{count: 10, want: otpBackoffMax},
+	}
+
+	for _, tt := range tests {
+		if got := otpBackoff(tt.count); got != tt.want {
+			t.Errorf("otpBackoff(%d) = %v, want %v", tt.count, got, tt.want)
+		}
+	}
+}
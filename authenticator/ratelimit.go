@@ -0,0 +1,123 @@
+package authenticator
+
+import (
+	"aat-manager/db"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrRateLimited      = errors.New("too many requests")
+	ErrMalformedLimiter = errors.New("malformed rate limit, want <count>/<window> like 5/1h")
+)
+
+// rateLimitPrefix is the key prefix of the rate limiter counters in the OTP store
+const rateLimitPrefix = "ratelimit:"
+
+// RateLimiter allows up to Limit requests per key in any Window long period.
+// It is a sliding window counter: requests are counted in fixed windows and the count of the previous window
+// is weighted by its overlap with the sliding window. Counters are kept in the OTP store and updated with
+// the atomic db.OtpStore.Incr, so the limit is shared by all instances when the Postgres store is used.
+type RateLimiter struct {
+	Name   string        // Limiter name, namespaces its keys in the store
+	Limit  int           // Requests allowed per window
+	Window time.Duration // Window length
+
+	store db.OtpStore
+	now   func() time.Time
+}
+
+// NewRateLimiter creates a rate limiter keeping its counters in the given store.
+func NewRateLimiter(name string, limit int, window time.Duration, store db.OtpStore) *RateLimiter {
+	return &RateLimiter{
+		Name:   name,
+		Limit:  limit,
+		Window: window,
+		store:  store,
+		now:    time.Now,
+	}
+}
+
+// ParseRateLimit parses a rate limit in the <count>/<window> form, like 5/1h or 20/15m.
+func ParseRateLimit(s string) (int, time.Duration, error) {
+	count, window, found := strings.Cut(strings.TrimSpace(s), "/")
+	if !found {
+		return 0, 0, ErrMalformedLimiter
+	}
+
+	limit, err := strconv.Atoi(count)
+	if err != nil || limit <= 0 {
+		return 0, 0, ErrMalformedLimiter
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return 0, 0, ErrMalformedLimiter
+	}
+
+	return limit, d, nil
+}
+
+// Allow counts a request for the key if it is within the limit.
+// Refused requests are not counted and return a *RetryError wrapping ErrRateLimited.
+// The request is counted before checking the limit, so concurrent requests on any instance can't all
+// pass the check, and uncounted if refused. Store errors are returned as is.
+func (rl *RateLimiter) Allow(key string) error {
+	now := rl.now()
+	index := now.UnixNano() / int64(rl.Window)
+	elapsed := time.Duration(now.UnixNano() - index*int64(rl.Window))
+
+	counted, err := rl.store.Incr(rl.key(key, index), 1, 2*rl.Window)
+	if err != nil {
+		return err
+	}
+	current := counted - 1 // Requests counted before this one
+	previous, err := rl.count(key, index-1)
+	if err != nil {
+		_, _ = rl.store.Incr(rl.key(key, index), -1, 2*rl.Window)
+		return err
+	}
+
+	// Previous window weighs as much as it overlaps the sliding window
+	weight := 1 - float64(elapsed)/float64(rl.Window)
+	if float64(previous)*weight+float64(current) >= float64(rl.Limit) {
+		if _, err := rl.store.Incr(rl.key(key, index), -1, 2*rl.Window); err != nil {
+			return err
+		}
+		return &RetryError{Err: ErrRateLimited, RetryAfter: rl.retryAfter(previous, current, elapsed)}
+	}
+
+	return nil
+}
+
+// retryAfter returns the time until a request is allowed again, given the window counters.
+func (rl *RateLimiter) retryAfter(previous int, current int, elapsed time.Duration) time.Duration {
+	window := float64(rl.Window)
+
+	// Allowed later in this window, as the previous window overlap shrinks
+	if current < rl.Limit {
+		allowedAt := window * (1 - float64(rl.Limit-current)/float64(previous))
+		return time.Duration(math.Ceil(allowedAt)) - elapsed + 1
+	}
+
+	// Allowed in the next window, as the current window overlap shrinks
+	allowedAt := window * (1 - float64(rl.Limit)/float64(current))
+	return rl.Window - elapsed + time.Duration(math.Ceil(allowedAt)) + 1
+}
+
+// count returns the requests counted for the key in the window with the given index.
+func (rl *RateLimiter) count(key string, index int64) (int, error) {
+	value, err := getOrEmpty(rl.store, rl.key(key, index))
+	if err != nil {
+		return 0, err
+	}
+	c, _ := strconv.Atoi(value)
+	return c, nil
+}
+
+// key returns the store key of the counter of the key in the window with the given index.
+func (rl *RateLimiter) key(key string, index int64) string {
+	return rateLimitPrefix + rl.Name + ":" + key + ":" + strconv.FormatInt(index, 10)
+}
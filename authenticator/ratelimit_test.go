@@ -0,0 +1,120 @@
+package authenticator
+
+import (
+	"aat-manager/db"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      string
+		wantLimit  int
+		wantWindow time.Duration
+		wantErr    bool
+	}{
+		{name: "Hourly", value: "5/1h", wantLimit: 5, wantWindow: time.Hour},
+		{name: "Spaces", value: " 20/15m ", wantLimit: 20, wantWindow: 15 * time.Minute},
+		{name: "Missing window", value: "5", wantErr: true},
+		{name: "Zero count", value: "0/1h", wantErr: true},
+		{name: "Negative window", value: "5/-1h", wantErr: true},
+		{name: "Non numeric count", value: "five/1h", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, window, err := ParseRateLimit(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRateLimit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if limit != tt.wantLimit || window != tt.wantWindow {
+				t.Errorf("ParseRateLimit() = %v, %v, want %v, %v", limit, window, tt.wantLimit, tt.wantWindow)
+			}
+		})
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	store := db.NewDB()
+	defer store.Close()
+
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	now := start
+	rl := NewRateLimiter("test", 3, time.Hour, store)
+	rl.now = func() time.Time { return now }
+
+	// Up to the limit in the first window
+	for i := 0; i < 3; i++ {
+		if err := rl.Allow("1.2.3.4"); err != nil {
+			t.Fatalf("Allow() request %d error = %v", i+1, err)
+		}
+	}
+
+	var retryErr *RetryError
+	err := rl.Allow("1.2.3.4")
+	if !errors.As(err, &retryErr) || !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Allow() over limit error = %v, want RetryError wrapping %v", err, ErrRateLimited)
+	}
+	// Previous window weighs 3*(1-e/1h) < 3 as soon as the next window starts
+	if retryErr.RetryAfter <= 0 || retryErr.RetryAfter > time.Hour+time.Second {
+		t.Errorf("RetryAfter = %v, want about an hour", retryErr.RetryAfter)
+	}
+
+	// Other keys are counted apart
+	if err := rl.Allow("5.6.7.8"); err != nil {
+		t.Errorf("Allow() other key error = %v", err)
+	}
+
+	// At half of the next window the previous requests weigh 1.5
+	now = start.Add(90 * time.Minute)
+	for i := 0; i < 2; i++ {
+		if err := rl.Allow("1.2.3.4"); err != nil {
+			t.Fatalf("Allow() half window later request %d error = %v", i+1, err)
+		}
+	}
+	err = rl.Allow("1.2.3.4")
+	if !errors.As(err, &retryErr) {
+		t.Fatalf("Allow() over sliding limit error = %v, want RetryError", err)
+	}
+	// 3*(1-e/1h)+2 < 3 once e > 40m, that is in 10 minutes
+	if retryErr.RetryAfter < 9*time.Minute || retryErr.RetryAfter > 11*time.Minute {
+		t.Errorf("RetryAfter = %v, want about 10m", retryErr.RetryAfter)
+	}
+
+	// Retrying after the advertised wait is allowed
+	now = now.Add(retryErr.RetryAfter)
+	if err := rl.Allow("1.2.3.4"); err != nil {
+		t.Errorf("Allow() after RetryAfter error = %v", err)
+	}
+}
+
+func TestRateLimiterAllowConcurrent(t *testing.T) {
+	store := db.NewDB()
+	defer store.Close()
+
+	// Limiters of two instances sharing the store
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	limiters := []*RateLimiter{NewRateLimiter("test", 10, time.Hour, store), NewRateLimiter("test", 10, time.Hour, store)}
+	for _, rl := range limiters {
+		rl.now = func() time.Time { return now }
+	}
+
+	allowed := make(chan bool)
+	for i := 0; i < 50; i++ {
+		go func(rl *RateLimiter) {
+			allowed <- rl.Allow("1.2.3.4") == nil
+		}(limiters[i%2])
+	}
+	count := 0
+	for i := 0; i < 50; i++ {
+		if <-allowed {
+			count++
+		}
+	}
+
+	if count > 10 {
+		t.Errorf("Allow() let %d requests through, want at most 10", count)
+	}
+}
@@ -0,0 +1,76 @@
+package authenticator
+
+import (
+	"aat-manager/utils"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"time"
+)
+
+var ErrMalformedSessionLimit = errors.New("malformed session limit, want a positive duration like 15m or 72h")
+
+// Default session limits, see CurrentSessionLimits
+const (
+	DefaultAccessTokenTTL = "15m"
+	DefaultSessionIdle    = "72h"
+	DefaultSessionMaxAge  = "720h"
+)
+
+// SessionLimits rule the lifetime of the login sessions and of their access tokens.
+// Access tokens are short-lived and renewed while their session is valid, that is
+// used at least once every IdleTimeout and not older than MaxAge.
+type SessionLimits struct {
+	AccessTTL   time.Duration // Lifetime of an access token
+	IdleTimeout time.Duration // Time without requests after which a session expires
+	MaxAge      time.Duration // Absolute session lifetime, whatever the activity
+}
+
+// CurrentSessionLimits returns the limits configured by the JWTACCESSTTL, SESSIONIDLE and SESSIONMAXAGE env variables.
+// It returns ErrMalformedSessionLimit, wrapped with the variable name, for invalid values.
+func CurrentSessionLimits() (SessionLimits, error) {
+	var limits SessionLimits
+	values := []struct {
+		name  string
+		def   string
+		value *time.Duration
+	}{
+		{name: utils.JWTACCESSTTL, def: DefaultAccessTokenTTL, value: &limits.AccessTTL},
+		{name: utils.SESSIONIDLE, def: DefaultSessionIdle, value: &limits.IdleTimeout},
+		{name: utils.SESSIONMAXAGE, def: DefaultSessionMaxAge, value: &limits.MaxAge},
+	}
+
+	for _, v := range values {
+		d, err := time.ParseDuration(utils.ReadEnvOrDefault(v.name, v.def))
+		if err != nil || d <= 0 {
+			return SessionLimits{}, fmt.Errorf("%s: %w", v.name, ErrMalformedSessionLimit)
+		}
+		*v.value = d
+	}
+
+	return limits, nil
+}
+
+// RenewBefore returns how long before its expiration an access token is renewed.
+func (l SessionLimits) RenewBefore() time.Duration {
+	return l.AccessTTL / 2
+}
+
+// NeedsRenewal reports whether an access token expiring at exp should be renewed at now.
+func (l SessionLimits) NeedsRenewal(exp time.Time, now time.Time) bool {
+	return exp.Sub(now) < l.RenewBefore()
+}
+
+// RenewableExpired reports whether an access token expired at exp can still be renewed at now.
+// Expired tokens are renewable for one AccessTTL after their expiration, long enough for clients
+// idle a little more than a token lifetime, while a leaked token can't be renewed for the whole session.
+func (l SessionLimits) RenewableExpired(exp time.Time, now time.Time) bool {
+	return now.Before(exp.Add(l.AccessTTL))
+}
+
+// IsExpiredOnly reports whether err, as returned by KeyRing.Parse, only refuses the token because it is expired.
+// Such tokens are signed by the key ring, so they can still be renewed while their session is valid.
+func IsExpiredOnly(err error) bool {
+	var validationErr *jwt.ValidationError
+	return errors.As(err, &validationErr) && validationErr.Errors == jwt.ValidationErrorExpired
+}
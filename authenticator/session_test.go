@@ -0,0 +1,113 @@
+package authenticator
+
+import (
+	"aat-manager/utils"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCurrentSessionLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    SessionLimits
+		wantErr error
+	}{
+		{
+			name: "Defaults",
+			env:  map[string]string{},
+			want: SessionLimits{AccessTTL: 15 * time.Minute, IdleTimeout: 72 * time.Hour, MaxAge: 720 * time.Hour},
+		},
+		{
+			name: "Configured",
+			env:  map[string]string{utils.JWTACCESSTTL: "5m", utils.SESSIONIDLE: "12h", utils.SESSIONMAXAGE: "168h"},
+			want: SessionLimits{AccessTTL: 5 * time.Minute, IdleTimeout: 12 * time.Hour, MaxAge: 168 * time.Hour},
+		},
+		{
+			name:    "Malformed duration",
+			env:     map[string]string{utils.SESSIONIDLE: "3 days"},
+			wantErr: ErrMalformedSessionLimit,
+		},
+		{
+			name:    "Negative duration",
+			env:     map[string]string{utils.SESSIONMAXAGE: "-1h"},
+			wantErr: ErrMalformedSessionLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{utils.JWTACCESSTTL, utils.SESSIONIDLE, utils.SESSIONMAXAGE} {
+				os.Unsetenv(name)
+			}
+			for name, value := range tt.env {
+				os.Setenv(name, value)
+				defer os.Unsetenv(name)
+			}
+
+			got, err := CurrentSessionLimits()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CurrentSessionLimits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("CurrentSessionLimits() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionLimitsNeedsRenewal(t *testing.T) {
+	limits := SessionLimits{AccessTTL: 10 * time.Minute}
+	now := time.Now()
+
+	if limits.NeedsRenewal(now.Add(8*time.Minute), now) {
+		t.Errorf("NeedsRenewal() = true for a fresh token")
+	}
+	if !limits.NeedsRenewal(now.Add(2*time.Minute), now) {
+		t.Errorf("NeedsRenewal() = false for a token near expiry")
+	}
+	if !limits.NeedsRenewal(now.Add(-time.Minute), now) {
+		t.Errorf("NeedsRenewal() = false for an expired token")
+	}
+}
+
+func TestSessionLimitsRenewableExpired(t *testing.T) {
+	limits := SessionLimits{AccessTTL: 10 * time.Minute}
+	now := time.Now()
+
+	if !limits.RenewableExpired(now.Add(-time.Minute), now) {
+		t.Errorf("RenewableExpired() = false for a token expired within the grace window")
+	}
+	if limits.RenewableExpired(now.Add(-11*time.Minute), now) {
+		t.Errorf("RenewableExpired() = true for a token expired before the grace window")
+	}
+}
+
+func TestIsExpiredOnly(t *testing.T) {
+	ring, err := NewKeyRing("", "", "test_secret")
+	if err != nil {
+		t.Fatalf("NewKeyRing() error = %v", err)
+	}
+	sign := func(exp time.Time) string {
+		signed, err := ring.Sign(jwt.MapClaims{"exp": exp.Unix()})
+		if err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		return signed
+	}
+
+	if _, err := ring.Parse(sign(time.Now().Add(-time.Minute))); !IsExpiredOnly(err) {
+		t.Errorf("IsExpiredOnly() = false for an expired token, error = %v", err)
+	}
+
+	other, _ := NewKeyRing("", "", "other_secret")
+	if _, err := other.Parse(sign(time.Now().Add(-time.Minute))); IsExpiredOnly(err) {
+		t.Errorf("IsExpiredOnly() = true for an expired token with a wrong signature")
+	}
+	if IsExpiredOnly(nil) {
+		t.Errorf("IsExpiredOnly(nil) = true")
+	}
+}
@@ -0,0 +1,165 @@
+package authenticator
+
+import (
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformedTotpSecret = errors.New("malformed totp secret")
+	ErrInvalidTotpCode     = errors.New("invalid totp code")
+)
+
+const (
+	TotpIssuer        = "AAT Manager"    // Issuer shown by authenticator apps
+	TotpDigits        = 6                // Digits of a TOTP code
+	TotpPeriod        = 30 * time.Second // Time step of a TOTP code
+	totpSkew          = 1                // Time steps accepted before and after the current one, for clock drift
+	totpSecretBytes   = 20               // Secret length, as the HMAC-SHA1 output suggested by RFC 4226
+	RecoveryCodeCount = 10               // Recovery codes issued at enrollment
+	recoveryCodeChars = 10               // Characters of a recovery code, dash excluded
+)
+
+// totpEncoding is the base32 encoding of TOTP secrets, without padding as expected by authenticator apps
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTotpSecret returns a new random base32 encoded TOTP secret.
+func GenerateTotpSecret() (string, error) {
+	b := make([]byte, totpSecretBytes)
+	if _, err := cryptorand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TotpURI returns the otpauth:// URI of the secret, to be shown as QR code to the authenticator app.
+func TotpURI(secret string, account string) string {
+	label := url.PathEscape(TotpIssuer + ":" + account)
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {TotpIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(TotpDigits)},
+		"period":    {fmt.Sprint(int(TotpPeriod.Seconds()))},
+	}
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// TotpCode returns the TOTP code of the secret at the given time, as defined by RFC 6238 with HMAC-SHA1.
+func TotpCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTotpSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, totpCounter(t), TotpDigits), nil
+}
+
+// ValidateTotp checks the code against the codes of the secret around the given time, see totpSkew.
+// It returns the time step counter of the matching code, which must be greater than the one
+// of the last accepted code to refuse replays, or ErrInvalidTotpCode.
+func ValidateTotp(secret string, code string, t time.Time) (int64, error) {
+	key, err := decodeTotpSecret(secret)
+	if err != nil {
+		return 0, err
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) != TotpDigits {
+		return 0, ErrInvalidTotpCode
+	}
+
+	current := totpCounter(t)
+	for i := -totpSkew; i <= totpSkew; i++ {
+		counter := current + uint64(i)
+		if hmac.Equal([]byte(hotp(key, counter, TotpDigits)), []byte(code)) {
+			return int64(counter), nil
+		}
+	}
+
+	return 0, ErrInvalidTotpCode
+}
+
+// IsTotpCode reports whether the code has the form of a TOTP code, rather than of a recovery code.
+func IsTotpCode(code string) bool {
+	code = strings.TrimSpace(code)
+	if len(code) != TotpDigits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// GenerateRecoveryCodes returns RecoveryCodeCount new recovery codes, formatted as xxxxx-xxxxx, and their hashes.
+// Codes are shown once to the user, only the hashes are stored, see HashRecoveryCode.
+func GenerateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	hashes := make([]string, 0, RecoveryCodeCount)
+
+	for i := 0; i < RecoveryCodeCount; i++ {
+		b := make([]byte, recoveryCodeChars*5/8)
+		if _, err := cryptorand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))
+		code = code[:recoveryCodeChars/2] + "-" + code[recoveryCodeChars/2:]
+
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode returns the hex encoded SHA-256 of the recovery code, ignoring case, spaces and dashes.
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// decodeTotpSecret decodes a base32 secret, ignoring case and padding.
+func decodeTotpSecret(secret string) ([]byte, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil || len(key) == 0 {
+		return nil, ErrMalformedTotpSecret
+	}
+	return key, nil
+}
+
+// totpCounter returns the RFC 6238 time step counter at the given time.
+func totpCounter(t time.Time) uint64 {
+	return uint64(t.Unix() / int64(TotpPeriod.Seconds()))
+}
+
+// hotp returns the RFC 4226 HOTP code of the key at the given counter, with HMAC-SHA1 and dynamic truncation.
+func hotp(key []byte, counter uint64, digits int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
@@ -0,0 +1,122 @@
+package authenticator
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 seed of the RFC 6238 test vectors, "12345678901234567890" in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestHotpRFC6238Vectors(t *testing.T) {
+	key, err := decodeTotpSecret(rfc6238Secret)
+	if err != nil {
+		t.Fatalf("decodeTotpSecret() error = %v", err)
+	}
+
+	// RFC 6238 appendix B, SHA1 mode with 8 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "94287082"},
+		{unix: 1111111109, want: "07081804"},
+		{unix: 1111111111, want: "14050471"},
+		{unix: 1234567890, want: "89005924"},
+		{unix: 2000000000, want: "69279037"},
+		{unix: 20000000000, want: "65353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := hotp(key, totpCounter(time.Unix(tt.unix, 0)), 8); got != tt.want {
+				t.Errorf("hotp() at %d = %v, want %v", tt.unix, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTotp(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, err := TotpCode(rfc6238Secret, now)
+	if err != nil {
+		t.Fatalf("TotpCode() error = %v", err)
+	}
+	if code != "050471" {
+		t.Fatalf("TotpCode() = %v, want last 6 digits of the RFC vector", code)
+	}
+
+	tests := []struct {
+		name    string
+		secret  string
+		code    string
+		at      time.Time
+		wantErr error
+	}{
+		{name: "Current step", secret: rfc6238Secret, code: code, at: now},
+		{name: "Previous step", secret: rfc6238Secret, code: code, at: now.Add(TotpPeriod)},
+		{name: "Next step", secret: rfc6238Secret, code: code, at: now.Add(-TotpPeriod)},
+		{name: "Expired", secret: rfc6238Secret, code: code, at: now.Add(3 * TotpPeriod), wantErr: ErrInvalidTotpCode},
+		{name: "Wrong code", secret: rfc6238Secret, code: "000000", at: now, wantErr: ErrInvalidTotpCode},
+		{name: "Short code", secret: rfc6238Secret, code: "0504", at: now, wantErr: ErrInvalidTotpCode},
+		{name: "Lowercase secret", secret: strings.ToLower(rfc6238Secret), code: code, at: now},
+		{name: "Malformed secret", secret: "not base32!", code: code, at: now, wantErr: ErrMalformedTotpSecret},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, err := ValidateTotp(tt.secret, tt.code, tt.at)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ValidateTotp() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && counter != int64(totpCounter(now)) {
+				t.Errorf("ValidateTotp() counter = %v, want %v", counter, totpCounter(now))
+			}
+		})
+	}
+}
+
+func TestGenerateTotpSecret(t *testing.T) {
+	secret, err := GenerateTotpSecret()
+	if err != nil {
+		t.Fatalf("GenerateTotpSecret() error = %v", err)
+	}
+	key, err := decodeTotpSecret(secret)
+	if err != nil || len(key) != totpSecretBytes {
+		t.Errorf("GenerateTotpSecret() = %v, want %d bytes base32 secret", secret, totpSecretBytes)
+	}
+
+	uri := TotpURI(secret, "user@test.com")
+	if !strings.HasPrefix(uri, "otpauth://totp/AAT%20Manager:user@test.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("TotpURI() = %v", uri)
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes() error = %v", err)
+	}
+	if len(codes) != RecoveryCodeCount || len(hashes) != RecoveryCodeCount {
+		t.Fatalf("GenerateRecoveryCodes() returned %d codes and %d hashes, want %d", len(codes), len(hashes), RecoveryCodeCount)
+	}
+
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		if len(code) != recoveryCodeChars+1 || code[recoveryCodeChars/2] != '-' || IsTotpCode(code) {
+			t.Errorf("recovery code %q is not formatted as xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("recovery code %q is duplicated", code)
+		}
+		seen[code] = true
+
+		// Users may type the code in upper case or without dash
+		typed := strings.ToUpper(strings.Replace(code, "-", " ", 1))
+		if HashRecoveryCode(typed) != hashes[i] {
+			t.Errorf("HashRecoveryCode(%q) doesn't match the hash of %q", typed, code)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"aat-manager/authenticator"
+	"aat-manager/db"
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+)
+
+var errUsage = errors.New(`usage:
+  aat-manager                      start the server
+  aat-manager migrate [up]         apply the pending schema migrations
+  aat-manager migrate down [n]     revert the last n applied migrations, default 1
+  aat-manager migrate status       list the migrations and when they were applied
+  aat-manager rotate-keys          re-encrypt the stored secrets with the active AES key
+  aat-manager promote <email>      give the manager role to the user, to bootstrap the first manager`)
+
+// runCommand runs the command line subcommand in args, without starting the server.
+func runCommand(args []string) error {
+	switch args[0] {
+	case "migrate":
+		return runMigrate(args[1:])
+	case "rotate-keys":
+		return runRotateKeys(args[1:])
+	case "promote":
+		return runPromote(args[1:])
+	default:
+		return errUsage
+	}
+}
+
+// runMigrate runs the migrate subcommand, only the POSTGRESCONNSTRING env variable is required.
+func runMigrate(args []string) error {
+	ctx := context.Background()
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch action {
+	case "up":
+		applied, err := db.Migrations{}.MigrateUp(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d migrations applied\n", len(applied))
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return errUsage
+			}
+			steps = n
+		}
+		reverted, err := db.Migrations{}.MigrateDown(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d migrations reverted\n", len(reverted))
+	case "status":
+		status, err := db.Migrations{}.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range status {
+			applied := "pending"
+			if migration.AppliedAt != nil {
+				applied = "applied " + migration.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d %-24s %s\n", migration.Version, migration.Name, applied)
+		}
+	default:
+		return errUsage
+	}
+
+	return nil
+}
+
+// runRotateKeys runs the rotate-keys subcommand, the POSTGRESCONNSTRING and AES env variables are required.
+func runRotateKeys(args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+
+	rotation, err := db.Token{}.RotateKeys(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Re-encrypted with key %q: %d tokens, %d token versions, %d TOTP secrets\n",
+		rotation.ActiveKeyID, rotation.Tokens, rotation.TokenHistory, rotation.TotpSecrets)
+
+	return nil
+}
+
+// runPromote runs the promote subcommand, only the POSTGRESCONNSTRING env variable is required.
+// It gives the manager role to the user, registering it if it never logged in, so the first manager can be
+// created without the admin API, which requires a manager.
+func runPromote(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	addr, err := mail.ParseAddress(args[0])
+	if err != nil {
+		return err
+	}
+
+	email := strings.ToLower(addr.Address)
+	if err := (db.Users{}).SetRole(email, authenticator.RoleManager); err != nil {
+		return err
+	}
+	fmt.Printf("User %s set to %s\n", email, authenticator.RoleManager)
+
+	return nil
+}
@@ -0,0 +1,293 @@
+package db
+
+import (
+	"aat-manager/utils"
+	"context"
+	"crypto/aes"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Error definition
+var (
+	ErrUnknownAESKeyID     = errors.New("unknown AES key id")
+	ErrMalformedAESKeyRing = errors.New("malformed AES key ring")
+	ErrDuplicateAESKeyID   = errors.New("duplicate AES key id")
+	ErrMissingActiveAESKey = errors.New("active AES key id not set")
+	ErrInvalidAESKey       = errors.New("AES key must be 16, 24 or 32 hex encoded bytes")
+	ErrMalformedCipherText = errors.New("malformed cipher text")
+)
+
+// Key ID of the key built from AESSECRET
+const LegacyAESKeyID = "default"
+
+// cipherTextSeparator separates the key ID from the hex encoded cipher text, see AESKeyRing.Encrypt
+const cipherTextSeparator = ":"
+
+// AESKeyRing holds the keys used to encrypt the stored secrets.
+// Secrets are encrypted with the active key only, prefixed with its ID, but are decrypted with any key of the ring,
+// so a key can be retired once the secrets are re-encrypted with the rotate-keys command, see Token.RotateKeys.
+type AESKeyRing struct {
+	keys   map[string][]byte
+	active string
+}
+
+// aesKeyConfig is a key of the AESKEYS env variable
+type aesKeyConfig struct {
+	ID  string `json:"kid"` // Key ID
+	Key string `json:"key"` // Hex encoded AES-128, AES-192 or AES-256 key
+}
+
+// NewAESKeyRing builds a key ring from the JSON array of keys and the ID of the active one.
+// The legacy hex key, if not blank, is added with LegacyAESKeyID, and it also decrypts cipher texts without key ID.
+// With no keys the legacy key is the active key.
+//
+// Example keys JSON:
+//
+//	[{"kid":"2024-01","key":"<64 hex chars>"},{"kid":"2024-06","key":"<64 hex chars>"}]
+func NewAESKeyRing(keysJSON string, activeID string, legacyKey string) (*AESKeyRing, error) {
+	ring := &AESKeyRing{
+		keys: make(map[string][]byte),
+	}
+
+	configs := make([]aesKeyConfig, 0)
+	if legacyKey != "" {
+		configs = append(configs, aesKeyConfig{ID: LegacyAESKeyID, Key: legacyKey})
+	}
+	if keysJSON != "" {
+		var ringConfigs []aesKeyConfig
+		if err := json.Unmarshal([]byte(keysJSON), &ringConfigs); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedAESKeyRing, err)
+		}
+		configs = append(configs, ringConfigs...)
+	}
+
+	for _, config := range configs {
+		if config.ID == "" || strings.Contains(config.ID, cipherTextSeparator) {
+			return nil, fmt.Errorf("key %q: %w", config.ID, ErrMalformedAESKeyRing)
+		}
+		if _, exists := ring.keys[config.ID]; exists {
+			return nil, fmt.Errorf("key %q: %w", config.ID, ErrDuplicateAESKeyID)
+		}
+		key, err := hex.DecodeString(config.Key)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", config.ID, ErrInvalidAESKey)
+		}
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, fmt.Errorf("key %q: %w", config.ID, ErrInvalidAESKey)
+		}
+		ring.keys[config.ID] = key
+	}
+
+	// Without a key ring the legacy key is used for encryption
+	if keysJSON == "" {
+		if legacyKey == "" {
+			return nil, ErrMissingActiveAESKey
+		}
+		ring.active = LegacyAESKeyID
+		return ring, nil
+	}
+
+	if activeID == "" {
+		return nil, ErrMissingActiveAESKey
+	}
+	if _, ok := ring.keys[activeID]; !ok {
+		return nil, fmt.Errorf("active key %q: %w", activeID, ErrUnknownAESKeyID)
+	}
+	ring.active = activeID
+
+	return ring, nil
+}
+
+// ActiveKeyID returns the ID of the key used for encryption.
+func (r *AESKeyRing) ActiveKeyID() string {
+	return r.active
+}
+
+// Encrypt encrypts the plain text with the active key, bound to the additional data aad, like the token name,
+// so the cipher text can't be swapped with the one of another record.
+// The cipher text is the key ID and the hex encoded nonce and sealed text, separated by ":".
+func (r *AESKeyRing) Encrypt(plainText string, aad string) (string, error) {
+	cipherText, err := encryptToken(plainText, r.keys[r.active], []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return r.active + cipherTextSeparator + cipherText, nil
+}
+
+// Decrypt decrypts a cipher text of Encrypt with the key of its ID, checking it is bound to aad.
+// Cipher texts without key ID were encrypted by the legacy key, without additional data.
+func (r *AESKeyRing) Decrypt(cipherText string, aad string) (string, error) {
+	id, sealed, found := strings.Cut(cipherText, cipherTextSeparator)
+	if !found {
+		key, ok := r.keys[LegacyAESKeyID]
+		if !ok {
+			return "", fmt.Errorf("key %q: %w", LegacyAESKeyID, ErrUnknownAESKeyID)
+		}
+		return decryptToken(cipherText, key, nil)
+	}
+
+	key, ok := r.keys[id]
+	if !ok {
+		return "", fmt.Errorf("key %q: %w", id, ErrUnknownAESKeyID)
+	}
+	return decryptToken(sealed, key, []byte(aad))
+}
+
+// Reencrypt decrypts the cipher text with its key and encrypts it again with the active key, see Encrypt.
+func (r *AESKeyRing) Reencrypt(cipherText string, aad string) (string, error) {
+	plainText, err := r.Decrypt(cipherText, aad)
+	if err != nil {
+		return "", err
+	}
+	return r.Encrypt(plainText, aad)
+}
+
+// aesKeyRingCache holds the key ring built from env, rebuilt only when the env values change
+var aesKeyRingCache struct {
+	mux    sync.Mutex
+	source [3]string
+	ring   *AESKeyRing
+}
+
+// CurrentAESKeyRing returns the key ring configured by the AESKEYS, AESACTIVEKID and AESSECRET env variables.
+func CurrentAESKeyRing() (*AESKeyRing, error) {
+	source := [3]string{
+		utils.ReadEnvOrDefault(utils.AESKEYS, ""),
+		utils.ReadEnvOrDefault(utils.AESACTIVEKID, ""),
+		utils.ReadEnvOrPanic(utils.AESSECRET),
+	}
+
+	aesKeyRingCache.mux.Lock()
+	defer aesKeyRingCache.mux.Unlock()
+
+	if aesKeyRingCache.ring != nil && aesKeyRingCache.source == source {
+		return aesKeyRingCache.ring, nil
+	}
+
+	ring, err := NewAESKeyRing(source[0], source[1], source[2])
+	if err != nil {
+		return nil, err
+	}
+	aesKeyRingCache.source = source
+	aesKeyRingCache.ring = ring
+
+	return ring, nil
+}
+
+// tokenAAD returns the additional data binding a token cipher text to its name
+func tokenAAD(name string) string {
+	return name
+}
+
+// totpAAD returns the additional data binding a TOTP secret cipher text to its user
+func totpAAD(email string) string {
+	return "user_totp:" + email
+}
+
+// KeyRotation counts the secrets re-encrypted by Token.RotateKeys.
+type KeyRotation struct {
+	ActiveKeyID  string
+	Tokens       int // Rows of the tokens table
+	TokenHistory int // Rows of the token_history table
+	TotpSecrets  int // Rows of the user_totp table
+}
+
+// encryptedRow is a row holding a secret, see Token.RotateKeys
+type encryptedRow struct {
+	id    string // Primary key
+	aad   string // Additional data the secret is bound to
+	value string // Cipher text
+}
+
+// RotateKeys re-encrypts every stored secret with the active AES key, in a single transaction:
+// the tokens and their history, bound to the token name, and the TOTP secrets, bound to their user.
+// Once done the keys other than the active one can be removed from AESKEYS.
+func (t Token) RotateKeys(ctx context.Context) (KeyRotation, error) {
+	ring, err := CurrentAESKeyRing()
+	if err != nil {
+		return KeyRotation{}, err
+	}
+
+	tx, err := pgConnect().BeginTx(ctx, nil)
+	if err != nil {
+		return KeyRotation{}, err
+	}
+	defer tx.Rollback()
+
+	res := KeyRotation{ActiveKeyID: ring.ActiveKeyID()}
+	tables := []struct {
+		name   string
+		query  string // Selects id, additional data source and cipher text
+		update string // Sets the cipher text $2 of the row with id $1
+		aad    func(string) string
+		count  *int
+	}{
+		{
+			name:   "tokens",
+			query:  "SELECT name, name, value FROM tokens FOR UPDATE",
+			update: "UPDATE tokens SET value = $2 WHERE name = $1",
+			aad:    tokenAAD,
+			count:  &res.Tokens,
+		},
+		{
+			name:   "token_history",
+			query:  "SELECT id::varchar, name, value FROM token_history FOR UPDATE",
+			update: "UPDATE token_history SET value = $2 WHERE id = $1::bigint",
+			aad:    tokenAAD,
+			count:  &res.TokenHistory,
+		},
+		{
+			name:   "user_totp",
+			query:  "SELECT user_email, user_email, secret FROM user_totp FOR UPDATE",
+			update: "UPDATE user_totp SET secret = $2 WHERE user_email = $1",
+			aad:    totpAAD,
+			count:  &res.TotpSecrets,
+		},
+	}
+
+	for _, table := range tables {
+		rows, err := readEncryptedRows(ctx, tx, table.query)
+		if err != nil {
+			return KeyRotation{}, fmt.Errorf("%s: %w", table.name, err)
+		}
+
+		for _, row := range rows {
+			value, err := ring.Reencrypt(row.value, table.aad(row.aad))
+			if err != nil {
+				return KeyRotation{}, fmt.Errorf("%s %q: %w", table.name, row.id, err)
+			}
+			if _, err := tx.ExecContext(ctx, table.update, row.id, value); err != nil {
+				return KeyRotation{}, fmt.Errorf("%s %q: %w", table.name, row.id, err)
+			}
+		}
+		*table.count = len(rows)
+	}
+
+	return res, tx.Commit()
+}
+
+// readEncryptedRows reads all the rows selected by query, before they are updated in the same transaction.
+func readEncryptedRows(ctx context.Context, tx *sql.Tx, query string) ([]encryptedRow, error) {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]encryptedRow, 0)
+	for rows.Next() {
+		var row encryptedRow
+		if err := rows.Scan(&row.id, &row.aad, &row.value); err != nil {
+			return nil, err
+		}
+		res = append(res, row)
+	}
+
+	return res, rows.Err()
+}
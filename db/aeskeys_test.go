@@ -0,0 +1,146 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// randomAESKey returns a random hex encoded AES-256 key.
+func randomAESKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("rand.Read() error = %v", err)
+	}
+	return hex.EncodeToString(key)
+}
+
+// aesKeysJSON returns the AESKEYS value for the key configurations.
+func aesKeysJSON(t *testing.T, configs ...aesKeyConfig) string {
+	t.Helper()
+	raw, err := json.Marshal(configs)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	return string(raw)
+}
+
+func TestNewAESKeyRing(t *testing.T) {
+	legacy := randomAESKey(t)
+	k1 := aesKeyConfig{ID: "k1", Key: randomAESKey(t)}
+
+	tests := []struct {
+		name     string
+		keys     string
+		activeID string
+		legacy   string
+		wantKid  string
+		wantErr  error
+	}{
+		{name: "Legacy key only", legacy: legacy, wantKid: LegacyAESKeyID},
+		{name: "No keys", wantErr: ErrMissingActiveAESKey},
+		{name: "Key ring", keys: aesKeysJSON(t, k1), activeID: "k1", legacy: legacy, wantKid: "k1"},
+		{name: "Key ring without legacy key", keys: aesKeysJSON(t, k1), activeID: "k1", wantKid: "k1"},
+		{name: "Missing active key", keys: aesKeysJSON(t, k1), legacy: legacy, wantErr: ErrMissingActiveAESKey},
+		{name: "Unknown active key", keys: aesKeysJSON(t, k1), activeID: "other", wantErr: ErrUnknownAESKeyID},
+		{name: "Duplicate key", keys: aesKeysJSON(t, k1, k1), activeID: "k1", wantErr: ErrDuplicateAESKeyID},
+		{name: "Legacy key id", keys: aesKeysJSON(t, aesKeyConfig{ID: LegacyAESKeyID, Key: legacy}), activeID: LegacyAESKeyID, legacy: legacy, wantErr: ErrDuplicateAESKeyID},
+		{name: "Separator in key id", keys: aesKeysJSON(t, aesKeyConfig{ID: "k:1", Key: k1.Key}), activeID: "k:1", wantErr: ErrMalformedAESKeyRing},
+		{name: "Short key", keys: aesKeysJSON(t, aesKeyConfig{ID: "k1", Key: "00ff"}), activeID: "k1", wantErr: ErrInvalidAESKey},
+		{name: "Not hex key", keys: aesKeysJSON(t, aesKeyConfig{ID: "k1", Key: "key"}), activeID: "k1", wantErr: ErrInvalidAESKey},
+		{name: "Malformed JSON", keys: "{", activeID: "k1", wantErr: ErrMalformedAESKeyRing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ring, err := NewAESKeyRing(tt.keys, tt.activeID, tt.legacy)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewAESKeyRing() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && ring.ActiveKeyID() != tt.wantKid {
+				t.Errorf("ActiveKeyID() = %v, want %v", ring.ActiveKeyID(), tt.wantKid)
+			}
+		})
+	}
+}
+
+func TestAESKeyRingRotation(t *testing.T) {
+	legacy := randomAESKey(t)
+	k1 := aesKeyConfig{ID: "k1", Key: randomAESKey(t)}
+	k2 := aesKeyConfig{ID: "k2", Key: randomAESKey(t)}
+
+	oldRing, err := NewAESKeyRing(aesKeysJSON(t, k1), "k1", legacy)
+	if err != nil {
+		t.Fatalf("NewAESKeyRing() error = %v", err)
+	}
+	rotatingRing, err := NewAESKeyRing(aesKeysJSON(t, k1, k2), "k2", legacy)
+	if err != nil {
+		t.Fatalf("NewAESKeyRing() error = %v", err)
+	}
+	newRing, err := NewAESKeyRing(aesKeysJSON(t, k2), "k2", "")
+	if err != nil {
+		t.Fatalf("NewAESKeyRing() error = %v", err)
+	}
+
+	cipherText, err := oldRing.Encrypt("secret", "gtoken")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if !strings.HasPrefix(cipherText, "k1:") {
+		t.Errorf("Encrypt() = %v, want k1 prefix", cipherText)
+	}
+
+	// The retired key can't decrypt, until the cipher text is re-encrypted
+	if _, err := newRing.Decrypt(cipherText, "gtoken"); !errors.Is(err, ErrUnknownAESKeyID) {
+		t.Errorf("Decrypt() with retired key error = %v, want %v", err, ErrUnknownAESKeyID)
+	}
+	rotated, err := rotatingRing.Reencrypt(cipherText, "gtoken")
+	if err != nil {
+		t.Fatalf("Reencrypt() error = %v", err)
+	}
+	got, err := newRing.Decrypt(rotated, "gtoken")
+	if err != nil || got != "secret" {
+		t.Errorf("Decrypt() after rotation = %v, %v, want secret", got, err)
+	}
+
+	// The cipher text is bound to its token name
+	if _, err := newRing.Decrypt(rotated, "other"); err == nil {
+		t.Errorf("Decrypt() with other additional data succeeded")
+	}
+}
+
+func TestAESKeyRingLegacyCipherText(t *testing.T) {
+	legacy := randomAESKey(t)
+	key, _ := hex.DecodeString(legacy)
+
+	// Cipher texts stored before the key ring have no key id nor additional data
+	cipherText, err := encryptToken("secret", key, nil)
+	if err != nil {
+		t.Fatalf("encryptToken() error = %v", err)
+	}
+
+	ring, err := NewAESKeyRing(aesKeysJSON(t, aesKeyConfig{ID: "k1", Key: randomAESKey(t)}), "k1", legacy)
+	if err != nil {
+		t.Fatalf("NewAESKeyRing() error = %v", err)
+	}
+	got, err := ring.Decrypt(cipherText, "gtoken")
+	if err != nil || got != "secret" {
+		t.Errorf("Decrypt() = %v, %v, want secret", got, err)
+	}
+
+	rotated, err := ring.Reencrypt(cipherText, "gtoken")
+	if err != nil {
+		t.Fatalf("Reencrypt() error = %v", err)
+	}
+	if !strings.HasPrefix(rotated, "k1:") {
+		t.Errorf("Reencrypt() = %v, want k1 prefix", rotated)
+	}
+
+	if _, err := ring.Decrypt("k1:00", "gtoken"); !errors.Is(err, ErrMalformedCipherText) {
+		t.Errorf("Decrypt() of short cipher text error = %v, want %v", err, ErrMalformedCipherText)
+	}
+}
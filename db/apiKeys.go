@@ -0,0 +1,91 @@
+package db
+
+import (
+	"github.com/lib/pq"
+	"time"
+)
+
+// APIKey represents an API key record of the api_keys table, the key itself is never stored.
+type APIKey struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  string     `json:"createdBy"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+type APIKeys struct {
+}
+
+// CreateAPIKey stores a new API key with the given hash and returns its ID.
+func (k APIKeys) CreateAPIKey(key APIKey, keyHash string) (int64, error) {
+	db := pgConnect()
+
+	var id int64
+	err := db.QueryRow("INSERT INTO api_keys(name, key_hash, scopes, created_by, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		key.Name, keyHash, pq.Array(key.Scopes), key.CreatedBy, key.ExpiresAt).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// UseAPIKey marks the key with the given hash as used now and returns it.
+// It returns sql.ErrNoRows if the key doesn't exist, is revoked or expired.
+func (k APIKeys) UseAPIKey(keyHash string) (APIKey, error) {
+	db := pgConnect()
+
+	var key APIKey
+	err := db.QueryRow(`UPDATE api_keys SET last_used_at = now()
+WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())
+RETURNING id, name, scopes, created_by, created_at, expires_at, last_used_at, revoked_at`, keyHash).
+		Scan(&key.ID, &key.Name, pq.Array(&key.Scopes), &key.CreatedBy, &key.CreatedAt, &key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	return key, nil
+}
+
+// ListAPIKeys returns all the API keys, revoked ones included, newest first.
+func (k APIKeys) ListAPIKeys() ([]APIKey, error) {
+	db := pgConnect()
+
+	rows, err := db.Query("SELECT id, name, scopes, created_by, created_at, expires_at, last_used_at, revoked_at FROM api_keys ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]APIKey, 0)
+	for rows.Next() {
+		var key APIKey
+		if err := rows.Scan(&key.ID, &key.Name, pq.Array(&key.Scopes), &key.CreatedBy, &key.CreatedAt, &key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, key)
+	}
+
+	return res, rows.Err()
+}
+
+// RevokeAPIKey revokes the API key with the given ID.
+// It returns false if no active key with such ID exists.
+func (k APIKeys) RevokeAPIKey(id int64) (bool, error) {
+	db := pgConnect()
+
+	res, err := db.Exec("UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL", id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"time"
+)
+
+// Auth event types, one for every step of the auth flow
+const (
+	AuthEventOtpRequested       = "otp_requested"        // OTP requested, the detail holds the refusal reason if any
+	AuthEventUnauthorizedDomain = "unauthorized_domain"  // Login attempted with a mail outside the authorized domains
+	AuthEventBlockedMail        = "blocked_mail"         // Login attempted with a blocked mail
+	AuthEventMailSent           = "mail_sent"            // OTP mail sent
+	AuthEventMailFailed         = "mail_failed"          // OTP mail not sent
+	AuthEventOtpFailed          = "otp_failed"           // Wrong OTP or OTP check refused
+	AuthEventOtpSucceeded       = "otp_succeeded"        // Valid OTP, the user is logged in
+	AuthEventMagicLinkSucceeded = "magic_link_succeeded" // Valid magic link, the user is logged in
+	AuthEventMagicLinkFailed    = "magic_link_failed"    // Magic link invalid, expired, used or opened without the pending auth
+	AuthEventGoogleSucceeded    = "google_succeeded"     // Signed in with Google, the user is logged in
+	AuthEventGoogleFailed       = "google_failed"        // Sign in with Google refused
+	AuthEventTotpEnrolled       = "totp_enrolled"        // Authenticator app confirmed, recovery codes issued
+	AuthEventTotpDisabled       = "totp_disabled"        // Authenticator app login disabled by its user
+	AuthEventTotpSucceeded      = "totp_succeeded"       // Valid authenticator app or recovery code, the user is logged in
+	AuthEventTotpFailed         = "totp_failed"          // Wrong, reused or unenrolled authenticator app or recovery code
+	AuthEventJWTRejected        = "jwt_rejected"         // Invalid token or expired/revoked session refused by the middleware
+	AuthEventAPIKeyRejected     = "api_key_rejected"     // Invalid, expired or revoked API key refused by the middleware
+	AuthEventLogout             = "logout"               // Session closed by its user
+	AuthEventUserInactive       = "user_inactive"        // Login refused to a deactivated user
+)
+
+// MaxAuthEvents is the maximum number of events returned by a query
+const MaxAuthEvents = 1000
+
+// AuthEvent represents an auth flow step of the auth_events table.
+type AuthEvent struct {
+	ID        int64     `json:"id"`
+	Event     string    `json:"event"`
+	UserEmail string    `json:"userEmail"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// AuthEventFilter selects the events returned by QueryAuthEvents, zero fields don't filter.
+type AuthEventFilter struct {
+	UserEmail string    // Events of this user only, e-mails are stored lowercase
+	From      time.Time // Events at or after this time
+	To        time.Time // Events before this time
+	Limit     int       // Maximum number of events, capped to MaxAuthEvents
+}
+
+type AuthEvents struct {
+}
+
+// RecordAuthEvent stores an auth event, timestamped by the DB clock.
+func (a AuthEvents) RecordAuthEvent(event AuthEvent) error {
+	db := pgConnect()
+
+	_, err := db.Exec("INSERT INTO auth_events(event, user_email, ip, user_agent, detail) VALUES ($1, $2, $3, $4, $5)",
+		event.Event, event.UserEmail, event.IP, event.UserAgent, event.Detail)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// QueryAuthEvents returns the events matching the filter, newest first.
+func (a AuthEvents) QueryAuthEvents(filter AuthEventFilter) ([]AuthEvent, error) {
+	db := pgConnect()
+
+	limit := filter.Limit
+	if limit <= 0 || limit > MaxAuthEvents {
+		limit = MaxAuthEvents
+	}
+	var from, to *time.Time
+	if !filter.From.IsZero() {
+		from = &filter.From
+	}
+	if !filter.To.IsZero() {
+		to = &filter.To
+	}
+
+	rows, err := db.Query(`SELECT id, event, user_email, ip, user_agent, detail, created_at FROM auth_events
+WHERE ($1 = '' OR user_email = $1)
+  AND ($2::timestamptz IS NULL OR created_at >= $2)
+  AND ($3::timestamptz IS NULL OR created_at < $3)
+ORDER BY created_at DESC, id DESC
+LIMIT $4`, filter.UserEmail, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]AuthEvent, 0)
+	for rows.Next() {
+		var event AuthEvent
+		if err := rows.Scan(&event.ID, &event.Event, &event.UserEmail, &event.IP, &event.UserAgent, &event.Detail, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, event)
+	}
+
+	return res, rows.Err()
+}
@@ -1,13 +1,14 @@
 package db
 
 import (
-	"aat-manager/utils"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"database/sql"
 	"encoding/hex"
 	"io"
 	"log"
+	"time"
 )
 
 const (
@@ -18,9 +19,9 @@ type Token struct {
 }
 
 // encryptToken encrypts a token using AES encryption with a given key.
-// It takes a plainText string and a key as input parameters.
-// It returns the encrypted token as a string and an error if any.
-func encryptToken(plainText string, key []byte) (string, error) {
+// It takes a plainText string, a key and the additional authenticated data, may be nil, as input parameters.
+// It returns the hex encoded nonce and encrypted token as a string and an error if any.
+func encryptToken(plainText string, key []byte, aad []byte) (string, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
@@ -36,12 +37,12 @@ func encryptToken(plainText string, key []byte) (string, error) {
 		return "", err
 	}
 
-	cipherText := aesgcm.Seal(nonce, nonce, []byte(plainText), nil)
+	cipherText := aesgcm.Seal(nonce, nonce, []byte(plainText), aad)
 	return hex.EncodeToString(cipherText), nil
 }
 
-// decryptToken decrypts a token using AES encryption with a given key.
-func decryptToken(cipherText string, key []byte) (string, error) {
+// decryptToken decrypts a token using AES encryption with a given key, checking the additional authenticated data.
+func decryptToken(cipherText string, key []byte, aad []byte) (string, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
@@ -57,8 +58,11 @@ func decryptToken(cipherText string, key []byte) (string, error) {
 		return "", err
 	}
 
-	nonce, ct := ct[:12], ct[12:]
-	plainText, err := aesgcm.Open(nil, nonce, ct, nil)
+	if len(ct) < aesgcm.NonceSize() {
+		return "", ErrMalformedCipherText
+	}
+	nonce, ct := ct[:aesgcm.NonceSize()], ct[aesgcm.NonceSize():]
+	plainText, err := aesgcm.Open(nil, nonce, ct, aad)
 	if err != nil {
 		return "", err
 	}
@@ -66,40 +70,166 @@ func decryptToken(cipherText string, key []byte) (string, error) {
 	return string(plainText), nil
 }
 
-// SaveToken saves the provided token into the database after encrypting it using the AES encryption key.
-// It requires a valid database connection obtained from the pgConnect function.
-// The encryption key is fetched from the environment using the ReadEnvOrPanic function.
-// The token is stored in the tokens table in the database with the name "gtoken".
+// TokenHistoryRetention is the number of prior versions kept for every token
+const TokenHistoryRetention = 10
+
+// TokenVersion represents the metadata of a token version, current or prior, never its value.
+type TokenVersion struct {
+	Name       string     `json:"name"`
+	Version    int        `json:"version"`
+	SavedAt    time.Time  `json:"savedAt"`
+	SavedBy    string     `json:"savedBy"`
+	ReplacedAt *time.Time `json:"replacedAt,omitempty"` // Nil for the current version
+	ReplacedBy string     `json:"replacedBy,omitempty"`
+}
+
+// SaveToken saves the provided token into the database after encrypting it using the active AES key, see CurrentAESKeyRing.
+// The token is stored in the tokens table with the name "gtoken", see GsuiteToken.
+// A stored token is replaced and its previous version is moved to the token_history table in the same transaction,
+// only the last TokenHistoryRetention versions are kept.
+// The rotatedBy parameter records who saved the token, like "oauth_callback" or "refresh".
 // The function returns an error if there is any issue encrypting or saving the token.
-// Note: The pgConnect and ReadEnvOrPanic functions must be properly implemented and available.
-// The encryptToken function is used to encrypt the token.
-// The GsuiteToken constant specifies the name under which the token is stored in the database.
-func (t Token) SaveToken(token string) error {
-	db := pgConnect()                               // Acquire db connection
-	aesKey := utils.ReadEnvOrPanic(utils.AESSECRET) // Acquire aes secret from env
-	aesByteKey, err := hex.DecodeString(aesKey)     // Decode string to byte
+func (t Token) SaveToken(token string, rotatedBy string) error {
+	db := pgConnect() // Acquire db connection
+
+	ring, err := CurrentAESKeyRing() // Acquire aes keys from env
+	if err != nil {
+		return err
+	}
+	encryptedToken, err := ring.Encrypt(token, tokenAAD(GsuiteToken)) // Encrypt token, bound to its name
 	if err != nil {
 		return err
 	}
-	encryptedToke, err := encryptToken(token, aesByteKey) // Encrypt token
+
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	// Keep the replaced version, if any
+	if err := archiveToken(tx, GsuiteToken, rotatedBy); err != nil {
+		return err
+	}
 
-	_, err = db.Exec("INSERT INTO tokens(name, value) VALUES ($1, $2)", GsuiteToken, encryptedToke)
+	_, err = tx.Exec(`INSERT INTO tokens(name, value, rotated_by) VALUES ($1, $2, $3)
+ON CONFLICT (name) DO UPDATE SET value      = excluded.value,
+                                 version    = tokens.version + 1,
+                                 updated_at = now(),
+                                 rotated_by = excluded.rotated_by`, GsuiteToken, encryptedToken, rotatedBy)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateToken replaces the value of the stored token in place, after encrypting it like SaveToken.
+// It is meant for access tokens refreshed with the same refresh token: the version, and who saved it,
+// are kept and nothing is archived, so the hourly refreshes don't fill the token history.
+// A deleted token is not stored again, the function then returns sql.ErrNoRows.
+func (t Token) UpdateToken(token string) error {
+	db := pgConnect()
+
+	ring, err := CurrentAESKeyRing()
+	if err != nil {
+		return err
+	}
+	encryptedToken, err := ring.Encrypt(token, tokenAAD(GsuiteToken))
+	if err != nil {
+		return err
+	}
+
+	res, err := db.Exec("UPDATE tokens SET value = $2, updated_at = now() WHERE name = $1", GsuiteToken, encryptedToken)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
 
 	return nil
 }
 
-// GetToken retrieves the encrypted token from the database and decrypts it using the AES encryption key.
+// DeleteToken deletes the stored token, moving it to the token_history table.
+// The deletedBy parameter records who deleted the token.
+// It returns false if no token is stored.
+func (t Token) DeleteToken(deletedBy string) (bool, error) {
+	db := pgConnect()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if err := archiveToken(tx, GsuiteToken, deletedBy); err != nil {
+		return false, err
+	}
+
+	res, err := tx.Exec("DELETE FROM tokens WHERE name = $1", GsuiteToken)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, tx.Commit()
+}
+
+// ListTokenVersions returns the metadata of the current token, if any, and of its prior versions, newest first.
+func (t Token) ListTokenVersions() ([]TokenVersion, error) {
+	db := pgConnect()
+
+	rows, err := db.Query(`SELECT name, version, updated_at, rotated_by, NULL, '' FROM tokens WHERE name = $1
+UNION ALL
+SELECT name, version, saved_at, saved_by, replaced_at, replaced_by FROM token_history WHERE name = $1
+ORDER BY 2 DESC, 5 DESC NULLS FIRST`, GsuiteToken)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]TokenVersion, 0)
+	for rows.Next() {
+		var version TokenVersion
+		if err := rows.Scan(&version.Name, &version.Version, &version.SavedAt, &version.SavedBy, &version.ReplacedAt, &version.ReplacedBy); err != nil {
+			return nil, err
+		}
+		res = append(res, version)
+	}
+
+	return res, rows.Err()
+}
+
+// archiveToken copies the stored token, if any, to the token_history table as replaced by replacedBy,
+// then drops the versions exceeding TokenHistoryRetention. The token row is locked until the transaction ends.
+func archiveToken(tx *sql.Tx, name string, replacedBy string) error {
+	_, err := tx.Exec(`INSERT INTO token_history(name, version, value, saved_at, saved_by, replaced_by)
+SELECT name, version, value, updated_at, rotated_by, $2 FROM tokens WHERE name = $1
+FOR UPDATE`, name, replacedBy)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM token_history WHERE name = $1 AND id NOT IN (
+    SELECT id FROM token_history WHERE name = $1 ORDER BY id DESC LIMIT $2)`, name, TokenHistoryRetention)
+	return err
+}
+
+// GetToken retrieves the encrypted token from the database and decrypts it using the AES key it was encrypted with.
 // It returns the decrypted token as a string and an error if any.
-// The encryption key is fetched from the environment using the ReadEnvOrPanic function.
+// The encryption keys are fetched from the environment, see CurrentAESKeyRing.
 // It requires a valid database connection obtained from the pgConnect function.
 // The token is stored in the tokens table in the database with the name "gtoken".
 // The function log any errors encountered during the retrieval or decryption process.
+// It returns sql.ErrNoRows if no token is stored.
 func (t Token) GetToken() (string, error) {
 	db := pgConnect()
 
@@ -110,15 +240,14 @@ func (t Token) GetToken() (string, error) {
 		return "", err
 	}
 
-	// Fetch encryption key from env
-	aesKey := utils.ReadEnvOrPanic(utils.AESSECRET)
-	aesByteKey, err := hex.DecodeString(aesKey) // Decode string to byte
+	// Fetch encryption keys from env
+	ring, err := CurrentAESKeyRing()
 	if err != nil {
 		return "", err
 	}
 
 	// Decrypt the token and return it
-	decryptedToken, err := decryptToken(encryptedToken, aesByteKey)
+	decryptedToken, err := ring.Decrypt(encryptedToken, tokenAAD(GsuiteToken))
 	if err != nil {
 		log.Printf("Failed to decrypt token: %v", err)
 		return "", err
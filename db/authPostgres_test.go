@@ -30,7 +30,7 @@ func TestEncryptToken(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := encryptToken(tt.plainText, key)
+			_, err := encryptToken(tt.plainText, key, nil)
 
 			if (err != nil) != (tt.expectedErr != nil) {
 				t.Errorf("encryptToken() error = %v, expectedErr %v", err, tt.expectedErr)
@@ -55,12 +55,12 @@ func TestEncryptDecryptToken(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cipherText, err := encryptToken(tt.plainText, key)
+			cipherText, err := encryptToken(tt.plainText, key, nil)
 			if err != nil {
 				t.Errorf("encryption failed: %v", err)
 			}
 
-			decryptedText, err := decryptToken(cipherText, key)
+			decryptedText, err := decryptToken(cipherText, key, nil)
 			if err != nil {
 				t.Errorf("decryption failed: %v", err)
 			}
@@ -1,45 +1,154 @@
 package db
 
 import (
+	"container/heap"
+	"strconv"
 	"sync"
 	"time"
 )
 
+// DefaultTTL is the time to live of the values stored with Set
+const DefaultTTL = 3 * time.Minute
+
+// janitorIdle is the janitor wake up interval when no key is stored
+const janitorIdle = time.Hour
+
+// entry is a stored value with its expiration time
+type entry struct {
+	value     string
+	expiresAt time.Time
+}
+
+// expiration is a key scheduled for deletion by the janitor
+type expiration struct {
+	key string
+	at  time.Time
+}
+
+// expirationHeap is a min-heap of expirations ordered by time, implements heap.Interface
+type expirationHeap []expiration
+
+func (h expirationHeap) Len() int           { return len(h) }
+func (h expirationHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h expirationHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *expirationHeap) Push(x any) {
+	*h = append(*h, x.(expiration))
+}
+
+func (h *expirationHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
 // InMemoryDb represents an in-memory database
+// Every value has its own expiration time. Expired values are never returned by Get
+// and are removed by a single background janitor, which must be stopped with Close.
 type InMemoryDb struct {
-	m   map[string]string
-	mux sync.RWMutex
+	m           map[string]entry
+	expirations expirationHeap
+	mux         sync.RWMutex
+
+	wake      chan struct{} // Signal janitor that an earlier expiration has been scheduled
+	done      chan struct{} // Closed to stop the janitor
+	closeOnce sync.Once
 }
 
+// NewDB creates a new in memory db and starts its expiry janitor.
 func NewDB() *InMemoryDb {
 	db := &InMemoryDb{
-		m: make(map[string]string),
+		m:    make(map[string]entry),
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
 	}
+	go db.janitor()
 	return db
 }
 
 // Set sets the value for the specified key in the inMemoryDb.
-// It locks the mutex, updates the map with the key-value pair,
-// and then unlocks the mutex.
-// Additionally, it sets a timer to automatically delete the key
-// from the map after 3 minutes.
+// The key is automatically deleted after DefaultTTL.
 func (db *InMemoryDb) Set(key string, value string) {
+	db.SetWithTTL(key, value, DefaultTTL)
+}
+
+// SetWithTTL sets the value for the specified key in the inMemoryDb,
+// the key is automatically deleted after ttl.
+// Setting an existing key replaces both its value and its expiration time.
+func (db *InMemoryDb) SetWithTTL(key string, value string, ttl time.Duration) {
+	expiresAt := time.Now().Add(ttl)
+
 	db.mux.Lock()
-	db.m[key] = value
+	db.m[key] = entry{value: value, expiresAt: expiresAt}
+	heap.Push(&db.expirations, expiration{key: key, at: expiresAt})
+	earliest := db.expirations[0].at.Equal(expiresAt)
 	db.mux.Unlock()
 
-	// Set the key to be automatically deleted after 3 minutes
-	time.AfterFunc(3*time.Minute, func() {
-		db.Delete(key)
-	})
+	// Janitor only needs to be woken up if it sleeps past the new expiration
+	if earliest {
+		select {
+		case db.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Incr adds delta to the integer value of the key and returns the new value.
+// Missing, expired or non numeric keys count as 0 and expire after ttl, incremented keys keep their expiration.
+func (db *InMemoryDb) Incr(key string, delta int, ttl time.Duration) (int, error) {
+	now := time.Now()
+
+	db.mux.Lock()
+	e, exists := db.m[key]
+	count, err := strconv.Atoi(e.value)
+	if !exists || !now.Before(e.expiresAt) || err != nil {
+		count = 0
+		e.expiresAt = now.Add(ttl)
+		heap.Push(&db.expirations, expiration{key: key, at: e.expiresAt})
+	}
+	count += delta
+	e.value = strconv.Itoa(count)
+	db.m[key] = e
+	earliest := db.expirations[0].at.Equal(e.expiresAt)
+	db.mux.Unlock()
+
+	if earliest {
+		select {
+		case db.wake <- struct{}{}:
+		default:
+		}
+	}
+	return count, nil
 }
 
 // Get retrieves a value for a key
-func (db *InMemoryDb) Get(key string) (string, bool) {
+// Expired values are reported as missing even if the janitor didn't remove them yet.
+// The in memory store never fails, the error is always nil.
+func (db *InMemoryDb) Get(key string) (string, bool, error) {
 	db.mux.RLock()
-	value, exists := db.m[key]
+	e, exists := db.m[key]
 	db.mux.RUnlock()
-	return value, exists
+
+	if !exists || !time.Now().Before(e.expiresAt) {
+		return "", false, nil
+	}
+	return e.value, true, nil
+}
+
+// CompareAndDelete deletes the key if it isn't expired and holds the value, and reports whether it did.
+// The in memory store never fails, the error is always nil.
+func (db *InMemoryDb) CompareAndDelete(key string, value string) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	e, exists := db.m[key]
+	if !exists || !time.Now().Before(e.expiresAt) || e.value != value {
+		return false, nil
+	}
+	delete(db.m, key)
+	return true, nil
 }
 
 // Delete removes a value for a key
@@ -48,3 +157,50 @@ func (db *InMemoryDb) Delete(key string) {
 	delete(db.m, key)
 	db.mux.Unlock()
 }
+
+// Close stops the expiry janitor. Stored values can still be read, but are no longer purged.
+// It is safe to call Close more than once.
+func (db *InMemoryDb) Close() {
+	db.closeOnce.Do(func() {
+		close(db.done)
+	})
+}
+
+// janitor removes expired keys, sleeping until the earliest scheduled expiration.
+func (db *InMemoryDb) janitor() {
+	for {
+		wait := janitorIdle
+		if next, ok := db.purge(time.Now()); ok {
+			wait = time.Until(next)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-db.wake:
+			timer.Stop()
+		case <-db.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// purge deletes every key expired at now and returns the next scheduled expiration, if any.
+// Expirations of keys deleted or set again in the meantime are stale and just discarded.
+func (db *InMemoryDb) purge(now time.Time) (time.Time, bool) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	for len(db.expirations) > 0 && !db.expirations[0].at.After(now) {
+		exp := heap.Pop(&db.expirations).(expiration)
+		if e, ok := db.m[exp.key]; ok && e.expiresAt.Equal(exp.at) {
+			delete(db.m, exp.key)
+		}
+	}
+
+	if len(db.expirations) == 0 {
+		return time.Time{}, false
+	}
+	return db.expirations[0].at, true
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"strconv"
 	"testing"
 	"time"
 )
@@ -34,7 +35,7 @@ func TestSet(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			db := NewDB()
 			db.Set(tt.key, tt.value)
-			gotValue, exists := db.Get(tt.key)
+			gotValue, exists, _ := db.Get(tt.key)
 
 			if gotValue != tt.value {
 				t.Errorf("Set() = %v, want %v", gotValue, tt.value)
@@ -46,7 +47,7 @@ func TestSet(t *testing.T) {
 
 			// Wait for more than 3 minutes to check if value is deleted
 			//time.Sleep(3*time.Minute + 1*time.Second)
-			//gotValue, exists = db.Get(tt.key)
+			//gotValue, exists, _ = db.Get(tt.key)
 			//if exists != false {
 			//	t.Errorf("Set() key = %v should have been deleted after 3 minutes", tt.key)
 			//}
@@ -86,7 +87,7 @@ func TestGet(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			tt.setup()
-			gotVal, gotExist := db.Get(tt.input)
+			gotVal, gotExist, _ := db.Get(tt.input)
 			if gotVal != tt.wantVal || gotExist != tt.wantExist {
 				t.Errorf("inMemoryDb.Get() = value: %v, exist: %v, want value: %v, exist: %v", gotVal, gotExist, tt.wantVal, tt.wantExist)
 			}
@@ -128,10 +129,312 @@ func TestDelete(t *testing.T) {
 				time.Sleep(time.Millisecond)
 			}
 			myDB.Delete(tt.del)
-			_, got := myDB.Get(tt.del)
+			_, got, _ := myDB.Get(tt.del)
 			if got != tt.want {
 				t.Errorf("Delete() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestCompareAndDelete(t *testing.T) {
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		value     string
+		want      bool
+		wantExist bool
+	}{
+		{
+			name:      "same value",
+			ttl:       time.Minute,
+			value:     "value1",
+			want:      true,
+			wantExist: false,
+		},
+		{
+			name:      "other value",
+			ttl:       time.Minute,
+			value:     "value2",
+			want:      false,
+			wantExist: true,
+		},
+		{
+			name:      "expired key",
+			ttl:       time.Nanosecond,
+			value:     "value1",
+			want:      false,
+			wantExist: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			myDB := NewDB()
+			defer myDB.Close()
+			myDB.SetWithTTL("key1", "value1", tt.ttl)
+			time.Sleep(time.Millisecond)
+
+			got, err := myDB.CompareAndDelete("key1", tt.value)
+			if err != nil || got != tt.want {
+				t.Errorf("CompareAndDelete() = %v, %v, want %v, nil", got, err, tt.want)
+			}
+			if _, exist, _ := myDB.Get("key1"); exist != tt.wantExist {
+				t.Errorf("Get() exist = %v, want %v", exist, tt.wantExist)
+			}
+		})
+	}
+}
+
+func TestCompareAndDeleteConcurrent(t *testing.T) {
+	myDB := NewDB()
+	defer myDB.Close()
+	myDB.Set("key1", "value1")
+
+	done := make(chan bool)
+	for i := 0; i < 50; i++ {
+		go func() {
+			ok, _ := myDB.CompareAndDelete("key1", "value1")
+			done <- ok
+		}()
+	}
+	deleted := 0
+	for i := 0; i < 50; i++ {
+		if <-done {
+			deleted++
+		}
+	}
+
+	if deleted != 1 {
+		t.Errorf("CompareAndDelete() succeeded %d times, want 1", deleted)
+	}
+}
+
+func TestSetWithTTL(t *testing.T) {
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		wait      time.Duration
+		wantExist bool
+	}{
+		{name: "Not expired", ttl: time.Minute, wait: 0, wantExist: true},
+		{name: "Expired", ttl: 10 * time.Millisecond, wait: 20 * time.Millisecond, wantExist: false},
+		{name: "Non positive TTL", ttl: 0, wait: 0, wantExist: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewDB()
+			defer db.Close()
+
+			db.SetWithTTL("key", "value", tt.ttl)
+			time.Sleep(tt.wait)
+
+			_, exists, _ := db.Get("key")
+			if exists != tt.wantExist {
+				t.Errorf("Get() exists = %v, want %v", exists, tt.wantExist)
+			}
+		})
+	}
+}
+
+func TestSetReplacesExpiration(t *testing.T) {
+	db := NewDB()
+	defer db.Close()
+
+	// Second set must not be removed when the first expiration elapses
+	db.SetWithTTL("otp", "first", 20*time.Millisecond)
+	db.SetWithTTL("otp", "second", time.Minute)
+	time.Sleep(50 * time.Millisecond)
+
+	got, exists, _ := db.Get("otp")
+	if !exists || got != "second" {
+		t.Errorf("Get() = %v, %v, want second, true", got, exists)
+	}
+
+	// Shorter TTL on an existing key is honored as well
+	db.SetWithTTL("otp", "third", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if _, exists, _ := db.Get("otp"); exists {
+		t.Errorf("Get() exists = true, want key expired")
+	}
+}
+
+func TestJanitorPurgesExpiredKeys(t *testing.T) {
+	db := NewDB()
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		db.SetWithTTL(strconv.Itoa(i), "value", 10*time.Millisecond)
+	}
+	db.SetWithTTL("kept", "value", time.Minute)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		db.mux.RLock()
+		n := len(db.m)
+		db.mux.RUnlock()
+		if n == 1 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Errorf("janitor didn't purge expired keys")
+}
+
+func TestClose(t *testing.T) {
+	db := NewDB()
+	db.Set("key", "value")
+
+	// Close is idempotent and keeps values readable
+	db.Close()
+	db.Close()
+
+	if _, exists, _ := db.Get("key"); !exists {
+		t.Errorf("Get() after Close() exists = false, want true")
+	}
+}
+
+func BenchmarkSetWithTTL(b *testing.B) {
+	db := NewDB()
+	defer db.Close()
+
+	keys := make([]string, 50000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db.SetWithTTL(keys[i%len(keys)], "123456", DefaultTTL)
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	db := NewDB()
+	defer db.Close()
+
+	keys := make([]string, 50000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		db.Set(keys[i], "123456")
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			db.Get(keys[i%len(keys)])
+			i++
+		}
+	})
+}
+
+// BenchmarkJanitorExpiry measures the time to store and expire 50000 keys with a single janitor.
+func BenchmarkJanitorExpiry(b *testing.B) {
+	const n = 50000
+
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+
+	for i := 0; i < b.N; i++ {
+		db := NewDB()
+		for j, key := range keys {
+			// Spread expirations over 50ms
+			db.SetWithTTL(key, "123456", time.Duration(j%50)*time.Millisecond)
+		}
+
+		for {
+			db.mux.RLock()
+			left := len(db.m)
+			db.mux.RUnlock()
+			if left == 0 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		db.Close()
+	}
+}
+
+func TestIncr(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial string // Value set before incrementing, blank for a missing key
+		ttl     time.Duration
+		deltas  []int
+		want    int
+	}{
+		{name: "Missing key", ttl: time.Minute, deltas: []int{1}, want: 1},
+		{name: "Existing counter", initial: "3", ttl: time.Minute, deltas: []int{1, 1}, want: 5},
+		{name: "Negative delta", initial: "3", ttl: time.Minute, deltas: []int{1, -1}, want: 3},
+		{name: "Non numeric value", initial: "abc", ttl: time.Minute, deltas: []int{1}, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewDB()
+			defer db.Close()
+
+			if tt.initial != "" {
+				db.Set("counter", tt.initial)
+			}
+			var got int
+			for _, delta := range tt.deltas {
+				var err error
+				if got, err = db.Incr("counter", delta, tt.ttl); err != nil {
+					t.Fatalf("Incr() error = %v", err)
+				}
+			}
+
+			if got != tt.want {
+				t.Errorf("Incr() = %v, want %v", got, tt.want)
+			}
+			if value, _, _ := db.Get("counter"); value != strconv.Itoa(tt.want) {
+				t.Errorf("Get() = %v, want %v", value, tt.want)
+			}
+		})
+	}
+}
+
+func TestIncrExpiration(t *testing.T) {
+	db := NewDB()
+	defer db.Close()
+
+	if _, err := db.Incr("counter", 1, 50*time.Millisecond); err != nil {
+		t.Fatalf("Incr() error = %v", err)
+	}
+	// Incrementing keeps the expiration of the first increment
+	if _, err := db.Incr("counter", 1, time.Hour); err != nil {
+		t.Fatalf("Incr() error = %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, exists, _ := db.Get("counter"); exists {
+		t.Errorf("Get() counter should have expired")
+	}
+	if got, _ := db.Incr("counter", 1, time.Minute); got != 1 {
+		t.Errorf("Incr() after expiration = %v, want 1", got)
+	}
+}
+
+func TestIncrConcurrent(t *testing.T) {
+	db := NewDB()
+	defer db.Close()
+
+	done := make(chan struct{})
+	for i := 0; i < 100; i++ {
+		go func() {
+			_, _ = db.Incr("counter", 1, time.Minute)
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		<-done
+	}
+
+	if value, _, _ := db.Get("counter"); value != "100" {
+		t.Errorf("Get() = %v, want 100", value)
+	}
+}
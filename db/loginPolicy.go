@@ -0,0 +1,79 @@
+package db
+
+import (
+	"time"
+)
+
+// Login policy entry kinds
+const (
+	PolicyDomain = "domain" // Authorized e-mail domain, besides the AUTHDOMAIN one
+	PolicyAllow  = "allow"  // Address allowed to log in whatever its domain
+	PolicyBlock  = "block"  // Address refused even if its domain is authorized
+)
+
+// LoginPolicyEntry represents a rule of the login_policy table.
+type LoginPolicyEntry struct {
+	Kind      string    `json:"kind"`
+	Value     string    `json:"value"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type LoginPolicies struct {
+}
+
+// ListPolicyEntries returns all the login policy entries ordered by kind and value.
+func (p LoginPolicies) ListPolicyEntries() ([]LoginPolicyEntry, error) {
+	db := pgConnect()
+
+	rows, err := db.Query("SELECT kind, value, created_by, created_at FROM login_policy ORDER BY kind, value")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]LoginPolicyEntry, 0)
+	for rows.Next() {
+		var entry LoginPolicyEntry
+		if err := rows.Scan(&entry.Kind, &entry.Value, &entry.CreatedBy, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, entry)
+	}
+
+	return res, rows.Err()
+}
+
+// AddPolicyEntry stores a login policy entry and returns it.
+// Adding an existing entry has no effect and returns the stored one.
+func (p LoginPolicies) AddPolicyEntry(kind string, value string, createdBy string) (LoginPolicyEntry, error) {
+	db := pgConnect()
+
+	var entry LoginPolicyEntry
+	err := db.QueryRow(`INSERT INTO login_policy(kind, value, created_by) VALUES ($1, $2, $3)
+ON CONFLICT (kind, value) DO UPDATE SET kind = excluded.kind
+RETURNING kind, value, created_by, created_at`, kind, value, createdBy).
+		Scan(&entry.Kind, &entry.Value, &entry.CreatedBy, &entry.CreatedAt)
+	if err != nil {
+		return LoginPolicyEntry{}, err
+	}
+
+	return entry, nil
+}
+
+// DeletePolicyEntry removes a login policy entry.
+// It returns false if no such entry exists.
+func (p LoginPolicies) DeletePolicyEntry(kind string, value string) (bool, error) {
+	db := pgConnect()
+
+	res, err := db.Exec("DELETE FROM login_policy WHERE kind = $1 AND value = $2", kind, value)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
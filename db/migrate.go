@@ -0,0 +1,346 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Error definition
+var (
+	ErrMalformedMigration = errors.New("malformed migration file name, want <version>_<name>.up.sql or .down.sql")
+	ErrDuplicateMigration = errors.New("duplicate migration version")
+	ErrMissingUpMigration = errors.New("migration has no up file")
+	ErrMissingDownFile    = errors.New("migration has no down file")
+	ErrChecksumMismatch   = errors.New("applied migration changed since it was applied")
+	ErrUnknownMigration   = errors.New("applied migration unknown to this build")
+)
+
+// migrationsLockID is the Postgres advisory lock held while migrating,
+// so instances starting together don't apply the same migration twice
+const migrationsLockID int64 = 0x6161742d6d6967 // "aat-mig"
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationFileName matches the migration files, like 0001_tokens.up.sql
+var migrationFileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is a versioned schema change, read from the embedded migrations directory.
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string // SQL applying the change
+	Down     string // SQL reverting the change, blank if irreversible
+	Checksum string // SHA-256 of the up SQL, stored when applied
+}
+
+// MigrationStatus is a migration and its state in the schema_migrations table.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time // Nil for pending migrations
+}
+
+// appliedMigration is a schema_migrations record
+type appliedMigration struct {
+	Version   int64
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+type Migrations struct {
+}
+
+// loadMigrations reads the migrations of fsys, in its root directory, ordered by version.
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), ErrMalformedMigration)
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), ErrMalformedMigration)
+		}
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), ErrDuplicateMigration)
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	res := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("version %d: %w", migration.Version, ErrMissingUpMigration)
+		}
+		sum := sha256.Sum256([]byte(migration.Up))
+		migration.Checksum = hex.EncodeToString(sum[:])
+		res = append(res, *migration)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Version < res[j].Version
+	})
+
+	return res, nil
+}
+
+// embeddedMigrations returns the migrations embedded in the build.
+func embeddedMigrations() ([]Migration, error) {
+	fsys, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return loadMigrations(fsys)
+}
+
+// pendingMigrations returns the migrations not applied yet, checking the applied ones against their checksum.
+// Applied migrations unknown to this build, that is applied by a newer build, are only logged.
+func pendingMigrations(migrations []Migration, applied map[int64]appliedMigration) ([]Migration, error) {
+	known := make(map[int64]bool)
+	res := make([]Migration, 0)
+	for _, migration := range migrations {
+		known[migration.Version] = true
+
+		record, ok := applied[migration.Version]
+		if !ok {
+			res = append(res, migration)
+			continue
+		}
+		if record.Checksum != migration.Checksum {
+			return nil, fmt.Errorf("version %d %s: %w", migration.Version, migration.Name, ErrChecksumMismatch)
+		}
+	}
+
+	for version, record := range applied {
+		if !known[version] {
+			log.Printf("Migration %d %s is applied but unknown to this build", version, record.Name)
+		}
+	}
+
+	return res, nil
+}
+
+// MigrateUp applies the pending migrations in version order and returns them.
+// Every migration runs in its own transaction, with its schema_migrations record.
+// It refuses to run if an applied migration was changed since, see ErrChecksumMismatch.
+func (m Migrations) MigrateUp(ctx context.Context) ([]Migration, error) {
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	err = withMigrationsLock(ctx, func(conn *sql.Conn) error {
+		applied, err := readAppliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		pending, err := pendingMigrations(migrations, applied)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range pending {
+			err := runInTx(ctx, conn, migration.Up,
+				"INSERT INTO schema_migrations(version, name, checksum) VALUES ($1, $2, $3)",
+				migration.Version, migration.Name, migration.Checksum)
+			if err != nil {
+				return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+			log.Printf("Applied migration %d %s", migration.Version, migration.Name)
+			done = append(done, migration)
+		}
+		return nil
+	})
+
+	return done, err
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first, and returns them.
+// Every migration runs in its own transaction, removing its schema_migrations record.
+func (m Migrations) MigrateDown(ctx context.Context, steps int) ([]Migration, error) {
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]Migration)
+	for _, migration := range migrations {
+		byVersion[migration.Version] = migration
+	}
+
+	var done []Migration
+	err = withMigrationsLock(ctx, func(conn *sql.Conn) error {
+		applied, err := readAppliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int64, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Slice(versions, func(i, j int) bool {
+			return versions[i] > versions[j]
+		})
+
+		for i := 0; i < steps && i < len(versions); i++ {
+			migration, ok := byVersion[versions[i]]
+			if !ok {
+				return fmt.Errorf("version %d: %w", versions[i], ErrUnknownMigration)
+			}
+			if migration.Checksum != applied[migration.Version].Checksum {
+				return fmt.Errorf("version %d %s: %w", migration.Version, migration.Name, ErrChecksumMismatch)
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("version %d %s: %w", migration.Version, migration.Name, ErrMissingDownFile)
+			}
+
+			err := runInTx(ctx, conn, migration.Down, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+			log.Printf("Reverted migration %d %s", migration.Version, migration.Name)
+			done = append(done, migration)
+		}
+		return nil
+	})
+
+	return done, err
+}
+
+// MigrationStatus returns every migration known to this build with its applied time, nil if pending.
+func (m Migrations) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var res []MigrationStatus
+	err = withMigrationsLock(ctx, func(conn *sql.Conn) error {
+		applied, err := readAppliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		res = make([]MigrationStatus, len(migrations))
+		for i, migration := range migrations {
+			res[i] = MigrationStatus{Migration: migration}
+			if record, ok := applied[migration.Version]; ok {
+				appliedAt := record.AppliedAt
+				res[i].AppliedAt = &appliedAt
+			}
+		}
+		return nil
+	})
+
+	return res, err
+}
+
+// withMigrationsLock runs f on a dedicated connection holding the migrations advisory lock,
+// after creating the schema_migrations table if missing.
+func withMigrationsLock(ctx context.Context, f func(conn *sql.Conn) error) error {
+	conn, err := pgConnect().Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Advisory locks belong to the connection, they must be released on the same one
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationsLockID); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationsLockID); err != nil {
+			log.Printf("Failed to release migrations lock: %v", err)
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `create table if not exists schema_migrations
+(
+    version    bigint                    not null
+        constraint schema_migrations_pk
+            primary key,
+    name       varchar                   not null,
+    checksum   varchar                   not null,
+    applied_at timestamptz default now() not null
+);
+
+comment on table schema_migrations is 'Applied schema migrations, see the db/migrations directory';
+
+comment on column schema_migrations.checksum is 'SHA-256 of the up SQL, a changed applied migration stops the migrations';
+`)
+	if err != nil {
+		return err
+	}
+
+	return f(conn)
+}
+
+// readAppliedMigrations returns the schema_migrations records by version.
+func readAppliedMigrations(ctx context.Context, conn *sql.Conn) (map[int64]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[int64]appliedMigration)
+	for rows.Next() {
+		var record appliedMigration
+		if err := rows.Scan(&record.Version, &record.Name, &record.Checksum, &record.AppliedAt); err != nil {
+			return nil, err
+		}
+		res[record.Version] = record
+	}
+
+	return res, rows.Err()
+}
+
+// runInTx executes the migration SQL and the schema_migrations bookkeeping query in a single transaction.
+func runInTx(ctx context.Context, conn *sql.Conn, migrationSQL string, query string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, migrationSQL); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	tests := []struct {
+		name         string
+		files        fstest.MapFS
+		wantVersions []int64
+		wantErr      error
+	}{
+		{
+			name: "Ordered by version",
+			files: fstest.MapFS{
+				"0010_later.up.sql":   {Data: []byte("create table later();")},
+				"0002_first.up.sql":   {Data: []byte("create table first();")},
+				"0002_first.down.sql": {Data: []byte("drop table first;")},
+			},
+			wantVersions: []int64{2, 10},
+		},
+		{
+			name:    "Malformed name",
+			files:   fstest.MapFS{"first.sql": {Data: []byte("select 1;")}},
+			wantErr: ErrMalformedMigration,
+		},
+		{
+			name: "Duplicate version",
+			files: fstest.MapFS{
+				"0001_first.up.sql":  {Data: []byte("select 1;")},
+				"0001_second.up.sql": {Data: []byte("select 2;")},
+			},
+			wantErr: ErrDuplicateMigration,
+		},
+		{
+			name:    "Down without up",
+			files:   fstest.MapFS{"0001_first.down.sql": {Data: []byte("select 1;")}},
+			wantErr: ErrMissingUpMigration,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := loadMigrations(tt.files)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("loadMigrations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(migrations) != len(tt.wantVersions) {
+				t.Fatalf("loadMigrations() = %d migrations, want %d", len(migrations), len(tt.wantVersions))
+			}
+			for i, migration := range migrations {
+				if migration.Version != tt.wantVersions[i] {
+					t.Errorf("migration %d version = %d, want %d", i, migration.Version, tt.wantVersions[i])
+				}
+				if len(migration.Checksum) != 64 {
+					t.Errorf("migration %d checksum = %q, want SHA-256 hex", i, migration.Checksum)
+				}
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		t.Fatalf("embeddedMigrations() error = %v", err)
+	}
+	if len(migrations) == 0 || migrations[0].Version != 1 {
+		t.Fatalf("embeddedMigrations() = %d migrations, want versions starting at 1", len(migrations))
+	}
+
+	for _, migration := range migrations {
+		if migration.Down == "" {
+			t.Errorf("migration %d %s has no down file", migration.Version, migration.Name)
+		}
+	}
+}
+
+func TestPendingMigrations(t *testing.T) {
+	migrations, err := loadMigrations(fstest.MapFS{
+		"0001_first.up.sql":  {Data: []byte("create table first();")},
+		"0002_second.up.sql": {Data: []byte("create table second();")},
+	})
+	if err != nil {
+		t.Fatalf("loadMigrations() error = %v", err)
+	}
+
+	t.Run("Applied migrations skipped", func(t *testing.T) {
+		pending, err := pendingMigrations(migrations, map[int64]appliedMigration{
+			1: {Version: 1, Name: "first", Checksum: migrations[0].Checksum},
+			3: {Version: 3, Name: "newer", Checksum: "unknown"},
+		})
+		if err != nil {
+			t.Fatalf("pendingMigrations() error = %v", err)
+		}
+		if len(pending) != 1 || pending[0].Version != 2 {
+			t.Errorf("pendingMigrations() = %v, want version 2 only", pending)
+		}
+	})
+
+	t.Run("Changed applied migration", func(t *testing.T) {
+		_, err := pendingMigrations(migrations, map[int64]appliedMigration{
+			1: {Version: 1, Name: "first", Checksum: "changed"},
+		})
+		if !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("pendingMigrations() error = %v, want %v", err, ErrChecksumMismatch)
+		}
+	})
+}
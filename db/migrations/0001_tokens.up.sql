@@ -0,0 +1,14 @@
+create table if not exists tokens
+(
+    name  varchar not null
+        constraint tokens_pk
+            primary key,
+    value varchar not null
+);
+
+comment on table tokens is 'Tokens table.
+All tokens are encrypted';
+
+comment on column tokens.name is 'Token meaningful name, must be unique';
+
+comment on column tokens.value is 'Encrypted token';
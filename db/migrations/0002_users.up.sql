@@ -0,0 +1,37 @@
+create table if not exists users
+(
+    id            bigserial
+        constraint users_pk
+            primary key,
+    email         varchar                                not null
+        constraint users_email_uk
+            unique,
+    display_name  varchar     default ''                 not null,
+    station       varchar     default ''                 not null,
+    role          varchar     default 'crew'             not null,
+    active        boolean     default true               not null,
+    created_at    timestamptz default now()              not null,
+    last_login_at timestamptz
+);
+
+-- Databases created before the user profile lack its columns
+alter table users
+    add column if not exists display_name varchar default '' not null,
+    add column if not exists station varchar default '' not null,
+    add column if not exists active boolean default true not null;
+
+comment on table users is 'Users known to the application, their profile and role';
+
+comment on column users.id is 'Stable user ID, stored in the JWT sub claim';
+
+comment on column users.email is 'User full lowercase e-mail address, must be unique';
+
+comment on column users.display_name is 'Name shown in the application, blank to show the e-mail';
+
+comment on column users.station is 'Station the user belongs to, blank if unassigned';
+
+comment on column users.role is 'User role: crew or manager';
+
+comment on column users.active is 'Inactive users are refused at login';
+
+comment on column users.last_login_at is 'Last successful login';
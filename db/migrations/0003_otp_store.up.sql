@@ -0,0 +1,18 @@
+create table if not exists otp_store
+(
+    key        varchar     not null
+        constraint otp_store_pk
+            primary key,
+    value      varchar     not null,
+    expires_at timestamptz not null
+);
+
+create index if not exists otp_store_expires_at_idx
+    on otp_store (expires_at);
+
+comment on table otp_store is 'Pending OTPs and their attempts state, shared by all instances.
+OTP codes are stored hashed';
+
+comment on column otp_store.key is 'Entry key, the mailbox for OTPs or a prefixed mailbox for attempts state';
+
+comment on column otp_store.expires_at is 'Entry expiration, expired entries are ignored and periodically purged';
@@ -0,0 +1,24 @@
+create table if not exists sessions
+(
+    id           varchar                   not null
+        constraint sessions_pk
+            primary key,
+    user_email   varchar                   not null,
+    user_agent   varchar     default ''    not null,
+    ip           varchar     default ''    not null,
+    created_at   timestamptz default now() not null,
+    last_seen_at timestamptz default now() not null,
+    expires_at   timestamptz               not null,
+    revoked_at   timestamptz
+);
+
+create index if not exists sessions_user_email_idx
+    on sessions (user_email);
+
+comment on table sessions is 'Login sessions, one for every issued JWT';
+
+comment on column sessions.id is 'Session ID, stored in the JWT jti claim';
+
+comment on column sessions.last_seen_at is 'Last authenticated request';
+
+comment on column sessions.revoked_at is 'Logout or revocation time, revoked sessions are refused';
@@ -0,0 +1,25 @@
+create table if not exists api_keys
+(
+    id           bigserial
+        constraint api_keys_pk
+            primary key,
+    name         varchar                   not null,
+    key_hash     varchar                   not null
+        constraint api_keys_key_hash_uk
+            unique,
+    scopes       text[]      default '{}'  not null,
+    created_by   varchar                   not null,
+    created_at   timestamptz default now() not null,
+    expires_at   timestamptz,
+    last_used_at timestamptz,
+    revoked_at   timestamptz
+);
+
+comment on table api_keys is 'API keys for kiosks and integrations.
+Keys are stored hashed';
+
+comment on column api_keys.key_hash is 'SHA-256 of the key, the key itself is shown once at creation';
+
+comment on column api_keys.scopes is 'Granted scopes, like issues:write';
+
+comment on column api_keys.expires_at is 'Optional expiration, null keys never expire';
@@ -0,0 +1,26 @@
+create table if not exists auth_events
+(
+    id         bigserial
+        constraint auth_events_pk
+            primary key,
+    event      varchar                   not null,
+    user_email varchar     default ''    not null,
+    ip         varchar     default ''    not null,
+    user_agent varchar     default ''    not null,
+    detail     varchar     default ''    not null,
+    created_at timestamptz default now() not null
+);
+
+create index if not exists auth_events_created_at_idx
+    on auth_events (created_at);
+
+create index if not exists auth_events_user_email_idx
+    on auth_events (user_email, created_at);
+
+comment on table auth_events is 'Audit log of the auth flow: OTP requests and checks, mails, refused tokens and logouts';
+
+comment on column auth_events.event is 'Event type, like otp_requested or jwt_rejected';
+
+comment on column auth_events.user_email is 'User full e-mail address, blank if unknown';
+
+comment on column auth_events.detail is 'Refusal reason or other event detail';
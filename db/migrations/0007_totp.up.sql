@@ -0,0 +1,35 @@
+create table if not exists user_totp
+(
+    user_email   varchar                   not null
+        constraint user_totp_pk
+            primary key,
+    secret       varchar                   not null,
+    last_counter bigint      default 0     not null,
+    created_at   timestamptz default now() not null,
+    confirmed_at timestamptz
+);
+
+comment on table user_totp is 'TOTP secrets for authenticator app login.
+Secrets are encrypted as the tokens';
+
+comment on column user_totp.last_counter is 'Time step of the last accepted code, older or same codes are refused';
+
+comment on column user_totp.confirmed_at is 'Time the user confirmed the app with a valid code, pending secrets cannot log in';
+
+create table if not exists totp_recovery_codes
+(
+    id         bigserial
+        constraint totp_recovery_codes_pk
+            primary key,
+    user_email varchar                   not null,
+    code_hash  varchar                   not null,
+    created_at timestamptz default now() not null,
+    used_at    timestamptz,
+    constraint totp_recovery_codes_uk
+        unique (user_email, code_hash)
+);
+
+comment on table totp_recovery_codes is 'Single use recovery codes of the TOTP enrolled users.
+Codes are stored hashed';
+
+comment on column totp_recovery_codes.used_at is 'Time the code was used to log in, used codes are refused';
@@ -0,0 +1,18 @@
+create table if not exists login_policy
+(
+    kind       varchar                   not null,
+    value      varchar                   not null,
+    created_by varchar     default ''    not null,
+    created_at timestamptz default now() not null,
+    constraint login_policy_pk
+        primary key (kind, value),
+    constraint login_policy_kind_ck
+        check (kind in ('domain', 'allow', 'block'))
+);
+
+comment on table login_policy is 'Login policy, checked besides the AUTHDOMAIN domain.
+Blocked addresses win over allowed addresses and domains';
+
+comment on column login_policy.kind is 'Entry kind: domain, allow or block';
+
+comment on column login_policy.value is 'Lowercase domain for domain entries, lowercase full e-mail address otherwise';
@@ -0,0 +1,7 @@
+drop table if exists token_history;
+
+alter table tokens
+    drop column if exists rotated_by,
+    drop column if exists updated_at,
+    drop column if exists created_at,
+    drop column if exists version;
@@ -0,0 +1,37 @@
+alter table tokens
+    add column if not exists version    integer     default 1     not null,
+    add column if not exists created_at timestamptz default now() not null,
+    add column if not exists updated_at timestamptz default now() not null,
+    add column if not exists rotated_by varchar     default ''    not null;
+
+comment on column tokens.version is 'Token version, incremented at every save';
+
+comment on column tokens.created_at is 'First save of the token';
+
+comment on column tokens.updated_at is 'Last save of the token';
+
+comment on column tokens.rotated_by is 'Who saved the current version, like oauth_callback or refresh';
+
+create table if not exists token_history
+(
+    id          bigserial
+        constraint token_history_pk
+            primary key,
+    name        varchar                   not null,
+    version     integer                   not null,
+    value       varchar                   not null,
+    saved_at    timestamptz               not null,
+    saved_by    varchar     default ''    not null,
+    replaced_at timestamptz default now() not null,
+    replaced_by varchar     default ''    not null
+);
+
+create index if not exists token_history_name_idx
+    on token_history (name, version);
+
+comment on table token_history is 'Prior versions of the tokens, replaced or deleted.
+All tokens are encrypted';
+
+comment on column token_history.saved_at is 'Time the version was saved in the tokens table';
+
+comment on column token_history.replaced_by is 'Who replaced or deleted the version';
@@ -0,0 +1,2 @@
+-- Merged users, deleted TOTP secrets and the original e-mail case can't be restored
+drop index if exists users_email_lower_uk;
@@ -0,0 +1,46 @@
+-- Users differing only by the e-mail case are merged into the oldest one, whose ID is in the JWT sub claim.
+-- The merged user keeps the latest login and is inactive if any of its duplicates is.
+update users u
+set last_login_at = d.last_login_at,
+    active        = d.active
+from (select min(id) as id, max(last_login_at) as last_login_at, bool_and(active) as active
+      from users
+      group by lower(email)
+      having count(*) > 1) d
+where u.id = d.id;
+
+delete
+from users u
+    using users k
+where lower(k.email) = lower(u.email)
+  and k.id < u.id;
+
+update users
+set email = lower(email)
+where email <> lower(email);
+
+create unique index if not exists users_email_lower_uk
+    on users (lower(email));
+
+comment on index users_email_lower_uk is 'Case insensitive uniqueness of the user e-mail';
+
+-- Sessions follow their user, whatever the case of the login e-mail
+update sessions
+set user_email = lower(user_email)
+where user_email <> lower(user_email);
+
+-- TOTP secrets are sealed with their user e-mail as additional data, see totpAAD, so secrets enrolled
+-- with a mixed case e-mail can't be opened once renamed: they are deleted, with their recovery codes,
+-- and their users enroll again. Secrets enrolled with the lowercase e-mail are kept.
+delete
+from user_totp
+where user_email <> lower(user_email);
+
+delete
+from totp_recovery_codes c
+where not exists(select 1 from user_totp t where t.user_email = c.user_email);
+
+-- Auth events are filtered by the lowercase user e-mail
+update auth_events
+set user_email = lower(user_email)
+where user_email <> lower(user_email);
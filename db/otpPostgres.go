@@ -0,0 +1,139 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// postgresOtpPurgeInterval is the interval between two deletions of expired keys
+const postgresOtpPurgeInterval = time.Minute
+
+// PostgresOtpStore is an OtpStore backed by the otp_store table, shared by every instance of the service.
+// Expiration is computed by the database clock, so instances with skewed clocks agree on it.
+// Errors of Get, Incr and CompareAndDelete are returned, the other errors are logged as the OtpStore interface doesn't surface them.
+type PostgresOtpStore struct {
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewPostgresOtpStore creates a new Postgres OTP store and starts the purge of expired keys.
+func NewPostgresOtpStore() *PostgresOtpStore {
+	store := &PostgresOtpStore{
+		done: make(chan struct{}),
+	}
+	go store.janitor()
+	return store
+}
+
+// Set sets the value for the specified key, the key expires after DefaultTTL.
+func (s *PostgresOtpStore) Set(key string, value string) {
+	s.SetWithTTL(key, value, DefaultTTL)
+}
+
+// SetWithTTL sets the value for the specified key, the key expires after ttl.
+func (s *PostgresOtpStore) SetWithTTL(key string, value string, ttl time.Duration) {
+	db := pgConnect()
+
+	_, err := db.Exec(`INSERT INTO otp_store(key, value, expires_at) VALUES ($1, $2, now() + $3 * interval '1 millisecond')
+ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`, key, value, ttl.Milliseconds())
+	if err != nil {
+		log.Printf("Failed to set OTP store key: %v", err)
+	}
+}
+
+// Incr atomically adds delta to the integer value of the key and returns the new value, in a single statement
+// so concurrent increments from every instance are all counted.
+// Missing, expired or non numeric keys count as 0 and expire after ttl, incremented keys keep their expiration.
+func (s *PostgresOtpStore) Incr(key string, delta int, ttl time.Duration) (int, error) {
+	db := pgConnect()
+
+	var value int
+	err := db.QueryRow(`INSERT INTO otp_store(key, value, expires_at) VALUES ($1, $2::int::varchar, now() + $3 * interval '1 millisecond')
+ON CONFLICT (key) DO UPDATE SET value      = CASE
+                                                 WHEN otp_store.expires_at > now() AND otp_store.value ~ '^-?[0-9]+$'
+                                                     THEN (otp_store.value::int + $2)::varchar
+                                                 ELSE excluded.value END,
+                                expires_at = CASE
+                                                 WHEN otp_store.expires_at > now() AND otp_store.value ~ '^-?[0-9]+$'
+                                                     THEN otp_store.expires_at
+                                                 ELSE excluded.expires_at END
+RETURNING value::int`, key, delta, ttl.Milliseconds()).Scan(&value)
+	if err != nil {
+		log.Printf("Failed to increment OTP store key: %v", err)
+		return 0, err
+	}
+
+	return value, nil
+}
+
+// Get retrieves a value for a key, expired keys are reported as missing.
+// Errors other than a missing key are returned, not reported as missing.
+func (s *PostgresOtpStore) Get(key string) (string, bool, error) {
+	db := pgConnect()
+
+	var value string
+	err := db.QueryRow("SELECT value FROM otp_store WHERE key = $1 AND expires_at > now()", key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		log.Printf("Failed to get OTP store key: %v", err)
+		return "", false, err
+	}
+
+	return value, true, nil
+}
+
+// CompareAndDelete deletes the key if it isn't expired and holds the value, and reports whether it did.
+// The check and the deletion are a single statement, so concurrent callers from every instance can't all consume the value.
+func (s *PostgresOtpStore) CompareAndDelete(key string, value string) (bool, error) {
+	db := pgConnect()
+
+	res, err := db.Exec("DELETE FROM otp_store WHERE key = $1 AND value = $2 AND expires_at > now()", key, value)
+	if err != nil {
+		log.Printf("Failed to delete OTP store key: %v", err)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// Delete removes a value for a key
+func (s *PostgresOtpStore) Delete(key string) {
+	db := pgConnect()
+
+	if _, err := db.Exec("DELETE FROM otp_store WHERE key = $1", key); err != nil {
+		log.Printf("Failed to delete OTP store key: %v", err)
+	}
+}
+
+// Close stops the purge of expired keys. It is safe to call Close more than once.
+func (s *PostgresOtpStore) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// janitor periodically deletes expired keys, Get already ignores them so this only keeps the table small.
+func (s *PostgresOtpStore) janitor() {
+	ticker := time.NewTicker(postgresOtpPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := pgConnect().Exec("DELETE FROM otp_store WHERE expires_at <= now()"); err != nil {
+				log.Printf("Failed to purge OTP store: %v", err)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// OTP store backends
+const (
+	OtpStoreMemory   = "memory"   // Process local store, only suitable for a single instance
+	OtpStorePostgres = "postgres" // Store shared by every instance connected to the same database
+)
+
+// OtpStore is a key value store with expiring keys, used to keep pending OTPs and their attempts state.
+// Implementations must be safe for concurrent use.
+type OtpStore interface {
+	// Set sets the value for the key, the key expires after DefaultTTL
+	Set(key string, value string)
+	// SetWithTTL sets the value for the key, the key expires after ttl
+	SetWithTTL(key string, value string, ttl time.Duration)
+	// Incr atomically adds delta to the integer value of the key and returns the new value.
+	// Missing, expired or non numeric keys count as 0 and expire after ttl, incremented keys keep their expiration.
+	// It is the only safe way to count with a store shared by several instances.
+	Incr(key string, delta int, ttl time.Duration) (int, error)
+	// Get retrieves the value for the key, expired keys are reported as missing.
+	// Store failures are returned, callers guarding a limit must refuse rather than treat the key as missing.
+	Get(key string) (string, bool, error)
+	// CompareAndDelete atomically deletes the key if it isn't expired and holds the value, and reports whether it did.
+	// Of concurrent callers passing the same value, only one is reported the deletion, so the value is consumed once.
+	CompareAndDelete(key string, value string) (bool, error)
+	// Delete removes the key
+	Delete(key string)
+	// Close stops any background expiry activity
+	Close()
+}
+
+// NewOtpStore creates the OTP store for the given backend name.
+// It returns an error for unknown backends.
+func NewOtpStore(backend string) (OtpStore, error) {
+	switch backend {
+	case OtpStoreMemory, "":
+		return NewDB(), nil
+	case OtpStorePostgres:
+		return NewPostgresOtpStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown OTP store backend: %s", backend)
+	}
+}
+
+// Compile time check of the OtpStore implementations
+var (
+	_ OtpStore = (*InMemoryDb)(nil)
+	_ OtpStore = (*PostgresOtpStore)(nil)
+)
@@ -37,43 +37,9 @@ func pgConnect() *sql.DB {
 
 		log.Println("Connected to the database")
 
-		createTables(db)
-
+		// Schema is created and updated by the migrations, see Migrations.MigrateUp
 		instance = db
 	})
 
 	return instance
 }
-
-// The function takes a *sql.DB as the input parameter and creates the following tables if they do not already exist:
-// - tokens: This table stores encrypted tokens, with columns name and value.
-// - The table and column names have appropriate comments assigned to them for better understanding.
-// The function iterates through the list of queries and executes each query using the provided DB connection.
-// If there is an error during query execution, the error along with the corresponding query is logged.
-func createTables(db *sql.DB) {
-	queries := []string{
-		`create table if not exists tokens
-(
-    name  varchar not null
-        constraint tokens_pk
-            primary key,
-    value varchar not null
-);
-
-comment on table tokens is 'Tokens table.
-All tokens are encrypted';
-
-comment on column tokens.name is 'Token meaningful name, must be unique';
-
-comment on column tokens.value is 'Encrypted token';
-
-`,
-	}
-
-	// Actually create all table in db if not exists
-	for _, query := range queries {
-		if _, err := db.Exec(query); err != nil {
-			log.Printf("error executing query: %s: %v", query, err)
-		}
-	}
-}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"time"
+)
+
+// Session represents a login session of the sessions table.
+type Session struct {
+	ID         string    `json:"id"`
+	UserEmail  string    `json:"userEmail"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+type Sessions struct {
+}
+
+// CreateSession stores a new session. The session ID must be unique.
+func (s Sessions) CreateSession(session Session) error {
+	db := pgConnect()
+
+	_, err := db.Exec("INSERT INTO sessions(id, user_email, user_agent, ip, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		session.ID, session.UserEmail, session.UserAgent, session.IP, session.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// TouchSession marks the session as seen now and returns it.
+// It returns sql.ErrNoRows if the session doesn't exist, is revoked, expired or was not seen for longer than idle.
+func (s Sessions) TouchSession(id string, idle time.Duration) (Session, error) {
+	db := pgConnect()
+
+	var session Session
+	err := db.QueryRow(`UPDATE sessions SET last_seen_at = now()
+WHERE id = $1 AND revoked_at IS NULL AND expires_at > now() AND last_seen_at > now() - $2 * interval '1 second'
+RETURNING id, user_email, user_agent, ip, created_at, last_seen_at, expires_at`, id, idle.Seconds()).
+		Scan(&session.ID, &session.UserEmail, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+	if err != nil {
+		return Session{}, err
+	}
+
+	return session, nil
+}
+
+// ListSessions returns the active sessions of the user, that is not revoked, expired or idle for longer than idle,
+// most recently seen first.
+func (s Sessions) ListSessions(email string, idle time.Duration) ([]Session, error) {
+	db := pgConnect()
+
+	rows, err := db.Query(`SELECT id, user_email, user_agent, ip, created_at, last_seen_at, expires_at FROM sessions
+WHERE user_email = $1 AND revoked_at IS NULL AND expires_at > now() AND last_seen_at > now() - $2 * interval '1 second'
+ORDER BY last_seen_at DESC`, email, idle.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]Session, 0)
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(&session.ID, &session.UserEmail, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt); err != nil {
+			return nil, err
+		}
+		res = append(res, session)
+	}
+
+	return res, rows.Err()
+}
+
+// RevokeSession revokes a single session, revoking an already revoked session has no effect.
+func (s Sessions) RevokeSession(id string) error {
+	db := pgConnect()
+
+	_, err := db.Exec("UPDATE sessions SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokeUserSessions revokes every active session of the user and returns how many were revoked.
+func (s Sessions) RevokeUserSessions(email string) (int64, error) {
+	db := pgConnect()
+
+	res, err := db.Exec("UPDATE sessions SET revoked_at = now() WHERE user_email = $1 AND revoked_at IS NULL", email)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
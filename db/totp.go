@@ -0,0 +1,162 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrTotpAlreadyEnrolled is returned when enrolling a user whose TOTP is already confirmed
+var ErrTotpAlreadyEnrolled = errors.New("totp already enrolled")
+
+// UserTotp represents the TOTP enrollment of a user of the user_totp table.
+type UserTotp struct {
+	UserEmail   string     `json:"userEmail"`
+	Secret      string     `json:"-"` // Decrypted base32 secret
+	LastCounter int64      `json:"-"` // Time step of the last accepted code
+	CreatedAt   time.Time  `json:"createdAt"`
+	ConfirmedAt *time.Time `json:"confirmedAt,omitempty"` // Nil until the user proves the app is set up
+}
+
+type Totp struct {
+}
+
+// SaveTotpSecret stores a new pending TOTP secret for the user, AES encrypted as the tokens.
+// A pending secret is replaced, a confirmed one returns ErrTotpAlreadyEnrolled and must be deleted first.
+func (t Totp) SaveTotpSecret(email string, secret string) error {
+	db := pgConnect()
+
+	ring, err := CurrentAESKeyRing()
+	if err != nil {
+		return err
+	}
+	encryptedSecret, err := ring.Encrypt(secret, totpAAD(email))
+	if err != nil {
+		return err
+	}
+
+	res, err := db.Exec(`INSERT INTO user_totp(user_email, secret) VALUES ($1, $2)
+ON CONFLICT (user_email) DO UPDATE SET secret = excluded.secret, last_counter = 0, created_at = now()
+WHERE user_totp.confirmed_at IS NULL`, email, encryptedSecret)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrTotpAlreadyEnrolled
+	}
+
+	return nil
+}
+
+// GetTotp returns the TOTP enrollment of the user with the decrypted secret.
+// It returns sql.ErrNoRows if the user never enrolled.
+func (t Totp) GetTotp(email string) (UserTotp, error) {
+	db := pgConnect()
+
+	var totp UserTotp
+	var encryptedSecret string
+	err := db.QueryRow("SELECT user_email, secret, last_counter, created_at, confirmed_at FROM user_totp WHERE user_email = $1", email).
+		Scan(&totp.UserEmail, &encryptedSecret, &totp.LastCounter, &totp.CreatedAt, &totp.ConfirmedAt)
+	if err != nil {
+		return UserTotp{}, err
+	}
+
+	ring, err := CurrentAESKeyRing()
+	if err != nil {
+		return UserTotp{}, err
+	}
+	totp.Secret, err = ring.Decrypt(encryptedSecret, totpAAD(email))
+	if err != nil {
+		return UserTotp{}, err
+	}
+
+	return totp, nil
+}
+
+// ConfirmTotp confirms the pending TOTP of the user, accepting the code of the given time step,
+// and replaces the recovery codes with the given hashes.
+// It returns sql.ErrNoRows if the user has no pending TOTP or the time step was already used.
+func (t Totp) ConfirmTotp(email string, counter int64, recoveryHashes []string) error {
+	db := pgConnect()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE user_totp SET confirmed_at = now(), last_counter = $2
+WHERE user_email = $1 AND confirmed_at IS NULL AND last_counter < $2`, email, counter)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if _, err := tx.Exec("DELETE FROM totp_recovery_codes WHERE user_email = $1", email); err != nil {
+		return err
+	}
+	for _, hash := range recoveryHashes {
+		if _, err := tx.Exec("INSERT INTO totp_recovery_codes(user_email, code_hash) VALUES ($1, $2)", email, hash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseTotpCounter accepts a code of the given time step for the confirmed TOTP of the user.
+// It returns false if the user has no confirmed TOTP or a code of the same or a later time step
+// was already accepted, so every code can be used once.
+func (t Totp) UseTotpCounter(email string, counter int64) (bool, error) {
+	db := pgConnect()
+
+	res, err := db.Exec(`UPDATE user_totp SET last_counter = $2
+WHERE user_email = $1 AND confirmed_at IS NOT NULL AND last_counter < $2`, email, counter)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// UseRecoveryCode marks the recovery code with the given hash as used.
+// It returns false if the code doesn't belong to the user or was already used.
+func (t Totp) UseRecoveryCode(email string, codeHash string) (bool, error) {
+	db := pgConnect()
+
+	res, err := db.Exec(`UPDATE totp_recovery_codes SET used_at = now()
+WHERE user_email = $1 AND code_hash = $2 AND used_at IS NULL`, email, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// DeleteTotp removes the TOTP enrollment and the recovery codes of the user.
+func (t Totp) DeleteTotp(email string) error {
+	db := pgConnect()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM totp_recovery_codes WHERE user_email = $1", email); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_totp WHERE user_email = $1", email); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
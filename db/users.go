@@ -0,0 +1,121 @@
+package db
+
+import (
+	"time"
+)
+
+// User represents a user record of the users table.
+type User struct {
+	ID          int64      `json:"id"`
+	Email       string     `json:"email"`
+	DisplayName string     `json:"displayName"`
+	Station     string     `json:"station"`
+	Role        string     `json:"role"`
+	Active      bool       `json:"active"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	LastLoginAt *time.Time `json:"lastLoginAt,omitempty"`
+}
+
+// UserProfile holds the user fields changed by UpdateUser, nil fields are left unchanged.
+type UserProfile struct {
+	DisplayName *string `json:"displayName,omitempty"`
+	Station     *string `json:"station,omitempty"`
+	Active      *bool   `json:"active,omitempty"`
+}
+
+type Users struct {
+}
+
+// userColumns are the users table columns scanned by scanUser
+const userColumns = "id, email, display_name, station, role, active, created_at, last_login_at"
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser scans a row selected with userColumns.
+func scanUser(row rowScanner) (User, error) {
+	var user User
+	err := row.Scan(&user.ID, &user.Email, &user.DisplayName, &user.Station, &user.Role, &user.Active, &user.CreatedAt, &user.LastLoginAt)
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// EnsureUser registers the user on first login and updates the last login time otherwise.
+// It returns the stored user, new users get the default role of the users table and are active.
+// The last login time of inactive users is left unchanged, as they are refused.
+func (u Users) EnsureUser(email string) (User, error) {
+	db := pgConnect()
+
+	return scanUser(db.QueryRow(`INSERT INTO users(email, last_login_at) VALUES ($1, now())
+ON CONFLICT (email) DO UPDATE SET last_login_at = CASE WHEN users.active THEN now() ELSE users.last_login_at END
+RETURNING `+userColumns, email))
+}
+
+// GetUser returns the user with the given ID.
+// It returns sql.ErrNoRows if the user doesn't exist.
+func (u Users) GetUser(id int64) (User, error) {
+	db := pgConnect()
+
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = $1", id))
+}
+
+// GetUserByEmail returns the user with the given e-mail.
+// It returns sql.ErrNoRows if the user doesn't exist.
+func (u Users) GetUserByEmail(email string) (User, error) {
+	db := pgConnect()
+
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE email = $1", email))
+}
+
+// SetRole sets the role of the user with the given e-mail.
+// If the user never logged in, it is created with the given role.
+func (u Users) SetRole(email string, role string) error {
+	db := pgConnect()
+
+	_, err := db.Exec(`INSERT INTO users(email, role) VALUES ($1, $2)
+ON CONFLICT (email) DO UPDATE SET role = excluded.role`, email, role)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateUser changes the profile of the user with the given e-mail and returns the updated user.
+// If the user never logged in, it is created with the given profile.
+func (u Users) UpdateUser(email string, profile UserProfile) (User, error) {
+	db := pgConnect()
+
+	return scanUser(db.QueryRow(`INSERT INTO users(email, display_name, station, active)
+VALUES ($1, coalesce($2, ''), coalesce($3, ''), coalesce($4, true))
+ON CONFLICT (email) DO UPDATE SET display_name = coalesce($2, users.display_name),
+                                  station      = coalesce($3, users.station),
+                                  active       = coalesce($4, users.active)
+RETURNING `+userColumns, email, profile.DisplayName, profile.Station, profile.Active))
+}
+
+// ListUsers returns all the users ordered by e-mail.
+func (u Users) ListUsers() ([]User, error) {
+	db := pgConnect()
+
+	rows, err := db.Query("SELECT " + userColumns + " FROM users ORDER BY email")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, user)
+	}
+
+	return res, rows.Err()
+}
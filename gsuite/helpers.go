@@ -4,38 +4,71 @@ import (
 	"aat-manager/db"
 	"aat-manager/utils"
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/sheets/v4"
 	"log"
-	"net/http"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"sync"
 	"time"
 )
 
-type Token struct {
-	Token *oauth2.Token // oauth2 signed Token
-	Err   error         // error
-}
+// oauthStateKey is the key of the pending authorization state in the shared state store
+const oauthStateKey = "google-oauth-state"
+
+// oauthStateTTL is the lifetime of an authorization state, a new one is generated once expired
+const oauthStateTTL = 24 * time.Hour
+
+// oauthStateBytes is the length of the random authorization state
+const oauthStateBytes = 32
 
+// SharedState holds the state of the pending authorization, checked by the OAuth callback.
+// With a store, like the Postgres OTP store, the state is shared by every instance, so the callback
+// may reach another instance than the one that generated the authorization URL.
 type SharedState struct {
-	state string
+	state string      // Pending state without a store
+	store db.OtpStore // Shared store of the state, see UseStateStore
 	mux   sync.Mutex
 }
 
 var sharedState = &SharedState{}
 
-var TokenCh = make(chan Token) // TokenCh to xchange oauth2 token
+// UseStateStore sets the store of the authorization state.
+// Without a store the state is kept in memory, and the callback must reach the instance that generated it.
+func UseStateStore(store db.OtpStore) {
+	sharedState.mux.Lock()
+	defer sharedState.mux.Unlock()
+
+	sharedState.store = store
+}
+
+// useWebAuth reports whether the token is authorized through the web callback and stored in db,
+// otherwise it is authorized from the console and stored in TokenFile.
+func useWebAuth() bool {
+	webAuth, _ := strconv.ParseBool(utils.ReadEnvOrPanic(utils.WEBAUTH))
+	return webAuth
+}
 
 // GetState Getter for state
 func GetState() string {
 	sharedState.mux.Lock()
 	defer sharedState.mux.Unlock()
 
-	return sharedState.state
+	if sharedState.store == nil {
+		return sharedState.state
+	}
+	// A failed read returns no state, the callback is then refused
+	state, _, _ := sharedState.store.Get(oauthStateKey)
+	return state
 }
 
 // SetState Setter for state
@@ -43,43 +76,46 @@ func SetState(state string) {
 	sharedState.mux.Lock()
 	defer sharedState.mux.Unlock()
 
-	sharedState.state = state
+	if sharedState.store == nil {
+		sharedState.state = state
+		return
+	}
+	sharedState.store.SetWithTTL(oauthStateKey, state, oauthStateTTL)
 }
 
-// Retrieve a token, saves the token, then returns the generated client.
-func getClient(config *oauth2.Config) *http.Client {
-	// The file token.json stores the user's access and refresh tokens, and is
-	// created automatically when the authorization flow completes for the first
-	// time.
+// CheckState reports whether the state received by the OAuth callback is the pending one, in constant time.
+// It is false if no authorization is pending.
+func CheckState(state string) bool {
+	pending := GetState()
+	return pending != "" && subtle.ConstantTimeCompare([]byte(state), []byte(pending)) == 1
+}
 
-	var err error
-	var tok *oauth2.Token
-	tokFile := "token.json"
+// ClearState deletes the pending state once the authorization is completed, so the callback can't be replayed.
+func ClearState() {
+	sharedState.mux.Lock()
+	defer sharedState.mux.Unlock()
 
-	useWebAuth, _ := strconv.ParseBool(utils.ReadEnvOrPanic(utils.WEBAUTH))
-	if useWebAuth {
-		tok, err = tokenFromDb()
-	} else {
-		tok, err = tokenFromFile(tokFile)
+	if sharedState.store == nil {
+		sharedState.state = ""
+		return
 	}
+	sharedState.store.Delete(oauthStateKey)
+}
 
-	if err != nil {
-		if useWebAuth {
-			getTokenFromWeb(config)
-			go func() {
-				// Listen to channel for signed token or error
-				token := <-TokenCh
-				if token.Err != nil {
-					log.Printf("Unable to retrieve token from web: %v", token.Err)
-				}
-				saveTokenToDb(token.Token)
-			}()
-		} else {
-			tok = getTokenFromWebToConsole(config)
-			saveToken(tokFile, tok)
-		}
-	}
-	return config.Client(context.Background(), tok)
+// newState returns a random hex encoded authorization state.
+func newState() (string, error) {
+	b := make([]byte, oauthStateBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// APIConfig returns the OAuth2 config of the Gmail/Sheets authorization, from the GSECRET client credentials.
+func APIConfig() (*oauth2.Config, error) {
+	b := utils.ReadEnvOrPanic(utils.GOOGLECREDENTIAL)
+
+	return google.ConfigFromJSON([]byte(b), gmail.GmailSendScope, sheets.SpreadsheetsScope)
 }
 
 // Request a token from the web, then returns the retrieved token.
@@ -100,12 +136,23 @@ func getTokenFromWebToConsole(config *oauth2.Config) *oauth2.Token {
 	return tok
 }
 
-// getTokenFromWeb generates an OAuth2.0 URL, sets a random state string, and prints the authorization URL for the user to follow in their browser to authorize the application.
-// The generated state is set as a shared state for later processing.
-// This function does not return any value.
-func getTokenFromWeb(config *oauth2.Config) {
+// getTokenFromWeb returns the OAuth2.0 URL the user follows in their browser to authorize the application.
+// The URL carries the pending shared state, checked by the callback: unless renew is set, the state of an authorization
+// started by another instance is reused, so every instance shows the same URL. Otherwise, or if no state is pending,
+// a random state is generated, shared and the new authorization URL is printed.
+// If no state can be generated it returns an empty URL, the authorization can then be renewed with a reset.
+func getTokenFromWeb(config *oauth2.Config, renew bool) string {
+	state := GetState()
+	if !renew && state != "" {
+		return config.AuthCodeURL(state, oauth2.AccessTypeOffline)
+	}
+
 	// create a random state string
-	state := fmt.Sprintf("st%d", time.Now().UnixNano())
+	state, err := newState()
+	if err != nil {
+		log.Printf("Unable to generate authorization state: %v", err)
+		return ""
+	}
 
 	// Set state for handler to process
 	SetState(state)
@@ -116,6 +163,7 @@ func getTokenFromWeb(config *oauth2.Config) {
 	// print the url to authorize
 	fmt.Printf("Go to the following link in your browser:\n%v\n", authURL)
 
+	return authURL
 }
 
 // Retrieves a token from a local file.
@@ -130,15 +178,28 @@ func tokenFromFile(file string) (*oauth2.Token, error) {
 	return tok, err
 }
 
-// Saves a token to a file path.
-func saveToken(path string, token *oauth2.Token) {
-	fmt.Printf("Saving credential file to: %s\n", path)
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+// writeTokenFile replaces the token file at path, through a temporary file renamed over it,
+// so a concurrent reader never sees a partially written token.
+func writeTokenFile(path string, token *oauth2.Token) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
 	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+		return err
 	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
+	defer os.Remove(f.Name()) // No-op once renamed
+
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(token); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
 }
 
 // Retrieve token from db
@@ -157,19 +218,24 @@ func tokenFromDb() (*oauth2.Token, error) {
 	return token, nil
 }
 
-// Save token to db
-func saveTokenToDb(token *oauth2.Token) {
-	// Serialize token to string
+// writeTokenToDb serializes the token and saves it to db, recording savedBy in the token history.
+func writeTokenToDb(token *oauth2.Token, savedBy string) error {
 	stringToken, err := json.Marshal(token)
 	if err != nil {
-		log.Fatalf("Unable to marshal token: %v", err)
+		return err
 	}
 
-	// Save token to DB
-	err = db.Token{}.SaveToken(string(stringToken))
+	return db.Token{}.SaveToken(string(stringToken), savedBy)
+}
+
+// updateTokenInDb serializes the token and replaces the stored one in place, see db.Token.UpdateToken.
+func updateTokenInDb(token *oauth2.Token) error {
+	stringToken, err := json.Marshal(token)
 	if err != nil {
-		log.Fatalf("Unable to save token to db: %v", err)
+		return err
 	}
+
+	return db.Token{}.UpdateToken(string(stringToken))
 }
 
 // -------------------------
@@ -108,3 +108,28 @@ func TestColNumToName(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckState(t *testing.T) {
+	SetState("")
+	if CheckState("") {
+		t.Errorf("CheckState() without pending state = true, want false")
+	}
+
+	state, err := newState()
+	if err != nil {
+		t.Fatalf("newState() error = %v", err)
+	}
+	SetState(state)
+	if !CheckState(state) {
+		t.Errorf("CheckState() of the pending state = false, want true")
+	}
+	if CheckState(state[1:]) {
+		t.Errorf("CheckState() of another state = true, want false")
+	}
+
+	// A completed authorization consumes the state
+	ClearState()
+	if CheckState(state) {
+		t.Errorf("CheckState() after ClearState = true, want false")
+	}
+}
@@ -0,0 +1,52 @@
+package gsuite
+
+import (
+	"aat-manager/utils"
+	"context"
+	"errors"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/idtoken"
+)
+
+// ErrMissingIDToken is returned when the token exchange answers without OpenID Connect ID token
+var ErrMissingIDToken = errors.New("id token missing from token response")
+
+// LoginScopes are the OpenID Connect scopes requested to sign in staff with Google
+var LoginScopes = []string{"openid", "email", "profile"}
+
+// LoginConfig returns the OAuth2 config to sign in staff with their Google Workspace account.
+// It uses the same client credentials of the Gmail/Sheets authorization, with the given redirect URL.
+func LoginConfig(redirectURL string) (*oauth2.Config, error) {
+	b := utils.ReadEnvOrPanic(utils.GOOGLECREDENTIAL)
+
+	config, err := google.ConfigFromJSON([]byte(b), LoginScopes...)
+	if err != nil {
+		return nil, err
+	}
+	config.RedirectURL = redirectURL
+
+	return config, nil
+}
+
+// ExchangeLoginCode exchanges the authorization code and validates the returned ID token.
+// The token signature, expiration and audience are checked against Google public keys,
+// the token claims are returned for the application checks.
+func ExchangeLoginCode(ctx context.Context, config *oauth2.Config, code string) (map[string]interface{}, error) {
+	token, err := config.Exchange(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, ErrMissingIDToken
+	}
+
+	payload, err := idtoken.Validate(ctx, rawIDToken, config.ClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	return payload.Claims, nil
+}
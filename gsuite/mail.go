@@ -1,45 +1,32 @@
 package gsuite
 
 import (
-	"aat-manager/utils"
 	"bytes"
-	"context"
 	"encoding/base64"
-	"golang.org/x/oauth2/google"
 	"google.golang.org/api/gmail/v1"
-	"google.golang.org/api/option"
-	"google.golang.org/api/sheets/v4"
-	"log"
 )
 
 type MailService struct {
-	srv *gmail.Service
+	manager *Manager
 }
 
-// New initializes and returns a new instance of the MailService struct.
-// It requires a credentials.json file to be present in the working directory.
-// It returns the new MailService struct with a Gmail service and any error encountered while initializing.
-func (ms MailService) New() (MailService, error) {
-	ctx := context.Background()
-	b := utils.ReadEnvOrPanic(utils.GOOGLECREDENTIAL)
+// New returns a new instance of the MailService struct, sending through the Gmail service bound by the manager.
+// The service can be created before the integration is authorized, mails are sent once it is ready.
+func (ms MailService) New(manager *Manager) (MailService, error) {
+	return MailService{manager: manager}, nil
+}
 
-	config, err := google.ConfigFromJSON([]byte(b), gmail.GmailSendScope, sheets.SpreadsheetsScope)
-	if err != nil {
-		log.Fatalf("Unable to parse client secret file to config: %v", err)
+// SendMail sends an email with the given subject, recipient, and message.
+// It returns an error if the email sending fails, ErrNotReady while the integration is not authorized.
+func (ms MailService) SendMail(subject string, to string, message string) error {
+	if ms.manager == nil {
+		return ErrNotReady
 	}
-	client := getClient(config)
-
-	srv, err := gmail.NewService(ctx, option.WithHTTPClient(client))
+	srv, err := ms.manager.Gmail()
 	if err != nil {
-		log.Fatalf("Unable to retrieve Gmail client: %v", err)
+		return err
 	}
 
-	return MailService{srv: srv}, nil
-}
-
-// SendMail sends an email with the given subject, recipient, and message.
-// It returns an error if the email sending fails.
-func (ms MailService) SendMail(subject string, to string, message string) error {
 	var email bytes.Buffer
 	//email.WriteString("From: AAT One Time Password Provider\r\n")
 	email.WriteString("To: " + to + "\r\n")
@@ -51,7 +38,7 @@ func (ms MailService) SendMail(subject string, to string, message string) error
 	var msg gmail.Message
 	msg.Raw = raw
 
-	_, err := ms.srv.Users.Messages.Send("me", &msg).Do()
+	_, err = srv.Users.Messages.Send("me", &msg).Do()
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,285 @@
+package gsuite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrNotReady is returned by the Google services while the integration is not authorized
+var ErrNotReady = errors.New("google integration not ready")
+
+// ErrNotAuthorizing is returned by CompleteAuthorization when no authorization is pending
+var ErrNotAuthorizing = errors.New("google integration not waiting for an authorization")
+
+// IntegrationState is the state of the Google integration, see Manager.
+type IntegrationState string
+
+const (
+	StateUnauthorized IntegrationState = "unauthorized" // No token, the integration is not started or was reset
+	StateAuthorizing  IntegrationState = "authorizing"  // Waiting for the token through the OAuth callback
+	StateReady        IntegrationState = "ready"        // Services bound to the token
+	StateFailed       IntegrationState = "failed"       // Authorization or service binding failed, the callback may be retried
+)
+
+// IntegrationStatus is the state of the Google integration as reported to the administrators.
+type IntegrationStatus struct {
+	State   IntegrationState `json:"state"`
+	Since   time.Time        `json:"since"`             // Time of the last state change
+	AuthURL string           `json:"authUrl,omitempty"` // URL to authorize the integration, while authorizing or failed
+	Error   string           `json:"error,omitempty"`   // Failure reason
+}
+
+// tokenReloadInterval is the minimum time between two reloads of the stored token, see Manager.reload
+const tokenReloadInterval = 30 * time.Second
+
+// Manager binds the Google services to the API token.
+// Without a stored token, in web auth mode it waits for the token of the OAuth callback, see CompleteAuthorization,
+// and binds the services once it arrives, so they work without a restart.
+// The token is shared by every instance, each one reloads it to follow the authorizations and deletions
+// made on the others, see reload.
+type Manager struct {
+	mux    sync.Mutex
+	config *oauth2.Config
+	status IntegrationStatus
+
+	// Bound services, set only in StateReady
+	gmail  *gmail.Service
+	sheets *sheets.Service
+	token  *oauth2.Token // Token the services are bound to
+
+	// Storage of the token, set by NewManager according to utils.WEBAUTH
+	webAuth   bool
+	loadToken func() (*oauth2.Token, error)
+	saveToken func(*oauth2.Token) error
+
+	reloadInterval time.Duration // Minimum time between two reloads of the stored token
+	reloadedAt     time.Time     // Last load of the stored token
+}
+
+// NewManager returns an unauthorized manager of the integration using config,
+// storing the token in db or TokenFile according to utils.WEBAUTH.
+func NewManager(config *oauth2.Config) *Manager {
+	m := &Manager{
+		config:         config,
+		status:         IntegrationStatus{State: StateUnauthorized, Since: time.Now()},
+		webAuth:        useWebAuth(),
+		reloadInterval: tokenReloadInterval,
+	}
+	if m.webAuth {
+		m.loadToken = tokenFromDb
+		m.saveToken = func(tok *oauth2.Token) error {
+			return writeTokenToDb(tok, TokenSavedByCallback)
+		}
+	} else {
+		m.loadToken = func() (*oauth2.Token, error) {
+			return tokenFromFile(TokenFile)
+		}
+		m.saveToken = func(tok *oauth2.Token) error {
+			fmt.Printf("Saving credential file to: %s\n", TokenFile)
+			return writeTokenFile(TokenFile, tok)
+		}
+	}
+	return m
+}
+
+// Start binds the services to the stored token.
+// Without a stored token, in web auth mode it waits for the callback of the authorization pending on another instance,
+// or prints a new authorization URL, otherwise it asks the authorization code on the console.
+func (m *Manager) Start() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.reloadedAt = time.Now()
+	tok, err := m.loadToken()
+	if err == nil {
+		m.bind(tok)
+		return
+	}
+	log.Printf("No usable Google token stored: %v", err)
+
+	if !m.webAuth {
+		tok = getTokenFromWebToConsole(m.config)
+		if err := m.saveToken(tok); err != nil {
+			log.Fatalf("Unable to cache oauth token: %v", err)
+		}
+		m.bind(tok)
+		return
+	}
+
+	m.setStatus(IntegrationStatus{State: StateAuthorizing, AuthURL: getTokenFromWeb(m.config, false)})
+}
+
+// CompleteAuthorization saves the token exchanged by the OAuth callback and binds the services to it.
+// The exchange error, if any, is recorded as failure.
+// It returns ErrNotAuthorizing if the manager isn't authorizing or failed.
+func (m *Manager) CompleteAuthorization(tok *oauth2.Token, exchangeErr error) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if m.status.State != StateAuthorizing && m.status.State != StateFailed {
+		return ErrNotAuthorizing
+	}
+
+	if exchangeErr != nil {
+		m.fail(fmt.Errorf("token exchange: %w", exchangeErr))
+		return exchangeErr
+	}
+	if err := m.saveToken(tok); err != nil {
+		m.fail(fmt.Errorf("token save: %w", err))
+		return err
+	}
+
+	m.bind(tok)
+	if m.status.State == StateFailed {
+		return errors.New(m.status.Error)
+	}
+	return nil
+}
+
+// Reset unbinds the services, after the token was deleted.
+// In web auth mode it prints a new authorization URL and waits for the callback,
+// otherwise the integration stays unauthorized until a token is stored again.
+func (m *Manager) Reset() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.reloadedAt = time.Now()
+	m.unbind(true)
+}
+
+// Status returns the current state of the integration, after reloading the stored token if due.
+func (m *Manager) Status() IntegrationStatus {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.reload()
+	return m.status
+}
+
+// Gmail returns the bound Gmail service, or ErrNotReady.
+func (m *Manager) Gmail() (*gmail.Service, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.reload()
+	if m.status.State != StateReady {
+		return nil, fmt.Errorf("%w: %s", ErrNotReady, m.status.State)
+	}
+	return m.gmail, nil
+}
+
+// Sheets returns the bound Sheets service, or ErrNotReady.
+func (m *Manager) Sheets() (*sheets.Service, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.reload()
+	if m.status.State != StateReady {
+		return nil, fmt.Errorf("%w: %s", ErrNotReady, m.status.State)
+	}
+	return m.sheets, nil
+}
+
+// bind creates the services with a client refreshing tok, and saving it when refreshed, see persistingTokenSource.
+// It must be called holding the lock.
+func (m *Manager) bind(tok *oauth2.Token) {
+	ctx := context.Background()
+	client := oauth2.NewClient(ctx, newPersistingTokenSource(m.config.TokenSource(ctx, tok), tok, persistRefreshedToken))
+
+	gmailSrv, sheetsSrv, err := newServices(ctx, client)
+	if err != nil {
+		m.fail(fmt.Errorf("service binding: %w", err))
+		return
+	}
+
+	m.gmail = gmailSrv
+	m.sheets = sheetsSrv
+	m.token = tok
+	m.setStatus(IntegrationStatus{State: StateReady})
+	log.Printf("Google integration ready")
+}
+
+// reload loads the stored token, at most once every reloadInterval, to follow the changes made by other instances:
+// it binds the services to a token stored by another instance, and unbinds them once the token is deleted.
+// While authorizing, the authorization URL follows the state shared by the instances, see getTokenFromWeb.
+// Load errors other than a missing token keep the current state.
+// It must be called holding the lock.
+func (m *Manager) reload() {
+	// Not started yet, or reloaded recently
+	if m.reloadedAt.IsZero() || time.Since(m.reloadedAt) < m.reloadInterval {
+		return
+	}
+	m.reloadedAt = time.Now()
+
+	tok, err := m.loadToken()
+	if err == nil {
+		// Bound to the same grant, only its access token may have been refreshed
+		if m.status.State == StateReady && m.token != nil && m.token.RefreshToken == tok.RefreshToken {
+			return
+		}
+		m.bind(tok)
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Unable to reload Google token: %v", err)
+		return
+	}
+
+	switch m.status.State {
+	case StateReady:
+		log.Printf("Google token deleted by another instance")
+		m.unbind(false)
+	case StateAuthorizing, StateFailed:
+		m.status.AuthURL = getTokenFromWeb(m.config, false)
+	}
+}
+
+// unbind drops the services after the token was deleted. In web auth mode it waits for a new authorization,
+// with a new state if renew is set, otherwise the integration is unauthorized.
+// It must be called holding the lock.
+func (m *Manager) unbind(renew bool) {
+	m.gmail = nil
+	m.sheets = nil
+	m.token = nil
+	if !m.webAuth {
+		m.setStatus(IntegrationStatus{State: StateUnauthorized})
+		return
+	}
+	m.setStatus(IntegrationStatus{State: StateAuthorizing, AuthURL: getTokenFromWeb(m.config, renew)})
+}
+
+// fail records the failure err, it must be called holding the lock.
+func (m *Manager) fail(err error) {
+	log.Printf("Google integration failed: %v", err)
+	m.setStatus(IntegrationStatus{State: StateFailed, AuthURL: m.status.AuthURL, Error: err.Error()})
+}
+
+// setStatus changes the status, it must be called holding the lock.
+func (m *Manager) setStatus(status IntegrationStatus) {
+	status.Since = time.Now()
+	m.status = status
+}
+
+// newServices creates the Gmail and Sheets services using client.
+func newServices(ctx context.Context, client *http.Client) (*gmail.Service, *sheets.Service, error) {
+	gmailSrv, err := gmail.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, nil, err
+	}
+	sheetsSrv, err := sheets.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, nil, err
+	}
+	return gmailSrv, sheetsSrv, nil
+}
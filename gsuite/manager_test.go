@@ -0,0 +1,211 @@
+package gsuite
+
+import (
+	"aat-manager/db"
+	"aat-manager/utils"
+	"database/sql"
+	"errors"
+	"golang.org/x/oauth2"
+	"testing"
+	"time"
+)
+
+// testManager returns a web auth manager loading the stored token and error, recording the saved tokens.
+func testManager(t *testing.T, stored *oauth2.Token, loadErr error, saveErr error, saved *[]*oauth2.Token) *Manager {
+	t.Helper()
+	t.Setenv(utils.WEBAUTH, "true")
+
+	m := NewManager(&oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{AuthURL: "https://accounts.example.org/auth"}})
+	m.loadToken = func() (*oauth2.Token, error) {
+		return stored, loadErr
+	}
+	m.saveToken = func(tok *oauth2.Token) error {
+		*saved = append(*saved, tok)
+		return saveErr
+	}
+	return m
+}
+
+func TestManagerStart(t *testing.T) {
+	tests := []struct {
+		name      string
+		stored    *oauth2.Token
+		loadErr   error
+		wantState IntegrationState
+		wantURL   bool
+	}{
+		{name: "Stored token", stored: &oauth2.Token{AccessToken: "a1", RefreshToken: "r1"}, wantState: StateReady},
+		{name: "No stored token", loadErr: errors.New("no rows"), wantState: StateAuthorizing, wantURL: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var saved []*oauth2.Token
+			m := testManager(t, tt.stored, tt.loadErr, nil, &saved)
+			if got := m.Status().State; got != StateUnauthorized {
+				t.Errorf("Status() before Start = %v, want %v", got, StateUnauthorized)
+			}
+
+			m.Start()
+			status := m.Status()
+			if status.State != tt.wantState {
+				t.Errorf("Status() = %v, want %v", status.State, tt.wantState)
+			}
+			if (status.AuthURL != "") != tt.wantURL {
+				t.Errorf("Status() AuthURL = %q, want URL %v", status.AuthURL, tt.wantURL)
+			}
+
+			_, gmailErr := m.Gmail()
+			_, sheetsErr := m.Sheets()
+			wantErr := tt.wantState != StateReady
+			if (gmailErr != nil) != wantErr || (sheetsErr != nil) != wantErr {
+				t.Errorf("Gmail(), Sheets() error = %v, %v, want error %v", gmailErr, sheetsErr, wantErr)
+			}
+			if wantErr && !errors.Is(gmailErr, ErrNotReady) {
+				t.Errorf("Gmail() error = %v, want %v", gmailErr, ErrNotReady)
+			}
+			if len(saved) != 0 {
+				t.Errorf("Start() saved %d tokens, want 0", len(saved))
+			}
+		})
+	}
+}
+
+func TestManagerCompleteAuthorization(t *testing.T) {
+	tok := &oauth2.Token{AccessToken: "a1", RefreshToken: "r1"}
+
+	tests := []struct {
+		name        string
+		exchangeErr error
+		saveErr     error
+		wantState   IntegrationState
+		wantSaved   int
+	}{
+		{name: "Token received", wantState: StateReady, wantSaved: 1},
+		{name: "Exchange failed", exchangeErr: errors.New("invalid_grant"), wantState: StateFailed},
+		{name: "Save failed", saveErr: errors.New("db down"), wantState: StateFailed, wantSaved: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var saved []*oauth2.Token
+			m := testManager(t, nil, errors.New("no rows"), tt.saveErr, &saved)
+			m.Start()
+
+			err := m.CompleteAuthorization(tok, tt.exchangeErr)
+			if (err != nil) != (tt.wantState == StateFailed) {
+				t.Errorf("CompleteAuthorization() error = %v", err)
+			}
+			status := m.Status()
+			if status.State != tt.wantState {
+				t.Errorf("Status() = %v, want %v", status.State, tt.wantState)
+			}
+			if tt.wantState == StateFailed && (status.Error == "" || status.AuthURL == "") {
+				t.Errorf("Status() = %+v, want error and authorization URL", status)
+			}
+			if len(saved) != tt.wantSaved {
+				t.Errorf("saved %d tokens, want %d", len(saved), tt.wantSaved)
+			}
+		})
+	}
+}
+
+func TestManagerRetryAndReset(t *testing.T) {
+	tok := &oauth2.Token{AccessToken: "a1", RefreshToken: "r1"}
+	var saved []*oauth2.Token
+	m := testManager(t, nil, errors.New("no rows"), nil, &saved)
+	m.Start()
+
+	// A failed authorization may be retried
+	_ = m.CompleteAuthorization(nil, errors.New("access_denied"))
+	if err := m.CompleteAuthorization(tok, nil); err != nil {
+		t.Fatalf("CompleteAuthorization() retry error = %v", err)
+	}
+	if got := m.Status().State; got != StateReady {
+		t.Fatalf("Status() = %v, want %v", got, StateReady)
+	}
+
+	// A ready integration refuses other authorizations
+	if err := m.CompleteAuthorization(tok, nil); !errors.Is(err, ErrNotAuthorizing) {
+		t.Errorf("CompleteAuthorization() when ready error = %v, want %v", err, ErrNotAuthorizing)
+	}
+
+	// Resetting waits for a new authorization
+	m.Reset()
+	if status := m.Status(); status.State != StateAuthorizing || status.AuthURL == "" {
+		t.Errorf("Status() after Reset = %+v, want %v with authorization URL", status, StateAuthorizing)
+	}
+	if _, err := m.Gmail(); !errors.Is(err, ErrNotReady) {
+		t.Errorf("Gmail() after Reset error = %v, want %v", err, ErrNotReady)
+	}
+}
+
+func TestManagerReload(t *testing.T) {
+	tok := &oauth2.Token{AccessToken: "a1", RefreshToken: "r1"}
+
+	tests := []struct {
+		name      string
+		stored    *oauth2.Token // Token stored at start
+		reloaded  *oauth2.Token // Token stored by another instance
+		reloadErr error
+		interval  time.Duration
+		wantState IntegrationState
+	}{
+		{name: "Authorized by another instance", reloaded: tok, wantState: StateReady},
+		{name: "Deleted by another instance", stored: tok, reloadErr: sql.ErrNoRows, wantState: StateAuthorizing},
+		{name: "Storage unavailable", stored: tok, reloadErr: errors.New("db down"), wantState: StateReady},
+		{name: "Reload throttled", reloaded: tok, interval: time.Hour, wantState: StateAuthorizing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var saved []*oauth2.Token
+			var loadErr error
+			if tt.stored == nil {
+				loadErr = sql.ErrNoRows
+			}
+			m := testManager(t, tt.stored, loadErr, nil, &saved)
+			m.Start()
+
+			m.reloadInterval = tt.interval
+			m.loadToken = func() (*oauth2.Token, error) {
+				return tt.reloaded, tt.reloadErr
+			}
+
+			_, err := m.Gmail()
+			if (err == nil) != (tt.wantState == StateReady) {
+				t.Errorf("Gmail() error = %v, want ready %v", err, tt.wantState == StateReady)
+			}
+			if status := m.Status(); status.State != tt.wantState {
+				t.Errorf("Status() = %v, want %v", status.State, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestManagerSharedState(t *testing.T) {
+	UseStateStore(db.NewDB())
+	defer UseStateStore(nil)
+
+	var saved []*oauth2.Token
+	first := testManager(t, nil, sql.ErrNoRows, nil, &saved)
+	second := testManager(t, nil, sql.ErrNoRows, nil, &saved)
+	first.Start()
+	second.Start()
+
+	// Instances started without a token follow the same authorization
+	if first.Status().AuthURL != second.Status().AuthURL || GetState() == "" {
+		t.Fatalf("Status() AuthURL = %q and %q, want the same URL", first.Status().AuthURL, second.Status().AuthURL)
+	}
+
+	// A reset renews the state, the other instance follows it once reloaded
+	state := GetState()
+	first.Reset()
+	if GetState() == state {
+		t.Errorf("GetState() after Reset = %q, want a new state", state)
+	}
+	second.reloadInterval = 0
+	if first.Status().AuthURL != second.Status().AuthURL {
+		t.Errorf("Status() AuthURL = %q and %q after reload, want the same URL", first.Status().AuthURL, second.Status().AuthURL)
+	}
+}
@@ -2,13 +2,8 @@ package gsuite
 
 import (
 	"aat-manager/utils"
-	"context"
 	"fmt"
-	"golang.org/x/oauth2/google"
-	"google.golang.org/api/gmail/v1"
-	"google.golang.org/api/option"
 	"google.golang.org/api/sheets/v4"
-	"log"
 	"sync"
 )
 
@@ -19,16 +14,21 @@ const (
 
 // SheetService represents a service for interacting with Google Sheets API.
 // The SheetService struct contains the following fields:
-// - Srv: A pointer to the sheets.Service object that represents the Google Sheets API service.
-// - vehicleSheet: The name of the sheet that contains vehicle data.
-// - stationSheet: The name of the sheet that contains station data.
+// - manager: The Google integration manager binding the Google Sheets API service.
+// - sheets: The IDs of the vehicle and station spreadsheets.
 type SheetService struct {
-	Srv      *sheets.Service
+	manager  *Manager
 	sheets   map[string]string
 	initOnce sync.Once
 	initErr  error
 }
 
+// NewSheetService returns a sheet service using the Google Sheets API service bound by the manager.
+// The service can be created before the integration is authorized, requests succeed once it is ready.
+func NewSheetService(manager *Manager) *SheetService {
+	return &SheetService{manager: manager}
+}
+
 // Initialize lazy initialize the sheet service when needed.
 // It call initialize function and return error in fail
 func (ss *SheetService) Initialize() error {
@@ -38,25 +38,20 @@ func (ss *SheetService) Initialize() error {
 	return ss.initErr
 }
 
-// initialize initializes the SheetService by setting up the Google Sheet client and reading the sheet IDs from the environment.
-// It takes no parameters and returns an error if any occurred during initialization.
-func (ss *SheetService) initialize() error {
-	ctx := context.Background()
-	b := utils.ReadEnvOrPanic(utils.GOOGLECREDENTIAL)
-
-	config, err := google.ConfigFromJSON([]byte(b), gmail.GmailSendScope, sheets.SpreadsheetsScope)
-	if err != nil {
-		log.Fatalf("Unable to parse client file from config: %v", err)
+// service returns the Google Sheets API service, ErrNotReady while the integration is not authorized.
+func (ss *SheetService) service() (*sheets.Service, error) {
+	if err := ss.Initialize(); err != nil {
+		return nil, err
 	}
-	client := getClient(config)
-
-	srv, err := sheets.NewService(ctx, option.WithHTTPClient(client))
-	if err != nil {
-		log.Fatalf("Unable to retrieve Sheet client: %v", err)
+	if ss.manager == nil {
+		return nil, ErrNotReady
 	}
+	return ss.manager.Sheets()
+}
 
-	ss.Srv = srv
-
+// initialize initializes the SheetService by reading the sheet IDs from the environment.
+// It takes no parameters and returns an error if any occurred during initialization.
+func (ss *SheetService) initialize() error {
 	// Read sheets id from env
 	vSheet := utils.ReadEnvOrPanic(utils.VEHICLESHEETID)
 	sSheet := utils.ReadEnvOrPanic(utils.STATIONSHEETID)
@@ -74,7 +69,30 @@ func (ss *SheetService) initialize() error {
 // The 'data' parameter should be a 2-dimensional slice of interface{} where each element of the slice represents a row of data and each element of a row represents a cell value.
 // The function returns the HTTP status code of the request and an error if any.
 func (ss *SheetService) Append(s string, r string, data [][]interface{}) (int, error) {
-	if err := ss.Initialize(); err != nil {
+	srv, err := ss.service()
+	if err != nil {
+		return 500, err
+	}
+
+	var values = sheets.ValueRange{
+		Values: data,
+	}
+
+	res, err := srv.Spreadsheets.Values.Append(ss.sheets[s], r, &values).ValueInputOption("USER_ENTERED").Do()
+	if err != nil {
+		return 500, err
+	}
+
+	return res.HTTPStatusCode, nil
+}
+
+// Update overwrites the cells of a specific sheet in a Google Sheet.
+// It takes the sheet identifier 's', the range 'r' to overwrite, and the new values 'data' as input.
+// The 'data' parameter follows the same layout of Append.
+// The function returns the HTTP status code of the request and an error if any.
+func (ss *SheetService) Update(s string, r string, data [][]interface{}) (int, error) {
+	srv, err := ss.service()
+	if err != nil {
 		return 500, err
 	}
 
@@ -82,7 +100,7 @@ func (ss *SheetService) Append(s string, r string, data [][]interface{}) (int, e
 		Values: data,
 	}
 
-	res, err := ss.Srv.Spreadsheets.Values.Append(ss.sheets[s], r, &values).ValueInputOption("USER_ENTERED").Do()
+	res, err := srv.Spreadsheets.Values.Update(ss.sheets[s], r, &values).ValueInputOption("USER_ENTERED").Do()
 	if err != nil {
 		return 500, err
 	}
@@ -91,15 +109,16 @@ func (ss *SheetService) Append(s string, r string, data [][]interface{}) (int, e
 }
 
 // EnumerateSheets retrieves the list of sheet names in the specified spreadsheet.
-// It takes a string parameter `s` which represents the spreadsheet ID.
+// It takes the sheet identifier 's', like Append and GetAllRecords, e.g. VehicleSheet.
 // It returns a slice of strings containing the names of the sheets and an error if any.
 // If an error occurs while retrieving the spreadsheet or its sheets, it will be returned.
 func (ss *SheetService) EnumerateSheets(s string) ([]string, error) {
-	if err := ss.Initialize(); err != nil {
+	srv, err := ss.service()
+	if err != nil {
 		return nil, err
 	}
 
-	spreadsheet, err := ss.Srv.Spreadsheets.Get(s).Do()
+	spreadsheet, err := srv.Spreadsheets.Get(ss.sheets[s]).Do()
 	if err != nil {
 		return nil, err
 	}
@@ -111,16 +130,17 @@ func (ss *SheetService) EnumerateSheets(s string) ([]string, error) {
 }
 
 // GetAllRecords retrieves all records from the specified sheet in a spreadsheet.
-// It takes in the spreadsheet ID (s), sheet name (sheet), and the column number (coln) to define the range from which to get the records.
+// It takes in the sheet identifier (s), sheet name (sheet), and the column number (coln) to define the range from which to get the records.
 // It returns a 2D slice of interfaces representing the retrieved records and an error if any.
 // The records are retrieved from the range "sheet!A2:{colName}" where colName is the corresponding column name calculated from coln using the colNumToName function.
 // If an error occurs while retrieving the records or if no values are found in the response, the function returns nil and the error.
 // Otherwise, it returns the retrieved records.
 // Example usage:
 //
-//	record, err := sheetService.GetAllRecords("<spreadsheetID>", "<sheetName>", 5)
+//	record, err := sheetService.GetAllRecords(VehicleSheet, "<sheetName>", 5)
 func (ss *SheetService) GetAllRecords(s string, sheet string, coln int) ([][]interface{}, error) {
-	if err := ss.Initialize(); err != nil {
+	srv, err := ss.service()
+	if err != nil {
 		return nil, err
 	}
 
@@ -131,7 +151,7 @@ func (ss *SheetService) GetAllRecords(s string, sheet string, coln int) ([][]int
 	cellRange := fmt.Sprintf("%s!A2:%s", sheet, colNumToName(coln))
 	var result [][]interface{}
 
-	resp, err := ss.Srv.Spreadsheets.Values.Get(s, cellRange).Do()
+	resp, err := srv.Spreadsheets.Values.Get(ss.sheets[s], cellRange).Do()
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,101 @@
+package gsuite
+
+import (
+	"aat-manager/db"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// TokenFile stores the Google API token when not using the web auth, see utils.WEBAUTH
+const TokenFile = "token.json"
+
+// TokenSavedByCallback records tokens saved at the end of the authorization flow
+const TokenSavedByCallback = "oauth_callback"
+
+// revokeURL is the Google endpoint revoking an access or refresh token, with its grant
+const revokeURL = "https://oauth2.googleapis.com/revoke"
+
+// revokeTimeout bounds the call to revokeURL
+const revokeTimeout = 10 * time.Second
+
+// DeleteToken revokes the stored Google API token and deletes it, from db or TokenFile according to utils.WEBAUTH.
+// Revoking at Google is best effort: its error is returned along with the deletion result, but doesn't stop the deletion.
+// The deletedBy parameter records who deleted the token in the token history.
+// It returns false if no token is stored.
+func DeleteToken(ctx context.Context, deletedBy string) (bool, error) {
+	if useWebAuth() {
+		tok, err := tokenFromDb()
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		var revokeErr error
+		if err == nil {
+			revokeErr = revokeToken(ctx, http.DefaultClient, revokeURL, tok.RefreshToken, tok.AccessToken)
+		}
+
+		deleted, err := db.Token{}.DeleteToken(deletedBy)
+		if err != nil {
+			return false, err
+		}
+		return deleted, revokeErr
+	}
+
+	tok, err := tokenFromFile(TokenFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	var revokeErr error
+	if err == nil {
+		revokeErr = revokeToken(ctx, http.DefaultClient, revokeURL, tok.RefreshToken, tok.AccessToken)
+	}
+
+	if err := os.Remove(TokenFile); err != nil {
+		return false, err
+	}
+	return true, revokeErr
+}
+
+// revokeToken asks Google to revoke the first non blank of the given tokens.
+// Revoking the refresh token also revokes its access tokens.
+func revokeToken(ctx context.Context, client *http.Client, endpoint string, tokens ...string) error {
+	var token string
+	for _, t := range tokens {
+		if t != "" {
+			token = t
+			break
+		}
+	}
+	if token == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, revokeTimeout)
+	defer cancel()
+
+	form := url.Values{"token": {token}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("google token revocation failed: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package gsuite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRevokeToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		tokens    []string
+		status    int
+		wantToken string
+		wantErr   bool
+	}{
+		{
+			name:      "Refresh token first",
+			tokens:    []string{"refresh", "access"},
+			status:    http.StatusOK,
+			wantToken: "refresh",
+		},
+		{
+			name:      "Access token without refresh token",
+			tokens:    []string{"", "access"},
+			status:    http.StatusOK,
+			wantToken: "access",
+		},
+		{
+			name:   "No token",
+			tokens: []string{"", ""},
+			status: http.StatusOK,
+		},
+		{
+			name:      "Revocation refused",
+			tokens:    []string{"refresh"},
+			status:    http.StatusBadRequest,
+			wantToken: "refresh",
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotToken string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotToken = r.PostFormValue("token")
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			err := revokeToken(context.Background(), server.Client(), server.URL, tt.tokens...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("revokeToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if gotToken != tt.wantToken {
+				t.Errorf("revokeToken() revoked %q, want %q", gotToken, tt.wantToken)
+			}
+		})
+	}
+}
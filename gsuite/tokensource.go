@@ -0,0 +1,71 @@
+package gsuite
+
+import (
+	"golang.org/x/oauth2"
+	"log"
+	"sync"
+)
+
+// TokenSavedByRefresh records tokens saved after the oauth2 library refreshed them
+const TokenSavedByRefresh = "refresh"
+
+// tokenWriteMux serializes the writes of refreshed tokens, the mail and sheet clients refresh independently
+var tokenWriteMux sync.Mutex
+
+// persistingTokenSource is an oauth2.TokenSource saving the tokens of its base source when they change,
+// so a refreshed access token, and a refresh token rotated by Google, survive a restart.
+// The save is told whether the refresh token was rotated, only rotations are new versions of the token.
+type persistingTokenSource struct {
+	mux  sync.Mutex
+	base oauth2.TokenSource                          // Refreshing source, like oauth2.Config.TokenSource
+	last *oauth2.Token                               // Last token saved, or loaded from storage
+	save func(tok *oauth2.Token, rotated bool) error // Persists a changed token
+}
+
+// newPersistingTokenSource wraps base, started from the initial token loaded from storage, saving the changed tokens with save.
+func newPersistingTokenSource(base oauth2.TokenSource, initial *oauth2.Token, save func(tok *oauth2.Token, rotated bool) error) oauth2.TokenSource {
+	return &persistingTokenSource{base: base, last: initial, save: save}
+}
+
+// Token returns the token of the base source, saving it first if it changed since the last save.
+// A failed save is only logged and retried at the next call, the token is still usable.
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	tok, err := s.base.Token()
+	if err != nil {
+		return nil, err
+	}
+	if !tokenChanged(s.last, tok) {
+		return tok, nil
+	}
+
+	tokenWriteMux.Lock()
+	err = s.save(tok, s.last == nil || s.last.RefreshToken != tok.RefreshToken)
+	tokenWriteMux.Unlock()
+	if err != nil {
+		log.Printf("Unable to save refreshed token: %v", err)
+		return tok, nil
+	}
+	s.last = tok
+
+	return tok, nil
+}
+
+// tokenChanged reports whether tok differs from the saved token last.
+func tokenChanged(last *oauth2.Token, tok *oauth2.Token) bool {
+	return last == nil || last.AccessToken != tok.AccessToken || last.RefreshToken != tok.RefreshToken
+}
+
+// persistRefreshedToken saves a refreshed token to db or TokenFile, according to utils.WEBAUTH.
+// In db a rotated refresh token is saved as a new version, a refreshed access token replaces the current one in place.
+func persistRefreshedToken(tok *oauth2.Token, rotated bool) error {
+	if !useWebAuth() {
+		return writeTokenFile(TokenFile, tok)
+	}
+	if rotated {
+		return writeTokenToDb(tok, TokenSavedByRefresh)
+	}
+	return updateTokenInDb(tok)
+}
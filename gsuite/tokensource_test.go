@@ -0,0 +1,142 @@
+package gsuite
+
+import (
+	"errors"
+	"golang.org/x/oauth2"
+	"sync"
+	"testing"
+)
+
+// sequenceTokenSource returns its tokens in order, then the last one.
+type sequenceTokenSource struct {
+	mux    sync.Mutex
+	tokens []*oauth2.Token
+	err    error
+}
+
+func (s *sequenceTokenSource) Token() (*oauth2.Token, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+	tok := s.tokens[0]
+	if len(s.tokens) > 1 {
+		s.tokens = s.tokens[1:]
+	}
+	return tok, nil
+}
+
+func TestPersistingTokenSource(t *testing.T) {
+	initial := &oauth2.Token{AccessToken: "a1", RefreshToken: "r1"}
+	refreshed := &oauth2.Token{AccessToken: "a2", RefreshToken: "r1"}
+	rotated := &oauth2.Token{AccessToken: "a2", RefreshToken: "r2"}
+
+	tests := []struct {
+		name      string
+		initial   *oauth2.Token
+		tokens    []*oauth2.Token
+		saveErr   error
+		wantSaved []string // Access and refresh tokens of the saved tokens, starred when rotated
+	}{
+		{
+			name:    "Unchanged token",
+			initial: initial,
+			tokens:  []*oauth2.Token{initial, initial},
+		},
+		{
+			name:      "Refreshed access token",
+			initial:   initial,
+			tokens:    []*oauth2.Token{initial, refreshed, refreshed},
+			wantSaved: []string{"a2/r1"},
+		},
+		{
+			name:      "Rotated refresh token",
+			initial:   initial,
+			tokens:    []*oauth2.Token{refreshed, rotated},
+			wantSaved: []string{"a2/r1", "a2/r2*"},
+		},
+		{
+			name:      "No initial token",
+			tokens:    []*oauth2.Token{initial, initial},
+			wantSaved: []string{"a1/r1*"},
+		},
+		{
+			name:      "Failed save retried",
+			initial:   initial,
+			tokens:    []*oauth2.Token{refreshed, refreshed},
+			saveErr:   errors.New("db down"),
+			wantSaved: []string{"a2/r1", "a2/r1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var saved []string
+			save := func(tok *oauth2.Token, rotated bool) error {
+				entry := tok.AccessToken + "/" + tok.RefreshToken
+				if rotated {
+					entry += "*"
+				}
+				saved = append(saved, entry)
+				return tt.saveErr
+			}
+
+			source := newPersistingTokenSource(&sequenceTokenSource{tokens: tt.tokens}, tt.initial, save)
+			for range tt.tokens {
+				if _, err := source.Token(); err != nil {
+					t.Fatalf("Token() error = %v", err)
+				}
+			}
+
+			if len(saved) != len(tt.wantSaved) {
+				t.Fatalf("saved %v, want %v", saved, tt.wantSaved)
+			}
+			for i := range saved {
+				if saved[i] != tt.wantSaved[i] {
+					t.Errorf("saved %v, want %v", saved, tt.wantSaved)
+				}
+			}
+		})
+	}
+}
+
+func TestPersistingTokenSourceError(t *testing.T) {
+	wantErr := errors.New("invalid_grant")
+	source := newPersistingTokenSource(&sequenceTokenSource{err: wantErr}, nil, func(*oauth2.Token, bool) error {
+		t.Errorf("token saved on error")
+		return nil
+	})
+
+	if _, err := source.Token(); !errors.Is(err, wantErr) {
+		t.Errorf("Token() error = %v, want %v", err, wantErr)
+	}
+}
+
+func TestPersistingTokenSourceConcurrent(t *testing.T) {
+	var mux sync.Mutex
+	saves := 0
+	save := func(tok *oauth2.Token, rotated bool) error {
+		mux.Lock()
+		defer mux.Unlock()
+		saves++
+		return nil
+	}
+	refreshed := &oauth2.Token{AccessToken: "a2", RefreshToken: "r1"}
+	source := newPersistingTokenSource(&sequenceTokenSource{tokens: []*oauth2.Token{refreshed}}, &oauth2.Token{AccessToken: "a1", RefreshToken: "r1"}, save)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = source.Token()
+		}()
+	}
+	wg.Wait()
+
+	if saves != 1 {
+		t.Errorf("saved %d times, want 1", saves)
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"aat-manager/authenticator"
+	"aat-manager/db"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"net/mail"
+	"strings"
+)
+
+// ListUsers answers with all the users known to the application and their role.
+func (h *Handler) ListUsers(ctx *fiber.Ctx) error {
+	users, err := db.Users{}.ListUsers()
+	if err != nil {
+		log.Errorf("Error listing users:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(users)
+}
+
+// PromoteUser gives the manager role to the user whose e-mail is in the route parameter.
+func (h *Handler) PromoteUser(ctx *fiber.Ctx) error {
+	return setUserRole(ctx, authenticator.RoleManager)
+}
+
+// DemoteUser gives back the crew role to the user whose e-mail is in the route parameter.
+func (h *Handler) DemoteUser(ctx *fiber.Ctx) error {
+	return setUserRole(ctx, authenticator.RoleCrew)
+}
+
+// setUserRole validates the e-mail from the route parameter and stores the new role.
+// The role is applied to the user's token at next login.
+func setUserRole(ctx *fiber.Ctx, role string) error {
+	addr, err := mail.ParseAddress(ctx.Params("email"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	if err := (db.Users{}).SetRole(strings.ToLower(addr.Address), role); err != nil {
+		log.Errorf("Error setting user role:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	principal, _ := getPrincipal(ctx)
+	log.Infof("User %s set to %s by %s", addr.Address, role, principal.Name)
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// UpdateUser changes display name, station and active flag of the user whose e-mail is in the route parameter.
+// Fields missing from the JSON body are left unchanged. Deactivating a user also revokes its sessions,
+// logging it out from all devices. It answers with the updated user.
+func (h *Handler) UpdateUser(ctx *fiber.Ctx) error {
+	addr, err := mail.ParseAddress(ctx.Params("email"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	email := strings.ToLower(addr.Address)
+
+	profile := new(db.UserProfile)
+	if err := ctx.BodyParser(profile); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	user, err := db.Users{}.UpdateUser(email, *profile)
+	if err != nil {
+		log.Errorf("Error updating user:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	if !user.Active {
+		if _, err := (db.Sessions{}).RevokeUserSessions(email); err != nil {
+			log.Errorf("Error revoking user sessions:\t%s\n", err)
+			return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+	}
+
+	principal, _ := getPrincipal(ctx)
+	log.Infof("User %s updated by %s", email, principal.Name)
+
+	return ctx.Status(fiber.StatusOK).JSON(user)
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"aat-manager/authenticator"
+	"aat-manager/db"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type APIKeyData struct {
+	Name          string   `json:"name,omitempty" form:"name"`
+	Scopes        []string `json:"scopes,omitempty" form:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays,omitempty" form:"expiresInDays"` // 0 means the key never expires
+}
+
+// CreatedAPIKey is the answer to an API key creation, the only time the key itself is shown.
+type CreatedAPIKey struct {
+	db.APIKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKey creates a new API key with the name, scopes and optional expiration read from the request body.
+// It answers with the key, which can't be retrieved later.
+func (h *Handler) CreateAPIKey(ctx *fiber.Ctx) error {
+	formData := new(APIKeyData)
+
+	// Read key definition from request
+	if err := ctx.BodyParser(formData); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	// Validate key definition
+	if strings.TrimSpace(formData.Name) == "" {
+		return ctx.Status(fiber.StatusBadRequest).SendString("API key name is blank.")
+	}
+	if len(formData.Scopes) == 0 {
+		return ctx.Status(fiber.StatusBadRequest).SendString("API key needs at least one scope.")
+	}
+	for _, scope := range formData.Scopes {
+		if !authenticator.ValidScope(scope) {
+			return ctx.Status(fiber.StatusBadRequest).SendString("Unknown scope: " + scope)
+		}
+	}
+	if formData.ExpiresInDays < 0 {
+		return ctx.Status(fiber.StatusBadRequest).SendString("API key expiration must not be negative.")
+	}
+
+	principal, _ := getPrincipal(ctx)
+	apiKey := db.APIKey{
+		Name:      formData.Name,
+		Scopes:    formData.Scopes,
+		CreatedBy: principal.Email,
+	}
+	if formData.ExpiresInDays > 0 {
+		expires := time.Now().AddDate(0, 0, formData.ExpiresInDays)
+		apiKey.ExpiresAt = &expires
+	}
+
+	// Generate key and store its hash only
+	key, hash, err := authenticator.GenerateAPIKey()
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	apiKey.ID, err = db.APIKeys{}.CreateAPIKey(apiKey, hash)
+	if err != nil {
+		log.Errorf("Error creating API key:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	log.Infof("API key %d %q created by %s", apiKey.ID, apiKey.Name, principal.Email)
+
+	return ctx.Status(fiber.StatusCreated).JSON(CreatedAPIKey{
+		APIKey: apiKey,
+		Key:    key,
+	})
+}
+
+// ListAPIKeys answers with all the API keys, without the keys themselves.
+func (h *Handler) ListAPIKeys(ctx *fiber.Ctx) error {
+	keys, err := db.APIKeys{}.ListAPIKeys()
+	if err != nil {
+		log.Errorf("Error listing API keys:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(keys)
+}
+
+// RevokeAPIKey revokes the API key whose ID is in the route parameter.
+func (h *Handler) RevokeAPIKey(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	revoked, err := db.APIKeys{}.RevokeAPIKey(id)
+	if err != nil {
+		log.Errorf("Error revoking API key:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	if !revoked {
+		return ctx.Status(fiber.StatusNotFound).SendString("API key not found.")
+	}
+
+	principal, _ := getPrincipal(ctx)
+	log.Infof("API key %d revoked by %s", id, principal.Email)
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
@@ -6,11 +6,10 @@ import (
 	"aat-manager/gsuite"
 	"aat-manager/utils"
 	"context"
+	"errors"
+	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/log"
-	"golang.org/x/oauth2/google"
-	"google.golang.org/api/gmail/v1"
-	"google.golang.org/api/sheets/v4"
 	"net/mail"
 	"strconv"
 	"strings"
@@ -18,23 +17,33 @@ import (
 )
 
 type Handler struct {
-	Db          *db.InMemoryDb     // In memory db interface
-	MailService gsuite.MailService // Gmail service interface
+	Db           db.OtpStore          // OTP store interface
+	Google       *gsuite.Manager      // Google integration binding the mail and sheet services, nil if disabled
+	MailService  gsuite.MailService   // Gmail service interface
+	SheetService *gsuite.SheetService // Sheet service interface
+
+	IPLimiter      *authenticator.RateLimiter // OTP requests limit per client IP, nil for no limit
+	MailboxLimiter *authenticator.RateLimiter // OTP requests limit per target mailbox, nil for no limit
+	TotpLimiter    *authenticator.RateLimiter // Authenticator app login attempts limit per mailbox, nil for no limit
 
 	initialized bool // Indicate that the handler is initialized and safe for use
 }
 type AuthData struct {
 	Mail string `json:"mail,omitempty" form:"mail"`
 	Otp  string `json:"otp,omitempty" form:"otp"`
+	Code string `json:"code,omitempty" form:"code"` // Authenticator app or recovery code
 }
 
-func OauthCallback(ctx *fiber.Ctx) error {
-	// Read state from auth request
-	state := gsuite.GetState()
+// OauthCallback completes the authorization of the Google integration in web auth mode.
+// It exchanges the authorization code for the API token, then the integration manager saves it and binds the services,
+// see gsuite.Manager.CompleteAuthorization. The state is deleted once the authorization is completed.
+func (h *Handler) OauthCallback(ctx *fiber.Ctx) error {
+	if h.Google == nil {
+		return ctx.Status(fiber.StatusNotImplemented).SendString("This service is not enabled.")
+	}
 
-	// Check the state parameter
-	responseState := ctx.Query("state")
-	if responseState != state {
+	// Check the state parameter against the pending one
+	if !gsuite.CheckState(ctx.Query("state")) {
 		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid state parameter.")
 	}
 
@@ -42,35 +51,57 @@ func OauthCallback(ctx *fiber.Ctx) error {
 	code := ctx.Query("code")
 
 	// Recreate config from env
-	b := utils.ReadEnvOrPanic(utils.GOOGLECREDENTIAL)
-	config, err := google.ConfigFromJSON([]byte(b), gmail.GmailSendScope, sheets.SpreadsheetsScope)
+	config, err := gsuite.APIConfig()
+	if err != nil {
+		log.Errorf("Error reading Google API config:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
 
-	// Exchange the authorization code for an access token
+	// Exchange the authorization code for an access token, the manager records the failure if any
 	token, err := config.Exchange(context.Background(), code)
-	if err != nil {
-		// If error, send back error to caller
-		gsuite.TokenCh <- gsuite.Token{
-			Err: err,
+	if err := h.Google.CompleteAuthorization(token, err); err != nil {
+		if errors.Is(err, gsuite.ErrNotAuthorizing) {
+			return ctx.Status(fiber.StatusConflict).SendString("Google integration already authorized.")
 		}
-		// If error, send error response
-		return ctx.Status(fiber.StatusInternalServerError).SendString("Failed to exchange token: " + err.Error())
+		return ctx.Status(fiber.StatusInternalServerError).SendString("Failed to complete authorization: " + err.Error())
 	}
 
-	// Send back token to caller
-	gsuite.TokenCh <- gsuite.Token{
-		Token: token,
-		Err:   nil,
-	}
+	// The state is consumed, a new authorization needs a new one
+	gsuite.ClearState()
 
 	return ctx.Status(fiber.StatusOK).SendString("Authentication successful.")
 }
 
-func (h *Handler) InitializeService(db *db.InMemoryDb, ms gsuite.MailService, init bool) {
+func (h *Handler) InitializeService(db db.OtpStore, google *gsuite.Manager, ms gsuite.MailService, ss *gsuite.SheetService, init bool) {
 	h.Db = db
+	h.Google = google
 	h.MailService = ms
+	h.SheetService = ss
 	h.initialized = init
 }
 
+// InitializeRateLimits creates the OTP request and authenticator app login rate limiters, configured by the
+// OTPRATELIMITIP, OTPRATELIMITMAILBOX and TOTPRATELIMIT env variables, keeping their counters in the given store.
+func (h *Handler) InitializeRateLimits(store db.OtpStore) error {
+	ipLimit, ipWindow, err := authenticator.ParseRateLimit(utils.ReadEnvOrDefault(utils.OTPRATELIMITIP, DefaultOtpRateLimitIP))
+	if err != nil {
+		return fmt.Errorf("%s: %w", utils.OTPRATELIMITIP, err)
+	}
+	mailboxLimit, mailboxWindow, err := authenticator.ParseRateLimit(utils.ReadEnvOrDefault(utils.OTPRATELIMITMAILBOX, DefaultOtpRateLimitMailbox))
+	if err != nil {
+		return fmt.Errorf("%s: %w", utils.OTPRATELIMITMAILBOX, err)
+	}
+	totpLimit, totpWindow, err := authenticator.ParseRateLimit(utils.ReadEnvOrDefault(utils.TOTPRATELIMIT, DefaultTotpRateLimit))
+	if err != nil {
+		return fmt.Errorf("%s: %w", utils.TOTPRATELIMIT, err)
+	}
+
+	h.IPLimiter = authenticator.NewRateLimiter("otp-ip", ipLimit, ipWindow, store)
+	h.MailboxLimiter = authenticator.NewRateLimiter("otp-mailbox", mailboxLimit, mailboxWindow, store)
+	h.TotpLimiter = authenticator.NewRateLimiter("totp-mailbox", totpLimit, totpWindow, store)
+	return nil
+}
+
 // GetMailAndSendBackOtp takes in a fiber.Ctx and retrieves the email from the request body.
 // It then validates the email and generates an OTP for the user.
 // The OTP is sent to the user
@@ -79,6 +110,11 @@ func (h *Handler) GetMailAndSendBackOtp(ctx *fiber.Ctx) error {
 		return ctx.Status(fiber.StatusNotImplemented).SendString("This service is not enabled.")
 	}
 
+	// Limit requests from the same client, whatever the mailbox
+	if allowed, err := allowRequest(ctx, h.IPLimiter, ctx.IP()); !allowed {
+		return err
+	}
+
 	formData := new(AuthData)
 
 	// Read email field from request
@@ -93,32 +129,76 @@ func (h *Handler) GetMailAndSendBackOtp(ctx *fiber.Ctx) error {
 		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
 	}
 
+	// Limit mails sent to the same mailbox, whatever the client
+	if allowed, err := allowRequest(ctx, h.MailboxLimiter, strings.ToLower(addr.Address)); !allowed {
+		return err
+	}
+
 	// Generate OTP for user
 	otp, err := authenticator.GenOtpAndSave(*addr, h.Db)
+	if err != nil {
+		log.Errorf("Error generating OTP:\t%s\n", err)
+
+		if event := mailRefusedEvent(err); event != "" {
+			recordAuthEvent(ctx, event, addr.Address, "")
+		} else {
+			recordAuthEvent(ctx, db.AuthEventOtpRequested, addr.Address, err.Error())
+		}
+
+		var retryErr *authenticator.RetryError
+		switch {
+		case errors.As(err, &retryErr):
+			ctx.Set(fiber.HeaderRetryAfter, retryAfterSeconds(retryErr.RetryAfter))
+			return ctx.Status(fiber.StatusTooManyRequests).SendString(err.Error())
+		case mailRefusedEvent(err) != "":
+			return ctx.Status(fiber.StatusForbidden).SendString(err.Error())
+		case errors.Is(err, authenticator.ErrMalformedMail):
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		default:
+			return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+	}
+	recordAuthEvent(ctx, db.AuthEventOtpRequested, addr.Address, "")
 
-	// Send OTP to user by e-mail
-	err = h.MailService.SendMail("Codice di verifica", addr.Address, "Ecco il tuo codice di verifica:\t"+otp)
+	// Send OTP to user by e-mail, with the magic link if enabled, bound to the nonce of the pending auth cookie
+	nonce, err := newPendingAuthNonce()
 	if err != nil {
-		log.Errorf("Error senting OTP mail:\t%s\n", err)
 		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
+	message, err := h.otpMailMessage(ctx, *addr, nonce, otp)
+	if err != nil {
+		log.Errorf("Error generating magic link:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	err = h.MailService.SendMail("Codice di verifica", addr.Address, message)
+	if err != nil {
+		log.Errorf("Error senting OTP mail:\t%s\n", err)
+		recordAuthEvent(ctx, db.AuthEventMailFailed, addr.Address, err.Error())
+		return ctx.Status(googleErrorStatus(err)).SendString(err.Error())
+	}
+	recordAuthEvent(ctx, db.AuthEventMailSent, addr.Address, "")
 
 	// Set pending auth cookie
-	ctx.Cookie(&fiber.Cookie{
-		Name:        "pendingauth",
-		Value:       addr.Address,
-		Expires:     time.Now().Add(time.Minute * 3),
-		Secure:      false,
-		HTTPOnly:    true,
-		SameSite:    "lax",
-		SessionOnly: false,
-	})
+	setPendingAuthCookie(ctx, addr.Address, nonce)
 
 	redirectPath := getChainableRedirectPath(CheckOTPURL, ctx)
 	ctx.Redirect(redirectPath, fiber.StatusSeeOther)
 	return nil
 }
 
+// mailRefusedEvent returns the auth event recording an address refused by the mail policy,
+// or an empty string if the error is not a refusal of the policy.
+func mailRefusedEvent(err error) string {
+	switch {
+	case errors.Is(err, authenticator.ErrUnauthorizedDomain):
+		return db.AuthEventUnauthorizedDomain
+	case errors.Is(err, authenticator.ErrBlockedMail):
+		return db.AuthEventBlockedMail
+	default:
+		return ""
+	}
+}
+
 // GetOtpAndAuthenticate takes in a fiber.Ctx and retrieves the user-entered OTP from the request body.
 // It converts the OTP from string to int and reads the user's email from the pendingAuthCookie.
 // It then checks if the user-entered OTP matches the stored OTP and returns the appropriate status and message.
@@ -141,64 +221,116 @@ func (h *Handler) GetOtpAndAuthenticate(ctx *fiber.Ctx) error {
 	}
 
 	// Read user's email from pendingAuthCookie
-	userEmail, err := mail.ParseAddress(ctx.Cookies(PendingAuthCookieName))
+	userEmail, _, err := readPendingAuth(ctx)
 	if err != nil {
 		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
 	}
 
 	// Check if user entered OTP match with stored one
 	valid, err := authenticator.CheckOtpAndDelete(*userEmail, otp, h.Db)
+	if errors.Is(err, authenticator.ErrTooManyAttempts) || errors.Is(err, authenticator.ErrLockedOut) {
+		// OTP has been invalidated, user must request a new one once the lockout expires
+		log.Warnf("OTP check refused for %s:\t%s\n", userEmail.Address, err)
+		recordAuthEvent(ctx, db.AuthEventOtpFailed, userEmail.Address, err.Error())
+		ctx.ClearCookie(PendingAuthCookieName)
+		return ctx.Status(fiber.StatusTooManyRequests).SendString(err.Error())
+	}
+	if errors.Is(err, authenticator.ErrBlockedMail) {
+		log.Warnf("OTP check refused for %s:\t%s\n", userEmail.Address, err)
+		recordAuthEvent(ctx, db.AuthEventBlockedMail, userEmail.Address, "")
+		ctx.ClearCookie(PendingAuthCookieName)
+		return ctx.Status(fiber.StatusForbidden).SendString(err.Error())
+	}
 	if err != nil {
+		recordAuthEvent(ctx, db.AuthEventOtpFailed, userEmail.Address, err.Error())
 		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
 	}
 
 	// If valid create JWT, set it in a cookie and redirect to original url
 	if valid {
 		log.Info("Valid OTP")
+		recordAuthEvent(ctx, db.AuthEventOtpSucceeded, userEmail.Address, "")
 
-		// Extract user from userEmail address
-		// Search for last @ occurrence
-		atIndex := strings.LastIndex(userEmail.Address, "@")
-		if atIndex == -1 {
-			return ctx.Status(fiber.StatusBadRequest).SendString("Malformed email address")
-		}
-		user := userEmail.Address[:atIndex]
-
-		// Create and sign token
-		token, err := authenticator.CreateAndSignJWT(user, false)
-		if err != nil {
-			return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
-		}
-
-		// Read JWT expiration from env
-		expiredays, err := strconv.Atoi(utils.ReadEnvOrPanic(utils.JWTEXPIREINMONTH))
-		if err != nil {
-			return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
-		}
-
-		// Set auth cookie
-		ctx.Cookie(&fiber.Cookie{
-			Name:        "jwt",
-			Value:       token,
-			Expires:     time.Now().AddDate(0, expiredays, 0),
-			Secure:      false,
-			HTTPOnly:    true,
-			SameSite:    "lax",
-			SessionOnly: false,
-		})
-
-		// Clear auth pending cookie
-		ctx.ClearCookie(PendingAuthCookieName)
-
-		// Redirect to original url
-		redirectPage := ctx.Query("redirect")
-		ctx.Redirect(redirectPage, fiber.StatusSeeOther)
-		return nil
+		return h.completeLogin(ctx, userEmail, ctx.Query("redirect"))
 	} else {
 		// OTP il invalid, keep on same page till cookie expiration or valid code inserted
 		log.Error("Invalid OTP")
+		recordAuthEvent(ctx, db.AuthEventOtpFailed, userEmail.Address, "invalid OTP")
 		redirectPage := getChainableRedirectPath(CheckOTPURL, ctx)
 		ctx.Redirect(redirectPage, fiber.StatusSeeOther)
 		return nil
 	}
 }
+
+// completeLogin logs in the user after a successful authentication.
+// It registers the user on first login, refuses deactivated users, opens a new session, sets the signed JWT
+// in the auth cookie, clears the pending auth cookie and redirects to the original url, if allowed by the redirect policy.
+func (h *Handler) completeLogin(ctx *fiber.Ctx, userEmail *mail.Address, redirect string) error {
+	// Users are identified by their full lowercase e-mail
+	email := strings.ToLower(userEmail.Address)
+
+	// Register user on first login and read its profile
+	user, err := db.Users{}.EnsureUser(email)
+	if err != nil {
+		log.Errorf("Error reading user:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	if !user.Active {
+		log.Warnf("Login refused to inactive user %s\n", email)
+		recordAuthEvent(ctx, db.AuthEventUserInactive, email, "")
+		ctx.ClearCookie(PendingAuthCookieName)
+		return ctx.Status(fiber.StatusForbidden).SendString("User disabled, contact a manager.")
+	}
+
+	// Read session limits from env
+	limits, err := authenticator.CurrentSessionLimits()
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	// Open a new session, referenced by the token
+	sessionID, err := authenticator.NewSessionID()
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	session := db.Session{
+		ID:        sessionID,
+		UserEmail: user.Email,
+		UserAgent: ctx.Get(fiber.HeaderUserAgent),
+		IP:        ctx.IP(),
+		ExpiresAt: time.Now().Add(limits.MaxAge),
+	}
+	if err := (db.Sessions{}).CreateSession(session); err != nil {
+		log.Errorf("Error creating session:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	// Create and sign token, renewed by the middleware while the session is valid
+	token, err := authenticator.CreateAndSignJWT(user, session)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	setAuthCookie(ctx, token, session.ExpiresAt)
+
+	// Clear auth pending cookie
+	ctx.ClearCookie(PendingAuthCookieName)
+
+	// Redirect to original url, if allowed by the redirect policy
+	redirectPage := currentRedirectPolicy().Sanitize(redirect)
+	ctx.Redirect(redirectPage, fiber.StatusSeeOther)
+	return nil
+}
+
+// setAuthCookie sets the signed token in the auth cookie.
+// The cookie lasts as long as the session, so expired tokens are still sent to be renewed.
+func setAuthCookie(ctx *fiber.Ctx, token string, expires time.Time) {
+	ctx.Cookie(&fiber.Cookie{
+		Name:        JWTTokenCookieName,
+		Value:       token,
+		Expires:     expires,
+		Secure:      false,
+		HTTPOnly:    true,
+		SameSite:    "lax",
+		SessionOnly: false,
+	})
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"aat-manager/db"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"strconv"
+	"strings"
+)
+
+// recordAuthEvent stores an auth flow step with the IP and user agent of the request.
+// A failure is only logged, the auth flow must not be stopped by the audit log.
+// Every event is also counted in the auth metrics. The e-mail is stored lowercase, as the users are identified.
+func recordAuthEvent(ctx *fiber.Ctx, event string, userEmail string, detail string) {
+	authMetrics.Add(event, 1)
+
+	err := db.AuthEvents{}.RecordAuthEvent(db.AuthEvent{
+		Event:     event,
+		UserEmail: strings.ToLower(userEmail),
+		IP:        ctx.IP(),
+		UserAgent: ctx.Get(fiber.HeaderUserAgent),
+		Detail:    detail,
+	})
+	if err != nil {
+		log.Errorf("Error recording %s auth event:\t%s\n", event, err)
+	}
+}
+
+// GetAuthEvents answers with the auth events, newest first.
+// The events can be filtered by the user, whatever its case, from and to query parameters, dates are RFC 3339 or YYYY-MM-DD,
+// a to date includes the whole day. The limit query parameter caps the number of events.
+func (h *Handler) GetAuthEvents(ctx *fiber.Ctx) error {
+	from, err := parseDateFilter(ctx.Query("from"), false)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid from date: " + err.Error())
+	}
+	to, err := parseDateFilter(ctx.Query("to"), true)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid to date: " + err.Error())
+	}
+	limit := 0
+	if l := ctx.Query("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+			return ctx.Status(fiber.StatusBadRequest).SendString("Invalid limit, must be a positive number.")
+		}
+	}
+
+	events, err := db.AuthEvents{}.QueryAuthEvents(db.AuthEventFilter{
+		UserEmail: strings.ToLower(ctx.Query("user")),
+		From:      from,
+		To:        to,
+		Limit:     limit,
+	})
+	if err != nil {
+		log.Errorf("Error querying auth events:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(events)
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"aat-manager/authenticator"
+	"aat-manager/db"
+	"aat-manager/gsuite"
+	"aat-manager/utils"
+	"crypto/subtle"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/oauth2"
+	"net/url"
+	"time"
+)
+
+// googleLoginTTL is the time allowed to complete the sign in on the Google pages
+const googleLoginTTL = 10 * time.Minute
+
+// GoogleLogin starts the sign in with Google.
+// It stores state, nonce and the redirect in a short-lived cookie and redirects to the Google account chooser,
+// restricted to the accounts of the authorized domain when only one is authorized. Google redirects back to GoogleCallback.
+func (h *Handler) GoogleLogin(ctx *fiber.Ctx) error {
+	config, err := gsuite.LoginConfig(googleRedirectURL(ctx))
+	if err != nil {
+		log.Errorf("Error reading Google login config:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	policy, err := authenticator.CurrentMailPolicy()
+	if err != nil {
+		log.Errorf("Error reading mail policy:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	state, err := randomHex(16)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	nonce, err := randomHex(16)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	// Login state is checked and consumed by the callback
+	login := url.Values{
+		"state":    {state},
+		"nonce":    {nonce},
+		"redirect": {currentRedirectPolicy().Sanitize(ctx.Query("redirect"))},
+	}
+	ctx.Cookie(&fiber.Cookie{
+		Name:        GoogleLoginCookieName,
+		Value:       login.Encode(),
+		Path:        GoogleLoginPath,
+		Expires:     time.Now().Add(googleLoginTTL),
+		Secure:      false,
+		HTTPOnly:    true,
+		SameSite:    "lax",
+		SessionOnly: false,
+	})
+
+	authURL := config.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("nonce", nonce),
+		oauth2.SetAuthURLParam("hd", policy.HostedDomain()),
+		oauth2.SetAuthURLParam("prompt", "select_account"),
+	)
+	ctx.Redirect(authURL, fiber.StatusSeeOther)
+	return nil
+}
+
+// GoogleCallback completes the sign in with Google.
+// It checks the state against the login cookie, exchanges the code for a validated ID token
+// and checks its claims, see authenticator.CheckGoogleIdentity.
+// Accepted users are logged in as with the OTP flow and redirected to the original url.
+func (h *Handler) GoogleCallback(ctx *fiber.Ctx) error {
+	// Login state is single use
+	login, err := url.ParseQuery(ctx.Cookies(GoogleLoginCookieName))
+	ctx.Cookie(&fiber.Cookie{
+		Name:    GoogleLoginCookieName,
+		Path:    GoogleLoginPath,
+		Expires: fasthttp.CookieExpireDelete,
+	})
+
+	state := login.Get("state")
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(ctx.Query("state"))) != 1 {
+		return googleLoginFailed(ctx, "", "state mismatch")
+	}
+	if reason := ctx.Query("error"); reason != "" {
+		return googleLoginFailed(ctx, "", reason)
+	}
+
+	config, err := gsuite.LoginConfig(googleRedirectURL(ctx))
+	if err != nil {
+		log.Errorf("Error reading Google login config:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	claims, err := gsuite.ExchangeLoginCode(ctx.UserContext(), config, ctx.Query("code"))
+	if err != nil {
+		return googleLoginFailed(ctx, "", err.Error())
+	}
+
+	email, _ := claims["email"].(string)
+	addr, err := authenticator.CheckGoogleIdentity(claims, login.Get("nonce"))
+	if event := mailRefusedEvent(err); event != "" {
+		log.Warnf("Google sign in refused for %s:\t%s\n", email, err)
+		recordAuthEvent(ctx, event, email, "google")
+		return ctx.Status(fiber.StatusForbidden).SendString(err.Error())
+	}
+	if err != nil {
+		return googleLoginFailed(ctx, email, err.Error())
+	}
+
+	recordAuthEvent(ctx, db.AuthEventGoogleSucceeded, addr.Address, "")
+	return h.completeLogin(ctx, &addr, login.Get("redirect"))
+}
+
+// googleLoginFailed records and refuses a failed sign in with Google.
+func googleLoginFailed(ctx *fiber.Ctx, email string, reason string) error {
+	log.Warnf("Google sign in failed for %q:\t%s\n", email, reason)
+	recordAuthEvent(ctx, db.AuthEventGoogleFailed, email, reason)
+	return ctx.Status(fiber.StatusUnauthorized).SendString("Sign in with Google failed: " + reason)
+}
+
+// googleRedirectURL returns the callback URL registered for the sign in with Google,
+// read from env or built from the request base URL.
+func googleRedirectURL(ctx *fiber.Ctx) string {
+	return utils.ReadEnvOrDefault(utils.GOOGLELOGINREDIRECT, ctx.BaseURL()+GoogleCallbackPath)
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"aat-manager/db"
+	"aat-manager/gsuite"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// GetGoogleToken answers with the versions of the stored Google API token, current first, without their value.
+// Only tokens stored in db are versioned, see utils.WEBAUTH.
+func (h *Handler) GetGoogleToken(ctx *fiber.Ctx) error {
+	versions, err := db.Token{}.ListTokenVersions()
+	if err != nil {
+		log.Errorf("Error listing Google token versions:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(versions)
+}
+
+// GetGoogleStatus answers with the state of the Google integration, and the authorization URL while it waits for it.
+func (h *Handler) GetGoogleStatus(ctx *fiber.Ctx) error {
+	if h.Google == nil {
+		return ctx.Status(fiber.StatusNotImplemented).SendString("This service is not enabled.")
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(h.Google.Status())
+}
+
+// DeleteGoogleToken revokes the stored Google API token at Google and deletes it, resetting the Google integration:
+// in web auth mode it waits for a new authorization, see GetGoogleStatus.
+// A failed revocation at Google is only logged, the token is deleted anyway.
+func (h *Handler) DeleteGoogleToken(ctx *fiber.Ctx) error {
+	principal, _ := getPrincipal(ctx)
+
+	deleted, err := gsuite.DeleteToken(ctx.Context(), principal.Email)
+	if !deleted && err != nil {
+		log.Errorf("Error deleting Google token:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	if !deleted {
+		return ctx.Status(fiber.StatusNotFound).SendString("Google token not found.")
+	}
+	if err != nil {
+		log.Warnf("Google token deleted but not revoked at Google:\t%s\n", err)
+	}
+
+	log.Infof("Google token deleted by %s", principal.Email)
+
+	if h.Google != nil {
+		h.Google.Reset()
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
@@ -1,8 +1,18 @@
 package handlers
 
 import (
-	"fmt"
+	"aat-manager/authenticator"
+	"aat-manager/gsuite"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"github.com/gofiber/fiber/v2"
+	"math"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
@@ -10,17 +20,211 @@ const (
 	PendingAuthCookieName = "pendingauth"
 	LoginURL              = "/login/login.html"
 	CheckOTPURL           = "/login/checkotp.html"
+	PrincipalLocalsKey    = "principal"
+	CSRFCookieName        = "csrf"
+	CSRFFormField         = "csrf"
+	CSRFHeader            = "X-CSRF-Token"
+	CSRFCookiePath        = "/login"
+	GoogleLoginCookieName = "googlelogin"
+	GoogleLoginPath       = "/login/google"
+	GoogleCallbackPath    = "/login/google/callback"
 )
 
+// Principal represents the authenticated user of a request, as set by JWTAuthenticationMiddleware.
+type Principal struct {
+	UserID    int64  // User ID from JWT sub claim, 0 for tokens issued before the users had an ID
+	Name      string // User display name from JWT name claim
+	Email     string // User full e-mail from the session
+	Manager   bool   // True if user has manager role
+	Role      string // User role from JWT role claim
+	SessionID string // Session ID from JWT jti claim
+
+	APIKeyID int64    // API key ID, set only for requests authenticated by API key
+	Scopes   []string // Scopes granted to the API key
+}
+
+// HasScope reports whether the principal API key was granted the given scope.
+func (p Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorResponse is the JSON body of refused API requests.
+type ErrorResponse struct {
+	Error   string `json:"error"`   // Machine readable error code
+	Message string `json:"message"` // Human readable description
+}
+
+// APIPrefix is the path prefix of the JSON API routes
+const APIPrefix = "/api/"
+
+// APIKeyHeader is the header carrying API keys
+const APIKeyHeader = "X-API-Key"
+
+// RenewedTokenHeader is the response header carrying the renewed token to bearer token clients
+const RenewedTokenHeader = "X-Renewed-Token"
+
+// errUserInactive refuses the token renewal of a deactivated user
+var errUserInactive = errors.New("user inactive")
+
+// errMalformedPendingAuth refuses pending auth cookies not set by setPendingAuthCookie
+var errMalformedPendingAuth = errors.New("malformed pending auth cookie")
+
+// getBearerToken extracts the token from an "Authorization: Bearer <token>" header.
+// It returns an empty string if the header is missing or uses another scheme.
+func getBearerToken(ctx *fiber.Ctx) string {
+	scheme, token, found := strings.Cut(ctx.Get(fiber.HeaderAuthorization), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// getAPIKey extracts an API key from the X-API-Key header or from the Authorization bearer header.
+// It returns an empty string if the request carries no API key.
+func getAPIKey(ctx *fiber.Ctx) string {
+	if key := ctx.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	if token := getBearerToken(ctx); authenticator.IsAPIKey(token) {
+		return token
+	}
+	return ""
+}
+
+// wantsJSON reports whether a refused request should get a JSON body instead of a redirect.
+// That is the case for API routes called with a bearer token or preferring JSON over HTML.
+func wantsJSON(ctx *fiber.Ctx) bool {
+	if !strings.HasPrefix(ctx.Path(), APIPrefix) {
+		return false
+	}
+	if getBearerToken(ctx) != "" || ctx.Get(APIKeyHeader) != "" {
+		return true
+	}
+	return ctx.Accepts(fiber.MIMETextHTML, fiber.MIMEApplicationJSON) == fiber.MIMEApplicationJSON
+}
+
+// googleErrorStatus returns the response status of a failed Google service call:
+// 503 while the Google integration is not authorized, 500 otherwise.
+func googleErrorStatus(err error) int {
+	if errors.Is(err, gsuite.ErrNotReady) {
+		return fiber.StatusServiceUnavailable
+	}
+	return fiber.StatusInternalServerError
+}
+
+// getPrincipal retrieves the authenticated user stored in the request context.
+// It returns false if the request didn't go through the authentication middleware.
+func getPrincipal(ctx *fiber.Ctx) (Principal, bool) {
+	p, ok := ctx.Locals(PrincipalLocalsKey).(Principal)
+	return p, ok
+}
+
+// csrfTokenBytes is the length of the random CSRF token
+const csrfTokenBytes = 32
+
+// newCSRFToken returns a random hex encoded CSRF token.
+func newCSRFToken() (string, error) {
+	return randomHex(csrfTokenBytes)
+}
+
+// randomHex returns n random bytes, hex encoded.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validCSRFToken reports whether token has the form of a token issued by newCSRFToken.
+func validCSRFToken(token string) bool {
+	b, err := hex.DecodeString(token)
+	return err == nil && len(b) == csrfTokenBytes
+}
+
+// pendingAuthNonceBytes is the length of the random pending auth nonce
+const pendingAuthNonceBytes = 16
+
+// newPendingAuthNonce returns a random hex encoded nonce, binding the magic link to the pending auth cookie.
+func newPendingAuthNonce() (string, error) {
+	return randomHex(pendingAuthNonceBytes)
+}
+
+// setPendingAuthCookie sets the pending auth cookie, carrying the nonce and the mail address as "nonce:address".
+// The nonce is only known to the browser requesting the OTP, see authenticator.GenMagicLinkAndSave.
+func setPendingAuthCookie(ctx *fiber.Ctx, address string, nonce string) {
+	ctx.Cookie(&fiber.Cookie{
+		Name:        PendingAuthCookieName,
+		Value:       nonce + ":" + address,
+		Expires:     time.Now().Add(time.Minute * 3),
+		Secure:      false,
+		HTTPOnly:    true,
+		SameSite:    "lax",
+		SessionOnly: false,
+	})
+}
+
+// readPendingAuth returns the mail address and the nonce of the pending auth cookie set by setPendingAuthCookie.
+// The nonce is hex encoded, so the first ":" ends it even if the address contains others.
+func readPendingAuth(ctx *fiber.Ctx) (*mail.Address, string, error) {
+	nonce, address, found := strings.Cut(ctx.Cookies(PendingAuthCookieName), ":")
+	if !found || nonce == "" {
+		return nil, "", errMalformedPendingAuth
+	}
+
+	addr, err := mail.ParseAddress(address)
+	if err != nil {
+		return nil, "", err
+	}
+	return addr, nonce, nil
+}
+
+// dateLayout is the layout of date only query parameters
+const dateLayout = "2006-01-02"
+
+// parseDateFilter parses a RFC 3339 time or a YYYY-MM-DD date, in UTC, from a query parameter.
+// With endOfDay, a date is moved to the start of the next day, to be used as exclusive upper bound.
+// A blank value returns the zero time.
+func parseDateFilter(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(dateLayout, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// retryAfterSeconds formats a wait as a Retry-After header value, rounding up to the next second.
+func retryAfterSeconds(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
 // getRedirectPath constructs the redirection URL with the current path.
+// The path is checked by the redirect policy and query escaped.
 func getRedirectPath(page string, ctx *fiber.Ctx) string {
-	return fmt.Sprintf("%s?redirect=%s", page, ctx.Path())
+	redirectPage := currentRedirectPolicy().Sanitize(ctx.Path())
+	return page + "?redirect=" + url.QueryEscape(redirectPage)
 }
 
 // getChainableRedirectPath constructs a redirection URL with an additional redirect query parameter.
 // The redirectPage parameter is extracted from the "redirect" query string of the given fiber.Ctx instance.
-// The constructed URL is formed by appending the redirectPage to the provided page parameter.
+// The constructed URL is formed by appending the redirectPage, checked by the redirect policy and query escaped,
+// to the provided page parameter.
 func getChainableRedirectPath(page string, ctx *fiber.Ctx) string {
-	redirectPage := ctx.Query("redirect")
-	return fmt.Sprintf("%s?redirect=%s", page, redirectPage)
+	redirectPage := currentRedirectPolicy().Sanitize(ctx.Query("redirect"))
+	return page + "?redirect=" + url.QueryEscape(redirectPage)
 }
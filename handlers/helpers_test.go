@@ -1,11 +1,18 @@
 package handlers
 
 import (
+	"aat-manager/authenticator"
+	"aat-manager/db"
+	"aat-manager/utils"
+	"errors"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/valyala/fasthttp"
+	"net/http/httptest"
 	"net/url"
+	"os"
 	"testing"
+	"time"
 )
 
 func TestGetRedirectPath(t *testing.T) {
@@ -19,25 +26,25 @@ func TestGetRedirectPath(t *testing.T) {
 			name:           "Empty Page and Path",
 			page:           "",
 			ctxPath:        "",
-			expectRedirect: "?redirect=",
+			expectRedirect: "?redirect=%2F",
 		},
 		{
 			name:           "Non-Empty Page, Empty Path",
 			page:           "/home",
 			ctxPath:        "",
-			expectRedirect: "/home?redirect=",
+			expectRedirect: "/home?redirect=%2F",
 		},
 		{
 			name:           "Non-Empty Page and Path",
 			page:           "/home",
 			ctxPath:        "/login",
-			expectRedirect: "/home?redirect=/login",
+			expectRedirect: "/home?redirect=%2Flogin",
 		},
 		{
 			name:           "Empty Page, Non-Empty Path",
 			page:           "",
 			ctxPath:        "/login",
-			expectRedirect: "?redirect=/login",
+			expectRedirect: "?redirect=%2Flogin",
 		},
 	}
 
@@ -66,25 +73,37 @@ func TestGetChainableRedirectPath(t *testing.T) {
 			name:         "RedirectToHomePage",
 			page:         "/home",
 			redirectPage: "/login",
-			expected:     "/home?redirect=/login",
+			expected:     "/home?redirect=%2Flogin",
 		},
 		{
 			name:         "RedirectToProfilePage",
 			page:         "/profile",
 			redirectPage: "/home",
-			expected:     "/profile?redirect=/home",
+			expected:     "/profile?redirect=%2Fhome",
 		},
 		{
 			name:         "NoRedirectPage",
 			page:         "/profile",
 			redirectPage: "",
-			expected:     "/profile?redirect=",
+			expected:     "/profile?redirect=%2F",
 		},
 		{
 			name:         "RedirectToURLWithQueryParameters",
 			page:         "/search",
 			redirectPage: "/products?productID=1234",
-			expected:     "/search?redirect=/products?productID=1234",
+			expected:     "/search?redirect=%2Fproducts%3FproductID%3D1234",
+		},
+		{
+			name:         "ProtocolRelativeRedirect",
+			page:         "/login",
+			redirectPage: "//evil.com",
+			expected:     "/login?redirect=%2F",
+		},
+		{
+			name:         "AbsoluteRedirect",
+			page:         "/login",
+			redirectPage: "https://evil.com/",
+			expected:     "/login?redirect=%2F",
 		},
 	}
 
@@ -103,3 +122,281 @@ func TestGetChainableRedirectPath(t *testing.T) {
 		})
 	}
 }
+
+func TestRedirectPolicySanitize(t *testing.T) {
+	policy := NewRedirectPolicy(" https://Fleet.example.org/ , http://localhost:3000")
+
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{name: "Empty", target: "", want: DefaultRedirect},
+		{name: "Relative path", target: "/api/v1/sessions", want: "/api/v1/sessions"},
+		{name: "Relative path with query", target: "/search?productID=1234&page=2", want: "/search?productID=1234&page=2"},
+		{name: "Root", target: "/", want: "/"},
+		{name: "Path without leading slash", target: "login", want: DefaultRedirect},
+		{name: "Protocol relative", target: "//evil.com", want: DefaultRedirect},
+		{name: "Protocol relative with path", target: "//evil.com/login", want: DefaultRedirect},
+		{name: "Backslash", target: "/\\evil.com", want: DefaultRedirect},
+		{name: "Mixed slashes", target: "\\/evil.com", want: DefaultRedirect},
+		{name: "Encoded slashes", target: "/%2F%2Fevil.com", want: DefaultRedirect},
+		{name: "Encoded protocol relative", target: "%2F%2Fevil.com", want: DefaultRedirect},
+		{name: "Encoded backslash", target: "/%5Cevil.com", want: DefaultRedirect},
+		{name: "Tab in scheme separator", target: "/\t/evil.com", want: DefaultRedirect},
+		{name: "Encoded newline", target: "/%0A/evil.com", want: DefaultRedirect},
+		{name: "Malformed escape", target: "/%zz", want: DefaultRedirect},
+		{name: "JavaScript scheme", target: "javascript:alert(1)", want: DefaultRedirect},
+		{name: "Data scheme", target: "data:text/html,<script>alert(1)</script>", want: DefaultRedirect},
+		{name: "Foreign origin", target: "https://evil.com/", want: DefaultRedirect},
+		{name: "Allowed origin suffix", target: "https://fleet.example.org.evil.com/", want: DefaultRedirect},
+		{name: "Allowed origin other scheme", target: "http://fleet.example.org/", want: DefaultRedirect},
+		{name: "Allowed origin with user info", target: "https://evil.com@fleet.example.org/", want: DefaultRedirect},
+		{name: "Allowed origin", target: "https://fleet.example.org/issues", want: "https://fleet.example.org/issues"},
+		{name: "Allowed origin case insensitive", target: "https://FLEET.example.org/", want: "https://FLEET.example.org/"},
+		{name: "Allowed origin with port", target: "http://localhost:3000/", want: "http://localhost:3000/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Sanitize(tt.target); got != tt.want {
+				t.Errorf("Sanitize(%q) = %q, want %q", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRedirectPolicyWithoutAllowlist(t *testing.T) {
+	os.Setenv(utils.REDIRECTALLOWLIST, "")
+	policy := currentRedirectPolicy()
+
+	if len(policy.AllowedOrigins) != 0 || policy.Default != DefaultRedirect {
+		t.Fatalf("currentRedirectPolicy() = %+v, want no allowed origin", policy)
+	}
+	if got := policy.Sanitize("https://fleet.example.org/"); got != DefaultRedirect {
+		t.Errorf("Sanitize() = %q, want %q", got, DefaultRedirect)
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	tests := []struct {
+		name string
+		wait time.Duration
+		want string
+	}{
+		{name: "Whole seconds", wait: 15 * time.Second, want: "15"},
+		{name: "Rounded up", wait: 1500 * time.Millisecond, want: "2"},
+		{name: "No wait", wait: 0, want: "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryAfterSeconds(tt.wait); got != tt.want {
+				t.Errorf("retryAfterSeconds(%v) = %v, want %v", tt.wait, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetBearerToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{name: "Bearer token", header: "Bearer abc.def.ghi", want: "abc.def.ghi"},
+		{name: "Lowercase scheme", header: "bearer abc.def.ghi", want: "abc.def.ghi"},
+		{name: "Basic scheme", header: "Basic dXNlcjpwYXNz", want: ""},
+		{name: "Missing token", header: "Bearer", want: ""},
+		{name: "No header", header: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			ctx := app.AcquireCtx(&fasthttp.RequestCtx{})
+			defer app.ReleaseCtx(ctx)
+			if tt.header != "" {
+				ctx.Request().Header.Set(fiber.HeaderAuthorization, tt.header)
+			}
+
+			if got := getBearerToken(ctx); got != tt.want {
+				t.Errorf("getBearerToken() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadPendingAuth(t *testing.T) {
+	tests := []struct {
+		name      string
+		cookie    string
+		wantMail  string
+		wantNonce string
+		wantErr   bool
+	}{
+		{name: "Nonce and address", cookie: "abc123:user@test.com", wantMail: "user@test.com", wantNonce: "abc123"},
+		{name: "Address without nonce", cookie: "user@test.com", wantErr: true},
+		{name: "Blank nonce", cookie: ":user@test.com", wantErr: true},
+		{name: "Malformed address", cookie: "abc123:user", wantErr: true},
+		{name: "No cookie", cookie: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			ctx := app.AcquireCtx(&fasthttp.RequestCtx{})
+			defer app.ReleaseCtx(ctx)
+			if tt.cookie != "" {
+				ctx.Request().Header.SetCookie(PendingAuthCookieName, tt.cookie)
+			}
+
+			addr, nonce, err := readPendingAuth(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readPendingAuth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (addr.Address != tt.wantMail || nonce != tt.wantNonce) {
+				t.Errorf("readPendingAuth() = %q, %q, want %q, %q", addr.Address, nonce, tt.wantMail, tt.wantNonce)
+			}
+		})
+	}
+}
+
+func TestWantsJSON(t *testing.T) {
+	tests := []struct {
+		name          string
+		path          string
+		accept        string
+		authorization string
+		want          bool
+	}{
+		{name: "API JSON client", path: "/api/v1/vehicles", accept: "application/json", want: true},
+		{name: "API bearer client", path: "/api/v1/vehicles", accept: "*/*", authorization: "Bearer token", want: true},
+		{name: "API browser", path: "/api/v1/vehicles", accept: "text/html,application/xhtml+xml,*/*;q=0.8", want: false},
+		{name: "API without accept", path: "/api/v1/vehicles", want: false},
+		{name: "Page asking JSON", path: "/login", accept: "application/json", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			fasthttpCtx := &fasthttp.RequestCtx{}
+			fasthttpCtx.Request.SetRequestURI(tt.path)
+			if tt.accept != "" {
+				fasthttpCtx.Request.Header.Set(fiber.HeaderAccept, tt.accept)
+			}
+			if tt.authorization != "" {
+				fasthttpCtx.Request.Header.Set(fiber.HeaderAuthorization, tt.authorization)
+			}
+			ctx := app.AcquireCtx(fasthttpCtx)
+			defer app.ReleaseCtx(ctx)
+
+			if got := wantsJSON(ctx); got != tt.want {
+				t.Errorf("wantsJSON() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDateFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		endOfDay bool
+		want     time.Time
+		wantErr  bool
+	}{
+		{name: "Blank", value: "", want: time.Time{}},
+		{name: "Date", value: "2024-03-01", want: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "Date end of day", value: "2024-03-01", endOfDay: true, want: time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)},
+		{name: "RFC 3339", value: "2024-03-01T10:30:00Z", want: time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)},
+		{name: "RFC 3339 end of day", value: "2024-03-01T10:30:00Z", endOfDay: true, want: time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)},
+		{name: "Malformed", value: "01/03/2024", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDateFilter(tt.value, tt.endOfDay)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDateFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseDateFilter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowRequest(t *testing.T) {
+	store := db.NewDB()
+	defer store.Close()
+	limiter := authenticator.NewRateLimiter("test-ip", 1, time.Hour, store)
+
+	app := fiber.New()
+	app.Post("/login", func(ctx *fiber.Ctx) error {
+		if allowed, err := allowRequest(ctx, limiter, "1.2.3.4"); !allowed {
+			return err
+		}
+		return ctx.SendStatus(fiber.StatusOK)
+	})
+
+	tests := []struct {
+		name           string
+		wantStatus     int
+		wantRetryAfter bool
+	}{
+		{name: "Within limit", wantStatus: fiber.StatusOK},
+		{name: "Over limit", wantStatus: fiber.StatusTooManyRequests, wantRetryAfter: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, "/login", nil))
+			if err != nil {
+				t.Fatalf("app.Test() error = %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := resp.Header.Get(fiber.HeaderRetryAfter); (got != "") != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want set %v", got, tt.wantRetryAfter)
+			}
+		})
+	}
+
+	if got := authMetrics.Get("rate_limited_test-ip"); got == nil || got.String() != "1" {
+		t.Errorf("rate_limited_test-ip metric = %v, want 1", got)
+	}
+}
+
+// failingStore is an OTP store whose reads and counters always fail
+type failingStore struct {
+	db.OtpStore
+}
+
+func (failingStore) Incr(string, int, time.Duration) (int, error) {
+	return 0, errors.New("store unavailable")
+}
+
+func TestAllowRequestStoreFailure(t *testing.T) {
+	limiter := authenticator.NewRateLimiter("test-failing", 1, time.Hour, failingStore{})
+
+	app := fiber.New()
+	app.Post("/login", func(ctx *fiber.Ctx) error {
+		if allowed, err := allowRequest(ctx, limiter, "1.2.3.4"); !allowed {
+			return err
+		}
+		return ctx.SendStatus(fiber.StatusOK)
+	})
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, "/login", nil))
+	if err != nil {
+		t.Fatalf("app.Test() error = %v", err)
+	}
+	if resp.StatusCode != fiber.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", resp.StatusCode, fiber.StatusServiceUnavailable)
+	}
+	if got := authMetrics.Get("rate_limit_failed_test-failing"); got == nil || got.String() != "1" {
+		t.Errorf("rate_limit_failed_test-failing metric = %v, want 1", got)
+	}
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"aat-manager/gsuite"
+	"aat-manager/issues"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+type IssueData struct {
+	Category    string `json:"category,omitempty" form:"category"`
+	Severity    string `json:"severity,omitempty" form:"severity"`
+	Description string `json:"description,omitempty" form:"description"`
+}
+
+// PostVehicleIssue reads an issue report from the request body and appends it to the vehicle sheet.
+// The vehicle is taken from the route parameter and the reporter from the authenticated user.
+// It answers with the created issue.
+func (h *Handler) PostVehicleIssue(ctx *fiber.Ctx) error {
+	if !h.initialized {
+		return ctx.Status(fiber.StatusNotImplemented).SendString("This service is not enabled.")
+	}
+
+	principal, ok := getPrincipal(ctx)
+	if !ok {
+		return ctx.Status(fiber.StatusUnauthorized).SendString("Missing authenticated user.")
+	}
+
+	formData := new(IssueData)
+
+	// Read issue from request
+	if err := ctx.BodyParser(formData); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	// Create and validate the issue
+	issue, err := issues.NewVehicleIssue(ctx.Params("id"), formData.Category, formData.Severity, formData.Description, principal.Name)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	// Append issue to vehicle sheet
+	_, err = h.SheetService.Append(gsuite.VehicleSheet, issues.VehicleIssueRange, [][]interface{}{issue.Row()})
+	if err != nil {
+		log.Errorf("Error appending vehicle issue:\t%s\n", err)
+		return ctx.Status(googleErrorStatus(err)).SendString(err.Error())
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(issue)
+}
+
+// GetVehicleIssues reads all the issues from the vehicle sheet and answers with the ones
+// reported on the vehicle from the route parameter.
+// The optional "status" query filters issues by open/closed status.
+// Malformed rows are logged and skipped.
+func (h *Handler) GetVehicleIssues(ctx *fiber.Ctx) error {
+	if !h.initialized {
+		return ctx.Status(fiber.StatusNotImplemented).SendString("This service is not enabled.")
+	}
+
+	vehicle := ctx.Params("id")
+	status, err := getStatusFilter(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	records, err := h.SheetService.GetAllRecords(gsuite.VehicleSheet, issues.VehicleIssueTab, issues.VehicleIssueColumns)
+	if err != nil {
+		log.Errorf("Error reading vehicle issues:\t%s\n", err)
+		return ctx.Status(googleErrorStatus(err)).SendString(err.Error())
+	}
+
+	res := make([]issues.VehicleIssue, 0)
+	for i, record := range records {
+		issue, err := issues.VehicleIssueFromRow(record)
+		if err != nil {
+			log.Warnf("Skipping vehicle sheet row %d:\t%s\n", i+2, err)
+			continue
+		}
+		if issue.Vehicle == vehicle && (status == "" || issue.Status == status) {
+			res = append(res, issue)
+		}
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(res)
+}
+
+// PostStationIssue reads an issue report from the request body and appends it to the station sheet.
+// The station is taken from the route parameter and the reporter from the authenticated user.
+// It answers with the created issue.
+func (h *Handler) PostStationIssue(ctx *fiber.Ctx) error {
+	if !h.initialized {
+		return ctx.Status(fiber.StatusNotImplemented).SendString("This service is not enabled.")
+	}
+
+	principal, ok := getPrincipal(ctx)
+	if !ok {
+		return ctx.Status(fiber.StatusUnauthorized).SendString("Missing authenticated user.")
+	}
+
+	formData := new(IssueData)
+
+	// Read issue from request
+	if err := ctx.BodyParser(formData); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	// Create and validate the issue
+	issue, err := issues.NewStationIssue(ctx.Params("id"), formData.Category, formData.Severity, formData.Description, principal.Name)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	// Append issue to station sheet
+	_, err = h.SheetService.Append(gsuite.StationSheet, issues.StationIssueRange, [][]interface{}{issue.Row()})
+	if err != nil {
+		log.Errorf("Error appending station issue:\t%s\n", err)
+		return ctx.Status(googleErrorStatus(err)).SendString(err.Error())
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(issue)
+}
+
+// GetStationIssues reads all the issues from the station sheet and answers with the ones
+// reported on the station from the route parameter.
+// The optional "status" query filters issues by open/closed status.
+// Malformed rows are logged and skipped.
+func (h *Handler) GetStationIssues(ctx *fiber.Ctx) error {
+	if !h.initialized {
+		return ctx.Status(fiber.StatusNotImplemented).SendString("This service is not enabled.")
+	}
+
+	station := ctx.Params("id")
+	status, err := getStatusFilter(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	records, err := h.SheetService.GetAllRecords(gsuite.StationSheet, issues.StationIssueTab, issues.StationIssueColumns)
+	if err != nil {
+		log.Errorf("Error reading station issues:\t%s\n", err)
+		return ctx.Status(googleErrorStatus(err)).SendString(err.Error())
+	}
+
+	res := make([]issues.StationIssue, 0)
+	for i, record := range records {
+		issue, err := issues.StationIssueFromRow(record)
+		if err != nil {
+			log.Warnf("Skipping station sheet row %d:\t%s\n", i+2, err)
+			continue
+		}
+		if issue.Station == station && (status == "" || issue.Status == status) {
+			res = append(res, issue)
+		}
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(res)
+}
+
+// getStatusFilter reads the optional "status" query used to filter issue lists.
+// It returns an empty string if no filter is requested and issues.ErrInvalidStatus for unknown statuses.
+func getStatusFilter(ctx *fiber.Ctx) (string, error) {
+	status := ctx.Query("status")
+	if status != "" && !issues.ValidStatus(status) {
+		return "", issues.ErrInvalidStatus
+	}
+	return status, nil
+}
+
+// CloseVehicleIssue sets to closed the status of the vehicle issue with the given ID.
+// It answers with 404 if no issue with such ID was reported on the vehicle.
+func (h *Handler) CloseVehicleIssue(ctx *fiber.Ctx) error {
+	if !h.initialized {
+		return ctx.Status(fiber.StatusNotImplemented).SendString("This service is not enabled.")
+	}
+
+	vehicle := ctx.Params("id")
+	issueID := ctx.Params("issue")
+
+	return h.closeIssue(ctx, gsuite.VehicleSheet, issues.VehicleIssueTab, issues.VehicleIssueColumns, func(record []interface{}) bool {
+		issue, err := issues.VehicleIssueFromRow(record)
+		return err == nil && issue.ID == issueID && issue.Vehicle == vehicle
+	})
+}
+
+// CloseStationIssue sets to closed the status of the station issue with the given ID.
+// It answers with 404 if no issue with such ID was reported on the station.
+func (h *Handler) CloseStationIssue(ctx *fiber.Ctx) error {
+	if !h.initialized {
+		return ctx.Status(fiber.StatusNotImplemented).SendString("This service is not enabled.")
+	}
+
+	station := ctx.Params("id")
+	issueID := ctx.Params("issue")
+
+	return h.closeIssue(ctx, gsuite.StationSheet, issues.StationIssueTab, issues.StationIssueColumns, func(record []interface{}) bool {
+		issue, err := issues.StationIssueFromRow(record)
+		return err == nil && issue.ID == issueID && issue.Station == station
+	})
+}
+
+// closeIssue searches the sheet for the first record accepted by match and sets its status to closed.
+// Issue rows are only ever appended, so the row index read here is still valid when updating.
+func (h *Handler) closeIssue(ctx *fiber.Ctx, sheet string, tab string, columns int, match func(record []interface{}) bool) error {
+	records, err := h.SheetService.GetAllRecords(sheet, tab, columns)
+	if err != nil {
+		log.Errorf("Error reading issues:\t%s\n", err)
+		return ctx.Status(googleErrorStatus(err)).SendString(err.Error())
+	}
+
+	for i, record := range records {
+		if !match(record) {
+			continue
+		}
+
+		// Records are read starting from the second sheet row
+		_, err = h.SheetService.Update(sheet, issues.StatusRange(tab, i+2), issues.StatusValues(issues.StatusClosed))
+		if err != nil {
+			log.Errorf("Error closing issue:\t%s\n", err)
+			return ctx.Status(googleErrorStatus(err)).SendString(err.Error())
+		}
+
+		return ctx.SendStatus(fiber.StatusNoContent)
+	}
+
+	return ctx.Status(fiber.StatusNotFound).SendString("Issue not found.")
+}
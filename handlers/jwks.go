@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"aat-manager/authenticator"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// JWKS answers with the public keys of the JWT key ring, so other services can verify the tokens.
+// HMAC secrets are never published, with only HS256 keys the key set is empty.
+func JWKS(ctx *fiber.Ctx) error {
+	ring, err := authenticator.CurrentKeyRing()
+	if err != nil {
+		log.Errorf("Error reading JWT key ring:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	ctx.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return ctx.Status(fiber.StatusOK).JSON(ring.JWKS())
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"aat-manager/authenticator"
+	"aat-manager/db"
+	"aat-manager/utils"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"net/mail"
+	"strings"
+)
+
+type LoginPolicyData struct {
+	Kind  string `json:"kind,omitempty" form:"kind"`   // domain, allow or block
+	Value string `json:"value,omitempty" form:"value"` // Domain for domain entries, e-mail address otherwise
+}
+
+// LoginPolicy is the answer of GetLoginPolicy.
+type LoginPolicy struct {
+	Domain  string                `json:"domain"`  // Domain authorized by the AUTHDOMAIN env variable, can't be removed
+	Entries []db.LoginPolicyEntry `json:"entries"` // Stored entries
+}
+
+// GetLoginPolicy answers with the authorized domains and the allowed and blocked addresses.
+func (h *Handler) GetLoginPolicy(ctx *fiber.Ctx) error {
+	entries, err := db.LoginPolicies{}.ListPolicyEntries()
+	if err != nil {
+		log.Errorf("Error listing login policy:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(LoginPolicy{
+		Domain:  strings.ToLower(utils.ReadEnvOrPanic(utils.AUTHORIZEDDOMAIN)),
+		Entries: entries,
+	})
+}
+
+// AddLoginPolicyEntry adds the authorized domain, allowed or blocked address read from the request body.
+// The change applies to this instance immediately, to the others within 30 seconds, see authenticator.CurrentMailPolicy.
+// Adding a blocked address revokes its sessions.
+func (h *Handler) AddLoginPolicyEntry(ctx *fiber.Ctx) error {
+	formData := new(LoginPolicyData)
+	if err := ctx.BodyParser(formData); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	value, err := normalizePolicyValue(formData.Kind, formData.Value)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	principal, _ := getPrincipal(ctx)
+	entry, err := db.LoginPolicies{}.AddPolicyEntry(formData.Kind, value, principal.Email)
+	if err != nil {
+		log.Errorf("Error adding login policy entry:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	authenticator.InvalidateMailPolicy()
+
+	// Blocked addresses are logged out from all devices
+	if entry.Kind == db.PolicyBlock {
+		if _, err := (db.Sessions{}).RevokeUserSessions(entry.Value); err != nil {
+			log.Errorf("Error revoking user sessions:\t%s\n", err)
+			return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+	}
+
+	log.Infof("Login policy %s %s added by %s", entry.Kind, entry.Value, principal.Email)
+
+	return ctx.Status(fiber.StatusCreated).JSON(entry)
+}
+
+// DeleteLoginPolicyEntry removes the login policy entry whose kind and value are in the route parameters.
+func (h *Handler) DeleteLoginPolicyEntry(ctx *fiber.Ctx) error {
+	kind := ctx.Params("kind")
+	value, err := normalizePolicyValue(kind, ctx.Params("value"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	deleted, err := db.LoginPolicies{}.DeletePolicyEntry(kind, value)
+	if err != nil {
+		log.Errorf("Error deleting login policy entry:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	if !deleted {
+		return ctx.Status(fiber.StatusNotFound).SendString("Login policy entry not found.")
+	}
+	authenticator.InvalidateMailPolicy()
+
+	principal, _ := getPrincipal(ctx)
+	log.Infof("Login policy %s %s deleted by %s", kind, value, principal.Email)
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// normalizePolicyValue validates a login policy entry and returns its value as stored:
+// a lowercase domain for domain entries, a lowercase e-mail address otherwise.
+func normalizePolicyValue(kind string, value string) (string, error) {
+	switch kind {
+	case db.PolicyDomain:
+		domain := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(value), "@"))
+		if domain == "" || strings.ContainsAny(domain, "@ ,") {
+			return "", errors.New("invalid domain: " + value)
+		}
+		return domain, nil
+	case db.PolicyAllow, db.PolicyBlock:
+		addr, err := mail.ParseAddress(value)
+		if err != nil {
+			return "", err
+		}
+		return strings.ToLower(addr.Address), nil
+	default:
+		return "", errors.New("unknown login policy kind: " + kind)
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"aat-manager/authenticator"
+	"aat-manager/db"
+	"aat-manager/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+// MagicLinkPath is the route opening the magic links
+const MagicLinkPath = "/login/magic"
+
+// otpMailMessage returns the body of the OTP mail.
+// When utils.MAGICLINKURL is set, the mail also carries a single-use link logging in without typing the OTP,
+// which keeps the redirect of the login request and is bound to the nonce of the pending auth cookie. The link base URL comes from env rather than from the request,
+// so a forged Host header can't send the link to another site.
+func (h *Handler) otpMailMessage(ctx *fiber.Ctx, addr mail.Address, pendingNonce string, otp string) (string, error) {
+	message := "Ecco il tuo codice di verifica:\t" + otp
+
+	baseURL := utils.ReadEnvOrDefault(utils.MAGICLINKURL, "")
+	if baseURL == "" {
+		return message, nil
+	}
+
+	token, err := authenticator.GenMagicLinkAndSave(addr, pendingNonce, h.Db)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{
+		"token":    {token},
+		"redirect": {currentRedirectPolicy().Sanitize(ctx.Query("redirect"))},
+	}
+	link := strings.TrimRight(baseURL, "/") + MagicLinkPath + "?" + query.Encode()
+
+	return message + "\r\n\r\nOppure accedi direttamente da questo dispositivo:\r\n" + link, nil
+}
+
+// MagicLogin logs in the user with the magic link sent by GetMailAndSendBackOtp.
+// The link must be opened in the browser that requested it, that is with the pending auth cookie of the same mailbox and nonce,
+// it completes the authentication as a valid OTP and redirects to the original url.
+func (h *Handler) MagicLogin(ctx *fiber.Ctx) error {
+	if !h.initialized {
+		return ctx.Status(fiber.StatusNotImplemented).SendString("This service is not enabled.")
+	}
+
+	// Read user's email and nonce from pendingAuthCookie
+	userEmail, nonce, err := readPendingAuth(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Open the link in the browser where you requested it.")
+	}
+
+	valid, err := authenticator.CheckMagicLinkAndDelete(*userEmail, nonce, ctx.Query("token"), h.Db)
+	if err != nil || !valid {
+		log.Warnf("Magic link refused for %s:\t%s\n", userEmail.Address, err)
+		recordAuthEvent(ctx, db.AuthEventMagicLinkFailed, userEmail.Address, errorDetail(err))
+		return ctx.Status(fiber.StatusUnauthorized).SendString("Link expired or already used, request a new one.")
+	}
+
+	recordAuthEvent(ctx, db.AuthEventMagicLinkSucceeded, userEmail.Address, "")
+	return h.completeLogin(ctx, userEmail, ctx.Query("redirect"))
+}
+
+// errorDetail returns the error message, or an empty string for nil errors.
+func errorDetail(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
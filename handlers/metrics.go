@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"aat-manager/authenticator"
+	"errors"
+	"expvar"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// Default OTP request and authenticator app login limits, see utils.OTPRATELIMITIP, utils.OTPRATELIMITMAILBOX
+// and utils.TOTPRATELIMIT
+const (
+	DefaultOtpRateLimitIP      = "20/1h"
+	DefaultOtpRateLimitMailbox = "5/1h"
+	DefaultTotpRateLimit       = "10/15m"
+)
+
+// authMetrics counts the auth events, the rate limited requests and the rate limit failures of this instance, published by expvar under "auth"
+var authMetrics = expvar.NewMap("auth")
+
+// allowRequest counts the request against the limiter key and reports whether it is allowed.
+// If the limit is reached it answers with 429 and Retry-After, the returned error is the send error.
+// If the limiter store fails it answers with 503, so the limit fails closed, and counts the failure in the auth metrics.
+// A nil limiter allows every request.
+func allowRequest(ctx *fiber.Ctx, limiter *authenticator.RateLimiter, key string) (bool, error) {
+	if limiter == nil {
+		return true, nil
+	}
+
+	err := limiter.Allow(key)
+	if err == nil {
+		return true, nil
+	}
+
+	var retryErr *authenticator.RetryError
+	if !errors.As(err, &retryErr) {
+		log.Errorf("Rate limit %s not available on %s:\t%s\n", limiter.Name, ctx.Path(), err)
+		authMetrics.Add("rate_limit_failed_"+limiter.Name, 1)
+		return false, ctx.Status(fiber.StatusServiceUnavailable).SendString("Service temporarily unavailable, retry later.")
+	}
+
+	log.Warnf("Rate limit %s reached by %s on %s:\t%s\n", limiter.Name, key, ctx.Path(), err)
+	authMetrics.Add("rate_limited_"+limiter.Name, 1)
+
+	ctx.Set(fiber.HeaderRetryAfter, retryAfterSeconds(retryErr.RetryAfter))
+	return false, ctx.Status(fiber.StatusTooManyRequests).SendString(err.Error())
+}
+
+// GetMetrics answers with the auth metrics of this instance as JSON.
+func (h *Handler) GetMetrics(ctx *fiber.Ctx) error {
+	ctx.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return ctx.Status(fiber.StatusOK).SendString(authMetrics.String())
+}
@@ -1,64 +1,266 @@
 package handlers
 
 import (
-	"aat-manager/utils"
-	"fmt"
+	"aat-manager/authenticator"
+	"aat-manager/db"
+	"crypto/subtle"
+	"errors"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
 	"log"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// JWTAuthenticationMiddleware authenticates the request with the JWT read from the Authorization
+// bearer header or, if missing, from the auth cookie.
+// API keys, passed in the X-API-Key header or as bearer token, are accepted as an alternative to the JWT,
+// only by the routes declaring a scope, see RequireScope and RefuseAPIKeys.
+// Refused API requests asking for JSON get a 401 JSON body, browsers are redirected to the login pages.
+// Tokens near their expiration are transparently renewed while their session is valid, see renewToken,
+// expired tokens are accepted only to be renewed, within one access token lifetime after their expiration.
 func JWTAuthenticationMiddleware(ctx *fiber.Ctx) error {
-	jwtCookie := ctx.Cookies(JWTTokenCookieName)            // JWT auth token
+	if apiKey := getAPIKey(ctx); apiKey != "" {
+		return apiKeyAuthentication(ctx, apiKey)
+	}
+
+	rawToken := getBearerToken(ctx) // JWT auth token
+	fromCookie := rawToken == ""
+	if fromCookie {
+		rawToken = ctx.Cookies(JWTTokenCookieName)
+	}
 	pendingAuthCookie := ctx.Cookies(PendingAuthCookieName) // Pending auth status
 
-	// Check if JWT is present in cookie:
+	// Check if JWT is present in header or cookie:
 	// Ok -> Proceed with validation
 	// Ko -> Check if auth is pending waiting OTP (pending auth cookie is present)
 	//
 	// Check if auth is pending waiting OTP
 	// OK -> Redirect to OTP check page
 	// KO -> Redirect to login page
-	if jwtCookie == "" {
+	if rawToken == "" {
 		redirectPage := LoginURL
 
 		if pendingAuthCookie != "" {
 			redirectPage = CheckOTPURL
 		}
 
-		redirectPath := getRedirectPath(redirectPage, ctx)
-		ctx.Redirect(redirectPath, fiber.StatusTemporaryRedirect)
-		return nil
+		return unauthenticated(ctx, redirectPage, "Missing authentication token.")
 	}
 
-	jwtSecret := utils.ReadEnvOrPanic(utils.JWTSECRET)
-	if jwtSecret == "" {
-		log.Println("JWT Secret not set")
-		return ctx.Status(fiber.StatusInternalServerError).SendString("Internal server error: JWT Secret not set.")
+	ring, err := authenticator.CurrentKeyRing()
+	if err != nil {
+		log.Printf("JWT key ring not available: %v", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString("Internal server error: JWT key ring not available.")
+	}
+	limits, err := authenticator.CurrentSessionLimits()
+	if err != nil {
+		log.Printf("Session limits not available: %v", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString("Internal server error: session limits not available.")
 	}
 
-	// Parse token after signing method verification
-	token, err := jwt.Parse(jwtCookie, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method")
-		}
-		return []byte(jwtSecret), nil
-	})
+	// Parse token after key and signing method verification
+	token, err := ring.Parse(rawToken)
+
+	// Expired tokens are only accepted to be renewed, if their session is still valid
+	expired := authenticator.IsExpiredOnly(err)
 
 	// If parsing error (apart from blank secret), redirect to login
-	if err != nil {
+	if err != nil && !expired {
 		log.Printf("Failed to parse JWT: %v", err)
-		redirectPath := getRedirectPath(LoginURL, ctx)
-		ctx.Redirect(redirectPath, fiber.StatusTemporaryRedirect)
-		return nil
+		recordAuthEvent(ctx, db.AuthEventJWTRejected, "", err.Error())
+		return unauthenticated(ctx, LoginURL, "Invalid authentication token.")
 	}
-	if !token.Valid {
+	if !token.Valid && !expired {
 		log.Println("Provided JWT is not valid")
-		redirectPath := getRedirectPath(LoginURL, ctx)
-		ctx.Redirect(redirectPath, fiber.StatusTemporaryRedirect)
-		return nil
+		recordAuthEvent(ctx, db.AuthEventJWTRejected, "", "invalid token")
+		return unauthenticated(ctx, LoginURL, "Invalid authentication token.")
+	}
+
+	// Check that the token session is still active, tokens issued before sessions carry no jti
+	claims, _ := token.Claims.(jwt.MapClaims)
+	name, _ := claims["name"].(string)
+	sessionID, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	if expired && !limits.RenewableExpired(time.Unix(int64(exp), 0), time.Now()) {
+		log.Printf("Provided JWT expired beyond the renewal window")
+		recordAuthEvent(ctx, db.AuthEventJWTRejected, "", "token expired beyond the renewal window for user "+name)
+		return unauthenticated(ctx, LoginURL, "Authentication token expired.")
+	}
+	if sessionID == "" {
+		log.Println("Provided JWT has no session")
+		recordAuthEvent(ctx, db.AuthEventJWTRejected, "", "token without session for user "+name)
+		return unauthenticated(ctx, LoginURL, "Invalid authentication token.")
+	}
+	session, err := db.Sessions{}.TouchSession(sessionID, limits.IdleTimeout)
+	if err != nil {
+		log.Printf("Session %s refused: %v", sessionID, err)
+		recordAuthEvent(ctx, db.AuthEventJWTRejected, "", "session expired or revoked for user "+name)
+		return unauthenticated(ctx, LoginURL, "Session expired or revoked.")
 	}
 
+	// Expose authenticated user to next handlers
+	manager, _ := claims["manager"].(bool)
+	role, ok := claims["role"].(string)
+	if !ok {
+		// Tokens issued before roles were introduced only carry the manager flag
+		role = authenticator.RoleCrew
+		if manager {
+			role = authenticator.RoleManager
+		}
+	}
+	// Tokens issued before the users had an ID carry no sub claim
+	sub, _ := claims["sub"].(string)
+	userID, _ := strconv.ParseInt(sub, 10, 64)
+
+	// Renew the token near its expiration, renewed tokens carry the current user profile and role
+	if expired || limits.NeedsRenewal(time.Unix(int64(exp), 0), time.Now()) {
+		user, err := renewToken(ctx, userID, session, fromCookie)
+		switch {
+		case err == nil:
+			userID, name, role = user.ID, user.DisplayName, user.Role
+			if name == "" {
+				name = user.Email
+			}
+			manager = role == authenticator.RoleManager
+		case expired || errors.Is(err, authenticator.ErrBlockedMail):
+			log.Printf("Failed to renew JWT of session %s: %v", sessionID, err)
+			recordAuthEvent(ctx, db.AuthEventJWTRejected, session.UserEmail, "renewal failed: "+err.Error())
+			return unauthenticated(ctx, LoginURL, "Session expired or revoked.")
+		default:
+			// The current token is still valid, renewal is tried again on next request
+			log.Printf("Failed to renew JWT of session %s: %v", sessionID, err)
+		}
+	}
+
+	ctx.Locals(PrincipalLocalsKey, Principal{
+		UserID:    userID,
+		Name:      name,
+		Email:     session.UserEmail,
+		Manager:   manager,
+		Role:      role,
+		SessionID: session.ID,
+	})
+
+	return ctx.Next()
+}
+
+// renewToken signs a new token for the session, with the current profile and role of its user,
+// and sends it back in the auth cookie or, for bearer token clients, in the RenewedTokenHeader.
+// Deactivated users are refused with errUserInactive, blocked addresses with authenticator.ErrBlockedMail.
+func renewToken(ctx *fiber.Ctx, userID int64, session db.Session, fromCookie bool) (db.User, error) {
+	user, err := lookupUser(userID, session.UserEmail)
+	if err != nil {
+		return db.User{}, err
+	}
+	if !user.Active {
+		return db.User{}, errUserInactive
+	}
+	// Addresses blocked by the login policy can't keep their sessions alive
+	if err := authenticator.CheckNotBlocked(mail.Address{Address: user.Email}); err != nil {
+		return db.User{}, err
+	}
+
+	token, err := authenticator.CreateAndSignJWT(user, session)
+	if err != nil {
+		return db.User{}, err
+	}
+
+	if fromCookie {
+		setAuthCookie(ctx, token, session.ExpiresAt)
+	} else {
+		ctx.Set(RenewedTokenHeader, token)
+	}
+
+	return user, nil
+}
+
+// RequireRole returns a middleware that let through only users with the given role.
+// It must be chained after JWTAuthenticationMiddleware, requests without an authenticated user are refused.
+func RequireRole(role string) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		principal, ok := getPrincipal(ctx)
+		if !ok || principal.Role != role {
+			log.Printf("User %q refused, %s role required", principal.Name, role)
+			if wantsJSON(ctx) {
+				return ctx.Status(fiber.StatusForbidden).JSON(ErrorResponse{
+					Error:   "forbidden",
+					Message: role + " role required.",
+				})
+			}
+			return ctx.Status(fiber.StatusForbidden).SendString("Forbidden: " + role + " role required.")
+		}
+
+		return ctx.Next()
+	}
+}
+
+// RequireScope returns a middleware that let through API keys granted any of the given scopes.
+// Users authenticated by JWT are not restricted by scopes, their access is ruled by their role.
+// It must be chained after JWTAuthenticationMiddleware, requests without an authenticated user are refused.
+// Routes declaring a scope must be registered before RefuseAPIKeys, which refuses API keys on the other routes.
+func RequireScope(scopes ...string) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		principal, ok := getPrincipal(ctx)
+		if ok && principal.APIKeyID == 0 {
+			return ctx.Next()
+		}
+		for _, scope := range scopes {
+			if ok && principal.HasScope(scope) {
+				return ctx.Next()
+			}
+		}
+
+		required := strings.Join(scopes, " or ")
+		log.Printf("API key %q refused, %s scope required", principal.Name, required)
+		if wantsJSON(ctx) {
+			return ctx.Status(fiber.StatusForbidden).JSON(ErrorResponse{
+				Error:   "forbidden",
+				Message: required + " scope required.",
+			})
+		}
+		return ctx.Status(fiber.StatusForbidden).SendString("Forbidden: " + required + " scope required.")
+	}
+}
+
+// RefuseAPIKeys refuses the requests authenticated by API key, API keys are denied by default.
+// Fiber runs the handlers in registration order: routes registered before this middleware, on the same group,
+// answer without reaching it, the routes registered after it are closed to API keys.
+// Only routes declaring a scope with RequireScope are to be registered before it.
+func RefuseAPIKeys(ctx *fiber.Ctx) error {
+	principal, ok := getPrincipal(ctx)
+	if ok && principal.APIKeyID == 0 {
+		return ctx.Next()
+	}
+
+	log.Printf("API key %q refused on %s %s, no scope declared", principal.Name, ctx.Method(), ctx.Path())
+	if wantsJSON(ctx) {
+		return ctx.Status(fiber.StatusForbidden).JSON(ErrorResponse{
+			Error:   "forbidden",
+			Message: "API keys not allowed.",
+		})
+	}
+	return ctx.Status(fiber.StatusForbidden).SendString("Forbidden: API keys not allowed.")
+}
+
+// apiKeyAuthentication authenticates the request with an API key and exposes it as principal.
+// Keys are looked up by hash and refused if unknown, revoked or expired.
+func apiKeyAuthentication(ctx *fiber.Ctx, apiKey string) error {
+	key, err := db.APIKeys{}.UseAPIKey(authenticator.HashAPIKey(apiKey))
+	if err != nil {
+		log.Printf("API key refused: %v", err)
+		recordAuthEvent(ctx, db.AuthEventAPIKeyRejected, "", err.Error())
+		return unauthenticated(ctx, LoginURL, "Invalid, expired or revoked API key.")
+	}
+
+	ctx.Locals(PrincipalLocalsKey, Principal{
+		Name:     key.Name,
+		APIKeyID: key.ID,
+		Scopes:   key.Scopes,
+	})
+
 	return ctx.Next()
 }
 
@@ -74,3 +276,63 @@ func AuthPendingMiddleware(ctx *fiber.Ctx) error {
 	// If present, continue to next handler
 	return ctx.Next()
 }
+
+// IssueCSRFToken sets the CSRF cookie when the login pages are served, if not already set.
+// The cookie is readable by the page scripts, which copy it in the csrf hidden field of the forms,
+// see VerifyCSRFToken.
+func IssueCSRFToken(ctx *fiber.Ctx) error {
+	if ctx.Method() != fiber.MethodGet || validCSRFToken(ctx.Cookies(CSRFCookieName)) {
+		return ctx.Next()
+	}
+
+	token, err := newCSRFToken()
+	if err != nil {
+		log.Printf("Failed to generate CSRF token: %v", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString("Internal server error: CSRF token not available.")
+	}
+
+	ctx.Cookie(&fiber.Cookie{
+		Name:        CSRFCookieName,
+		Value:       token,
+		Path:        CSRFCookiePath,
+		Secure:      false,
+		HTTPOnly:    false,
+		SameSite:    "strict",
+		SessionOnly: true,
+	})
+
+	return ctx.Next()
+}
+
+// VerifyCSRFToken refuses form posts whose csrf field, or X-CSRF-Token header, doesn't match the CSRF cookie.
+// A cross site form can't read the cookie, so it can't submit a matching token (double submit cookie).
+func VerifyCSRFToken(ctx *fiber.Ctx) error {
+	cookie := ctx.Cookies(CSRFCookieName)
+	token := ctx.Get(CSRFHeader)
+	if token == "" {
+		token = ctx.FormValue(CSRFFormField)
+	}
+
+	if !validCSRFToken(cookie) || subtle.ConstantTimeCompare([]byte(cookie), []byte(token)) != 1 {
+		log.Printf("CSRF token mismatch on %s", ctx.Path())
+		return ctx.Status(fiber.StatusForbidden).SendString("Forbidden: invalid CSRF token, reload the page and try again.")
+	}
+
+	return ctx.Next()
+}
+
+// unauthenticated refuses a request without valid credentials.
+// API clients asking for JSON get a 401 JSON body, browsers are redirected to the given login page.
+func unauthenticated(ctx *fiber.Ctx, page string, message string) error {
+	if wantsJSON(ctx) {
+		ctx.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+		return ctx.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   "unauthorized",
+			Message: message,
+		})
+	}
+
+	redirectPath := getRedirectPath(page, ctx)
+	ctx.Redirect(redirectPath, fiber.StatusTemporaryRedirect)
+	return nil
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestJWTAuthenticationMiddlewareMissingToken(t *testing.T) {
+	tests := []struct {
+		name         string
+		accept       string
+		pendingAuth  bool
+		wantStatus   int
+		wantLocation string
+	}{
+		{
+			name:       "JSON client",
+			accept:     fiber.MIMEApplicationJSON,
+			wantStatus: fiber.StatusUnauthorized,
+		},
+		{
+			name:         "Browser",
+			accept:       fiber.MIMETextHTML,
+			wantStatus:   fiber.StatusTemporaryRedirect,
+			wantLocation: LoginURL,
+		},
+		{
+			name:         "Browser waiting OTP",
+			accept:       fiber.MIMETextHTML,
+			pendingAuth:  true,
+			wantStatus:   fiber.StatusTemporaryRedirect,
+			wantLocation: CheckOTPURL,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Get("/api/v1/test", JWTAuthenticationMiddleware, func(ctx *fiber.Ctx) error {
+				return ctx.SendStatus(fiber.StatusOK)
+			})
+
+			req := httptest.NewRequest(fiber.MethodGet, "/api/v1/test", nil)
+			req.Header.Set(fiber.HeaderAccept, tt.accept)
+			if tt.pendingAuth {
+				req.Header.Set(fiber.HeaderCookie, PendingAuthCookieName+"=user@test.com")
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("app.Test() error = %v", err)
+			}
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+
+			if tt.wantLocation != "" {
+				if location := resp.Header.Get(fiber.HeaderLocation); !strings.HasPrefix(location, tt.wantLocation) {
+					t.Errorf("location = %q, want prefix %q", location, tt.wantLocation)
+				}
+				return
+			}
+
+			var body ErrorResponse
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("JSON body decode error = %v", err)
+			}
+			if body.Error != "unauthorized" {
+				t.Errorf("error = %q, want unauthorized", body.Error)
+			}
+		})
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	tests := []struct {
+		name       string
+		principal  *Principal
+		wantStatus int
+	}{
+		{
+			name:       "User session",
+			principal:  &Principal{Name: "user", SessionID: "session"},
+			wantStatus: fiber.StatusOK,
+		},
+		{
+			name:       "API key with scope",
+			principal:  &Principal{Name: "kiosk", APIKeyID: 1, Scopes: []string{"issues:read", "issues:write"}},
+			wantStatus: fiber.StatusOK,
+		},
+		{
+			name:       "API key with another accepted scope",
+			principal:  &Principal{Name: "script", APIKeyID: 2, Scopes: []string{"vehicles:read", "issues:admin"}},
+			wantStatus: fiber.StatusOK,
+		},
+		{
+			name:       "API key without scope",
+			principal:  &Principal{Name: "kiosk", APIKeyID: 1, Scopes: []string{"issues:read"}},
+			wantStatus: fiber.StatusForbidden,
+		},
+		{
+			name:       "No principal",
+			principal:  nil,
+			wantStatus: fiber.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Post("/api/v1/test", func(ctx *fiber.Ctx) error {
+				if tt.principal != nil {
+					ctx.Locals(PrincipalLocalsKey, *tt.principal)
+				}
+				return ctx.Next()
+			}, RequireScope("issues:write", "issues:admin"), func(ctx *fiber.Ctx) error {
+				return ctx.SendStatus(fiber.StatusOK)
+			})
+
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, "/api/v1/test", nil))
+			if err != nil {
+				t.Fatalf("app.Test() error = %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRefuseAPIKeys(t *testing.T) {
+	tests := []struct {
+		name       string
+		principal  Principal
+		path       string
+		wantStatus int
+	}{
+		{name: "API key on scoped route", principal: Principal{Name: "kiosk", APIKeyID: 1, Scopes: []string{"issues:read"}}, path: "/api/v1/scoped", wantStatus: fiber.StatusOK},
+		{name: "API key on unscoped route", principal: Principal{Name: "kiosk", APIKeyID: 1, Scopes: []string{"issues:read"}}, path: "/api/v1/me", wantStatus: fiber.StatusForbidden},
+		{name: "User on unscoped route", principal: Principal{Name: "user", SessionID: "session"}, path: "/api/v1/me", wantStatus: fiber.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			protected := app.Group("/api/v1", func(ctx *fiber.Ctx) error {
+				ctx.Locals(PrincipalLocalsKey, tt.principal)
+				return ctx.Next()
+			})
+			protected.Get("/scoped", RequireScope("issues:read"), func(ctx *fiber.Ctx) error {
+				return ctx.SendStatus(fiber.StatusOK)
+			})
+			protected.Use(RefuseAPIKeys)
+			protected.Get("/me", func(ctx *fiber.Ctx) error {
+				return ctx.SendStatus(fiber.StatusOK)
+			})
+
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, tt.path, nil))
+			if err != nil {
+				t.Fatalf("app.Test() error = %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestIssueCSRFToken(t *testing.T) {
+	valid := strings.Repeat("ab", csrfTokenBytes)
+
+	tests := []struct {
+		name      string
+		method    string
+		cookie    string
+		wantIssue bool
+	}{
+		{name: "New visitor", method: fiber.MethodGet, wantIssue: true},
+		{name: "Existing token", method: fiber.MethodGet, cookie: valid, wantIssue: false},
+		{name: "Malformed token", method: fiber.MethodGet, cookie: "forged", wantIssue: true},
+		{name: "Form post", method: fiber.MethodPost, wantIssue: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use("/login", IssueCSRFToken)
+			app.Add(tt.method, "/login/login.html", func(ctx *fiber.Ctx) error {
+				return ctx.SendStatus(fiber.StatusOK)
+			})
+
+			req := httptest.NewRequest(tt.method, "/login/login.html", nil)
+			if tt.cookie != "" {
+				req.Header.Set(fiber.HeaderCookie, CSRFCookieName+"="+tt.cookie)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("app.Test() error = %v", err)
+			}
+
+			var issued string
+			for _, c := range resp.Cookies() {
+				if c.Name == CSRFCookieName {
+					issued = c.Value
+				}
+			}
+			if (issued != "") != tt.wantIssue {
+				t.Fatalf("issued cookie = %q, want issued %v", issued, tt.wantIssue)
+			}
+			if tt.wantIssue && !validCSRFToken(issued) {
+				t.Errorf("issued cookie = %q is not a valid token", issued)
+			}
+		})
+	}
+}
+
+func TestVerifyCSRFToken(t *testing.T) {
+	valid := strings.Repeat("ab", csrfTokenBytes)
+	other := strings.Repeat("cd", csrfTokenBytes)
+
+	tests := []struct {
+		name       string
+		cookie     string
+		field      string
+		header     string
+		wantStatus int
+	}{
+		{name: "Matching field", cookie: valid, field: valid, wantStatus: fiber.StatusOK},
+		{name: "Matching header", cookie: valid, header: valid, wantStatus: fiber.StatusOK},
+		{name: "Missing cookie", field: valid, wantStatus: fiber.StatusForbidden},
+		{name: "Missing field", cookie: valid, wantStatus: fiber.StatusForbidden},
+		{name: "Mismatching field", cookie: valid, field: other, wantStatus: fiber.StatusForbidden},
+		{name: "Blank cookie and field", cookie: "", field: "", wantStatus: fiber.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Post("/login", VerifyCSRFToken, func(ctx *fiber.Ctx) error {
+				return ctx.SendStatus(fiber.StatusOK)
+			})
+
+			form := url.Values{"mail": {"user@test.com"}}
+			if tt.field != "" {
+				form.Set(CSRFFormField, tt.field)
+			}
+			req := httptest.NewRequest(fiber.MethodPost, "/login", strings.NewReader(form.Encode()))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+			if tt.cookie != "" {
+				req.Header.Set(fiber.HeaderCookie, CSRFCookieName+"="+tt.cookie)
+			}
+			if tt.header != "" {
+				req.Header.Set(CSRFHeader, tt.header)
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("app.Test() error = %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"aat-manager/db"
+	"database/sql"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// Me answers with the profile of the current user, read by the user ID of the token.
+// Tokens issued before the users had an ID are looked up by the session e-mail.
+func (h *Handler) Me(ctx *fiber.Ctx) error {
+	principal, ok := getPrincipal(ctx)
+	if !ok {
+		return ctx.Status(fiber.StatusUnauthorized).SendString("Missing authenticated user.")
+	}
+	if principal.SessionID == "" {
+		return ctx.Status(fiber.StatusBadRequest).SendString("API keys have no profile.")
+	}
+
+	user, err := lookupUser(principal.UserID, principal.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ctx.Status(fiber.StatusNotFound).SendString("User not found.")
+	}
+	if err != nil {
+		log.Errorf("Error reading user:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(user)
+}
+
+// lookupUser reads the user by ID or, for tokens issued before the users had an ID, by e-mail.
+func lookupUser(userID int64, email string) (db.User, error) {
+	if userID != 0 {
+		return db.Users{}.GetUser(userID)
+	}
+	return db.Users{}.GetUserByEmail(email)
+}
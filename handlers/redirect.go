@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"aat-manager/utils"
+	"net/url"
+	"strings"
+)
+
+// DefaultRedirect is the landing page used when the requested redirect is missing or refused
+const DefaultRedirect = "/"
+
+// RedirectPolicy decides where the login flow may send the user back to.
+// Only same-origin relative paths and absolute URLs of the allowed origins are accepted,
+// anything else is replaced by the default landing page.
+type RedirectPolicy struct {
+	AllowedOrigins []string // Origins, as scheme://host[:port], accepted as absolute redirect targets
+	Default        string   // Landing page for refused targets
+}
+
+// NewRedirectPolicy builds a redirect policy from a comma separated list of allowed origins.
+// An empty list only allows same-origin relative paths.
+func NewRedirectPolicy(allowlist string) RedirectPolicy {
+	policy := RedirectPolicy{Default: DefaultRedirect}
+	for _, origin := range strings.Split(allowlist, ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			policy.AllowedOrigins = append(policy.AllowedOrigins, strings.ToLower(origin))
+		}
+	}
+	return policy
+}
+
+// currentRedirectPolicy returns the redirect policy configured by the REDIRECTALLOWLIST env variable.
+func currentRedirectPolicy() RedirectPolicy {
+	return NewRedirectPolicy(utils.ReadEnvOrDefault(utils.REDIRECTALLOWLIST, ""))
+}
+
+// Sanitize returns the target if the policy allows it, the default landing page otherwise.
+func (p RedirectPolicy) Sanitize(target string) string {
+	if target == "" || hasUnsafeChars(target) {
+		return p.Default
+	}
+
+	// Browsers read some encoded separators, check the decoded form too
+	decoded, err := url.PathUnescape(target)
+	if err != nil || hasUnsafeChars(decoded) {
+		return p.Default
+	}
+
+	// Same-origin path, "//host" is protocol relative and leaves the origin
+	if strings.HasPrefix(target, "/") {
+		if strings.HasPrefix(target, "//") || strings.HasPrefix(decoded, "//") {
+			return p.Default
+		}
+		u, err := url.Parse(target)
+		if err != nil || u.Scheme != "" || u.Host != "" {
+			return p.Default
+		}
+		return target
+	}
+
+	// Absolute URL of an allowed origin
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return p.Default
+	}
+	origin := strings.ToLower(u.Scheme + "://" + u.Host)
+	for _, allowed := range p.AllowedOrigins {
+		if origin == allowed {
+			return u.String()
+		}
+	}
+
+	return p.Default
+}
+
+// hasUnsafeChars reports whether s contains backslashes, which browsers read as slashes,
+// or control characters, which browsers strip before resolving the URL.
+func hasUnsafeChars(s string) bool {
+	for _, r := range s {
+		if r == '\\' || r < 0x20 || r == 0x7f {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"aat-manager/authenticator"
+	"aat-manager/db"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"net/mail"
+	"strings"
+)
+
+// SessionData is a session as shown to its owner, flagging the one of the current request.
+type SessionData struct {
+	db.Session
+	Current bool `json:"current"`
+}
+
+// Logout revokes the session of the current request and clears the auth cookie.
+func (h *Handler) Logout(ctx *fiber.Ctx) error {
+	principal, ok := getPrincipal(ctx)
+	if !ok {
+		return ctx.Status(fiber.StatusUnauthorized).SendString("Missing authenticated user.")
+	}
+	if principal.SessionID == "" {
+		return ctx.Status(fiber.StatusBadRequest).SendString("API keys have no session.")
+	}
+
+	if err := (db.Sessions{}).RevokeSession(principal.SessionID); err != nil {
+		log.Errorf("Error revoking session:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	ctx.ClearCookie(JWTTokenCookieName)
+	recordAuthEvent(ctx, db.AuthEventLogout, principal.Email, "")
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// ListSessions answers with the active sessions of the current user, one for every logged in device.
+func (h *Handler) ListSessions(ctx *fiber.Ctx) error {
+	principal, ok := getPrincipal(ctx)
+	if !ok {
+		return ctx.Status(fiber.StatusUnauthorized).SendString("Missing authenticated user.")
+	}
+	if principal.SessionID == "" {
+		return ctx.Status(fiber.StatusBadRequest).SendString("API keys have no session.")
+	}
+
+	limits, err := authenticator.CurrentSessionLimits()
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	sessions, err := db.Sessions{}.ListSessions(principal.Email, limits.IdleTimeout)
+	if err != nil {
+		log.Errorf("Error listing sessions:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	res := make([]SessionData, len(sessions))
+	for i, session := range sessions {
+		res[i] = SessionData{
+			Session: session,
+			Current: session.ID == principal.SessionID,
+		}
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(res)
+}
+
+// RevokeUserSessions revokes every session of the user whose e-mail is in the route parameter,
+// logging the user out from all devices. It answers with the number of revoked sessions.
+func (h *Handler) RevokeUserSessions(ctx *fiber.Ctx) error {
+	addr, err := mail.ParseAddress(ctx.Params("email"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	revoked, err := db.Sessions{}.RevokeUserSessions(strings.ToLower(addr.Address))
+	if err != nil {
+		log.Errorf("Error revoking user sessions:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	principal, _ := getPrincipal(ctx)
+	log.Infof("%d sessions of %s revoked by %s", revoked, addr.Address, principal.Name)
+
+	return ctx.Status(fiber.StatusOK).JSON(fiber.Map{"revoked": revoked})
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"aat-manager/authenticator"
+	"aat-manager/db"
+	"database/sql"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// TotpLoginURL is the authenticator app login page
+const TotpLoginURL = "/login/totp.html"
+
+// TotpEnrollment is the answer of EnrollTotp, to be shown as QR code to the authenticator app.
+type TotpEnrollment struct {
+	Secret string `json:"secret"` // Base32 secret, for apps that can't scan the QR code
+	URI    string `json:"uri"`    // otpauth:// URI, to be shown as QR code
+}
+
+// TotpConfirmation is the answer of ConfirmTotp, recovery codes are shown only once.
+type TotpConfirmation struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// EnrollTotp generates a new TOTP secret for the current user and answers with its otpauth:// URI.
+// The secret can't be used to log in until confirmed with ConfirmTotp.
+func (h *Handler) EnrollTotp(ctx *fiber.Ctx) error {
+	principal, ok := getPrincipal(ctx)
+	if !ok || principal.SessionID == "" {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Authenticator app login is only available to users.")
+	}
+
+	secret, err := authenticator.GenerateTotpSecret()
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	err = db.Totp{}.SaveTotpSecret(principal.Email, secret)
+	if errors.Is(err, db.ErrTotpAlreadyEnrolled) {
+		return ctx.Status(fiber.StatusConflict).SendString("Authenticator app already enrolled, disable it before enrolling a new one.")
+	}
+	if err != nil {
+		log.Errorf("Error saving TOTP secret:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(TotpEnrollment{
+		Secret: secret,
+		URI:    authenticator.TotpURI(secret, principal.Email),
+	})
+}
+
+// ConfirmTotp confirms the pending TOTP secret of the current user with a code from the authenticator app
+// and answers with the recovery codes, which replace any previous ones.
+func (h *Handler) ConfirmTotp(ctx *fiber.Ctx) error {
+	principal, ok := getPrincipal(ctx)
+	if !ok || principal.SessionID == "" {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Authenticator app login is only available to users.")
+	}
+
+	formData := new(AuthData)
+	if err := ctx.BodyParser(formData); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	totp, err := db.Totp{}.GetTotp(principal.Email)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && totp.ConfirmedAt != nil) {
+		return ctx.Status(fiber.StatusConflict).SendString("No pending authenticator app enrollment.")
+	}
+	if err != nil {
+		log.Errorf("Error reading TOTP secret:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	counter, err := authenticator.ValidateTotp(totp.Secret, formData.Code, time.Now())
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	codes, hashes, err := authenticator.GenerateRecoveryCodes()
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	err = db.Totp{}.ConfirmTotp(principal.Email, counter, hashes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ctx.Status(fiber.StatusConflict).SendString("No pending authenticator app enrollment.")
+	}
+	if err != nil {
+		log.Errorf("Error confirming TOTP:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	recordAuthEvent(ctx, db.AuthEventTotpEnrolled, principal.Email, "")
+
+	return ctx.Status(fiber.StatusOK).JSON(TotpConfirmation{RecoveryCodes: codes})
+}
+
+// DisableTotp removes the authenticator app and the recovery codes of the current user.
+func (h *Handler) DisableTotp(ctx *fiber.Ctx) error {
+	principal, ok := getPrincipal(ctx)
+	if !ok || principal.SessionID == "" {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Authenticator app login is only available to users.")
+	}
+
+	if err := (db.Totp{}).DeleteTotp(principal.Email); err != nil {
+		log.Errorf("Error deleting TOTP:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	recordAuthEvent(ctx, db.AuthEventTotpDisabled, principal.Email, "")
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// LoginWithTotp logs in the user with a code from the authenticator app or a recovery code,
+// as an alternative to the e-mailed OTP. Attempts are rate limited per mailbox.
+// On a wrong code the user is sent back to the login page, every code is accepted only once.
+// Logins are refused without the rate limiter, the 6 digits codes could be guessed otherwise.
+func (h *Handler) LoginWithTotp(ctx *fiber.Ctx) error {
+	if h.TotpLimiter == nil {
+		return ctx.Status(fiber.StatusNotImplemented).SendString("This service is not enabled.")
+	}
+
+	formData := new(AuthData)
+	if err := ctx.BodyParser(formData); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	addr, err := mail.ParseAddress(formData.Mail)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	if _, err := authenticator.CheckMailDomain(*addr); err != nil {
+		event := mailRefusedEvent(err)
+		if event == "" {
+			log.Errorf("Error checking mail policy:\t%s\n", err)
+			return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+		recordAuthEvent(ctx, event, addr.Address, "totp")
+		return ctx.Status(fiber.StatusForbidden).SendString(err.Error())
+	}
+
+	email := strings.ToLower(addr.Address)
+	if allowed, err := allowRequest(ctx, h.TotpLimiter, email); !allowed {
+		return err
+	}
+
+	// Secrets are enrolled under the user e-mail, see completeLogin
+	valid, reason, err := checkTotpLogin(email, formData.Code)
+	if err != nil {
+		log.Errorf("Error checking TOTP:\t%s\n", err)
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	if !valid {
+		log.Warnf("TOTP login refused for %s:\t%s\n", addr.Address, reason)
+		recordAuthEvent(ctx, db.AuthEventTotpFailed, addr.Address, reason)
+		ctx.Redirect(getChainableRedirectPath(TotpLoginURL, ctx), fiber.StatusSeeOther)
+		return nil
+	}
+
+	recordAuthEvent(ctx, db.AuthEventTotpSucceeded, addr.Address, reason)
+	return h.completeLogin(ctx, addr, ctx.Query("redirect"))
+}
+
+// checkTotpLogin checks an authenticator app or recovery code of the user and consumes it.
+// It reports whether the code is valid, with the refusal reason or the kind of accepted code.
+func checkTotpLogin(email string, code string) (bool, string, error) {
+	if !authenticator.IsTotpCode(code) {
+		used, err := db.Totp{}.UseRecoveryCode(email, authenticator.HashRecoveryCode(code))
+		if err != nil || !used {
+			return false, "invalid recovery code", err
+		}
+		return true, "recovery code", nil
+	}
+
+	totp, err := db.Totp{}.GetTotp(email)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && totp.ConfirmedAt == nil) {
+		return false, "not enrolled", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+
+	counter, err := authenticator.ValidateTotp(totp.Secret, code, time.Now())
+	if err != nil {
+		return false, err.Error(), nil
+	}
+	used, err := db.Totp{}.UseTotpCounter(email, counter)
+	if err != nil || !used {
+		return false, "code already used", err
+	}
+
+	return true, "authenticator app", nil
+}
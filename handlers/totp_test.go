@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoginWithTotpWithoutRateLimit(t *testing.T) {
+	var h Handler
+	app := fiber.New()
+	app.Post("/login/totp", h.LoginWithTotp)
+
+	req := httptest.NewRequest(fiber.MethodPost, "/login/totp", strings.NewReader("mail=user@test.com&code=123456"))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("app.Test() error = %v", err)
+	}
+
+	// Codes must not be checked without the rate limiter
+	if resp.StatusCode != fiber.StatusNotImplemented {
+		t.Errorf("status = %d, want %d", resp.StatusCode, fiber.StatusNotImplemented)
+	}
+}
@@ -0,0 +1,137 @@
+package issues
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Error definition
+var (
+	ErrInvalidSubject     = errors.New("invalid vehicle or station identifier")
+	ErrInvalidCategory    = errors.New("invalid category")
+	ErrInvalidSeverity    = errors.New("invalid severity")
+	ErrInvalidStatus      = errors.New("invalid status")
+	ErrBlankDescription   = errors.New("description is blank")
+	ErrDescriptionTooLong = errors.New("description is too long")
+	ErrMissingReporter    = errors.New("missing reporter")
+	ErrMalformedRow       = errors.New("malformed sheet row")
+)
+
+// Issue severities, shared by vehicle and station reports
+const (
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+)
+
+// Issue statuses, shared by vehicle and station reports
+const (
+	StatusOpen   = "open"
+	StatusClosed = "closed"
+)
+
+// Maximum description length in characters
+const maxDescriptionLength = 1000
+
+// Column holding the status in both vehicle and station issue rows
+const statusColumn = "H"
+
+var severities = map[string]bool{
+	SeverityLow:      true,
+	SeverityMedium:   true,
+	SeverityHigh:     true,
+	SeverityCritical: true,
+}
+
+var statuses = map[string]bool{
+	StatusOpen:   true,
+	StatusClosed: true,
+}
+
+// Vehicle and station identifiers are short codes like "MSA1" or "bra-02"
+var subjectRx = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// ValidStatus reports whether s is a known issue status.
+func ValidStatus(s string) bool {
+	return statuses[s]
+}
+
+// StatusRange returns the A1 range of the status cell of the issue stored at the given sheet row.
+// Rows are numbered as in the sheet, the first one being the header.
+func StatusRange(tab string, row int) string {
+	return fmt.Sprintf("%s!%s%d:%s%d", tab, statusColumn, row, statusColumn, row)
+}
+
+// StatusValues returns the values to write in a status range to set the issue status.
+func StatusValues(status string) [][]interface{} {
+	return [][]interface{}{{textCell(status)}}
+}
+
+// validateReport checks the fields common to every issue report.
+// The category is checked against the allowed categories for the report kind.
+func validateReport(subject string, category string, severity string, description string, reporter string, categories map[string]bool) error {
+	if !subjectRx.MatchString(subject) {
+		return ErrInvalidSubject
+	}
+	if !categories[category] {
+		return ErrInvalidCategory
+	}
+	if !severities[severity] {
+		return ErrInvalidSeverity
+	}
+	if strings.TrimSpace(description) == "" {
+		return ErrBlankDescription
+	}
+	if len([]rune(description)) > maxDescriptionLength {
+		return ErrDescriptionTooLong
+	}
+	if reporter == "" {
+		return ErrMissingReporter
+	}
+	return nil
+}
+
+// newIssueID generates a random 16 characters hex identifier for an issue.
+func newIssueID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// Fall back on timestamp, still unique enough for a sheet row
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// textCell forces the sheet to store the value as plain text.
+// Values are appended as USER_ENTERED, so without the leading apostrophe a description
+// starting with "=" would be evaluated as a formula and timestamps would be reformatted.
+func textCell(s string) string {
+	return "'" + s
+}
+
+// cellString returns the cell at index i of the row as a string.
+// It returns ErrMalformedRow if the row is too short.
+func cellString(row []interface{}, i int) (string, error) {
+	if i >= len(row) {
+		return "", ErrMalformedRow
+	}
+	return fmt.Sprintf("%v", row[i]), nil
+}
+
+// cellStrings converts the first n cells of the row to strings.
+func cellStrings(row []interface{}, n int) ([]string, error) {
+	res := make([]string, n)
+	for i := range res {
+		s, err := cellString(row, i)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = s
+	}
+	return res, nil
+}
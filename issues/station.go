@@ -0,0 +1,118 @@
+package issues
+
+import (
+	"time"
+)
+
+const (
+	StationIssueTab     = "Issues"     // Tab of the station sheet holding the issue reports
+	StationIssueRange   = "Issues!A:H" // Range used to append new station issues
+	StationIssueColumns = 8            // Number of columns of a station issue row
+)
+
+// Station issue categories
+const (
+	StationCategoryDoors      = "doors"
+	StationCategoryHeating    = "heating"
+	StationCategorySupplies   = "supplies"
+	StationCategoryElectrical = "electrical"
+	StationCategoryPlumbing   = "plumbing"
+	StationCategoryIT         = "it"
+	StationCategoryCleaning   = "cleaning"
+	StationCategoryOther      = "other"
+)
+
+var stationCategories = map[string]bool{
+	StationCategoryDoors:      true,
+	StationCategoryHeating:    true,
+	StationCategorySupplies:   true,
+	StationCategoryElectrical: true,
+	StationCategoryPlumbing:   true,
+	StationCategoryIT:         true,
+	StationCategoryCleaning:   true,
+	StationCategoryOther:      true,
+}
+
+// StationIssue represents an issue reported on a station.
+// Every issue is stored as a row in the station sheet, columns follow the struct field order.
+type StationIssue struct {
+	ID          string    `json:"id"`
+	Station     string    `json:"station"`
+	Category    string    `json:"category"`
+	Severity    string    `json:"severity"`
+	Description string    `json:"description"`
+	Reporter    string    `json:"reporter"`
+	Timestamp   time.Time `json:"timestamp"`
+	Status      string    `json:"status"`
+}
+
+// NewStationIssue creates a new open issue for the given station and validates it.
+// The issue ID and the timestamp are generated.
+func NewStationIssue(station string, category string, severity string, description string, reporter string) (StationIssue, error) {
+	si := StationIssue{
+		ID:          newIssueID(),
+		Station:     station,
+		Category:    category,
+		Severity:    severity,
+		Description: description,
+		Reporter:    reporter,
+		Timestamp:   time.Now().UTC().Truncate(time.Second),
+		Status:      StatusOpen,
+	}
+
+	if err := si.Validate(); err != nil {
+		return StationIssue{}, err
+	}
+
+	return si, nil
+}
+
+// Validate checks that all the issue fields are set and hold allowed values.
+func (si StationIssue) Validate() error {
+	if err := validateReport(si.Station, si.Category, si.Severity, si.Description, si.Reporter, stationCategories); err != nil {
+		return err
+	}
+	if !ValidStatus(si.Status) {
+		return ErrInvalidStatus
+	}
+	return nil
+}
+
+// Row converts the issue to a sheet row.
+func (si StationIssue) Row() []interface{} {
+	return []interface{}{
+		textCell(si.ID),
+		textCell(si.Station),
+		textCell(si.Category),
+		textCell(si.Severity),
+		textCell(si.Description),
+		textCell(si.Reporter),
+		textCell(si.Timestamp.Format(time.RFC3339)),
+		textCell(si.Status),
+	}
+}
+
+// StationIssueFromRow parses a sheet row read from the station sheet.
+// It returns ErrMalformedRow if the row is too short or the timestamp can't be parsed.
+func StationIssueFromRow(row []interface{}) (StationIssue, error) {
+	cells, err := cellStrings(row, StationIssueColumns)
+	if err != nil {
+		return StationIssue{}, err
+	}
+
+	ts, err := time.Parse(time.RFC3339, cells[6])
+	if err != nil {
+		return StationIssue{}, ErrMalformedRow
+	}
+
+	return StationIssue{
+		ID:          cells[0],
+		Station:     cells[1],
+		Category:    cells[2],
+		Severity:    cells[3],
+		Description: cells[4],
+		Reporter:    cells[5],
+		Timestamp:   ts,
+		Status:      cells[7],
+	}, nil
+}
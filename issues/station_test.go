@@ -0,0 +1,100 @@
+package issues
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewStationIssue(t *testing.T) {
+	tests := []struct {
+		name        string
+		station     string
+		category    string
+		severity    string
+		description string
+		reporter    string
+		wantErr     error
+	}{
+		{
+			name:        "Valid issue",
+			station:     "bra-02",
+			category:    StationCategoryHeating,
+			severity:    SeverityMedium,
+			description: "Heating is off in the crew room",
+			reporter:    "mario.rossi",
+			wantErr:     nil,
+		},
+		{
+			name:        "Blank station",
+			station:     "",
+			category:    StationCategoryDoors,
+			severity:    SeverityLow,
+			description: "Garage door is stuck",
+			reporter:    "mario.rossi",
+			wantErr:     ErrInvalidSubject,
+		},
+		{
+			name:        "Vehicle category on station",
+			station:     "bra-02",
+			category:    "tyres",
+			severity:    SeverityLow,
+			description: "Spare tyres missing",
+			reporter:    "mario.rossi",
+			wantErr:     ErrInvalidCategory,
+		},
+		{
+			name:        "Invalid severity",
+			station:     "bra-02",
+			category:    StationCategorySupplies,
+			severity:    "",
+			description: "Gloves are missing",
+			reporter:    "mario.rossi",
+			wantErr:     ErrInvalidSeverity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			si, err := NewStationIssue(tt.station, tt.category, tt.severity, tt.description, tt.reporter)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewStationIssue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && si.Status != StatusOpen {
+				t.Errorf("NewStationIssue() status = %v, want %v", si.Status, StatusOpen)
+			}
+		})
+	}
+}
+
+func TestStationIssueFromRow(t *testing.T) {
+	tests := []struct {
+		name    string
+		row     []interface{}
+		want    string
+		wantErr error
+	}{
+		{
+			name:    "Closed issue",
+			row:     []interface{}{"id", "bra-02", "doors", "low", "desc", "user", "2024-01-02T10:00:00Z", "closed"},
+			want:    StatusClosed,
+			wantErr: nil,
+		},
+		{
+			name:    "Missing status column",
+			row:     []interface{}{"id", "bra-02", "doors", "low", "desc", "user", "2024-01-02T10:00:00Z"},
+			wantErr: ErrMalformedRow,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := StationIssueFromRow(tt.row)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("StationIssueFromRow() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Status != tt.want {
+				t.Errorf("StationIssueFromRow() status = %v, want %v", got.Status, tt.want)
+			}
+		})
+	}
+}
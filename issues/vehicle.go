@@ -0,0 +1,116 @@
+package issues
+
+import (
+	"time"
+)
+
+const (
+	VehicleIssueTab     = "Issues"     // Tab of the vehicle sheet holding the issue reports
+	VehicleIssueRange   = "Issues!A:H" // Range used to append new vehicle issues
+	VehicleIssueColumns = 8            // Number of columns of a vehicle issue row
+)
+
+// Vehicle issue categories
+const (
+	VehicleCategoryEngine     = "engine"
+	VehicleCategoryTyres      = "tyres"
+	VehicleCategoryBodywork   = "bodywork"
+	VehicleCategoryElectrical = "electrical"
+	VehicleCategoryEquipment  = "equipment"
+	VehicleCategoryCleaning   = "cleaning"
+	VehicleCategoryOther      = "other"
+)
+
+var vehicleCategories = map[string]bool{
+	VehicleCategoryEngine:     true,
+	VehicleCategoryTyres:      true,
+	VehicleCategoryBodywork:   true,
+	VehicleCategoryElectrical: true,
+	VehicleCategoryEquipment:  true,
+	VehicleCategoryCleaning:   true,
+	VehicleCategoryOther:      true,
+}
+
+// VehicleIssue represents an issue reported on a vehicle.
+// Every issue is stored as a row in the vehicle sheet, columns follow the struct field order.
+type VehicleIssue struct {
+	ID          string    `json:"id"`
+	Vehicle     string    `json:"vehicle"`
+	Category    string    `json:"category"`
+	Severity    string    `json:"severity"`
+	Description string    `json:"description"`
+	Reporter    string    `json:"reporter"`
+	Timestamp   time.Time `json:"timestamp"`
+	Status      string    `json:"status"`
+}
+
+// NewVehicleIssue creates a new open issue for the given vehicle and validates it.
+// The issue ID and the timestamp are generated.
+func NewVehicleIssue(vehicle string, category string, severity string, description string, reporter string) (VehicleIssue, error) {
+	vi := VehicleIssue{
+		ID:          newIssueID(),
+		Vehicle:     vehicle,
+		Category:    category,
+		Severity:    severity,
+		Description: description,
+		Reporter:    reporter,
+		Timestamp:   time.Now().UTC().Truncate(time.Second),
+		Status:      StatusOpen,
+	}
+
+	if err := vi.Validate(); err != nil {
+		return VehicleIssue{}, err
+	}
+
+	return vi, nil
+}
+
+// Validate checks that all the issue fields are set and hold allowed values.
+func (vi VehicleIssue) Validate() error {
+	if err := validateReport(vi.Vehicle, vi.Category, vi.Severity, vi.Description, vi.Reporter, vehicleCategories); err != nil {
+		return err
+	}
+	if !ValidStatus(vi.Status) {
+		return ErrInvalidStatus
+	}
+	return nil
+}
+
+// Row converts the issue to a sheet row.
+func (vi VehicleIssue) Row() []interface{} {
+	return []interface{}{
+		textCell(vi.ID),
+		textCell(vi.Vehicle),
+		textCell(vi.Category),
+		textCell(vi.Severity),
+		textCell(vi.Description),
+		textCell(vi.Reporter),
+		textCell(vi.Timestamp.Format(time.RFC3339)),
+		textCell(vi.Status),
+	}
+}
+
+// VehicleIssueFromRow parses a sheet row read from the vehicle sheet.
+// It returns ErrMalformedRow if the row is too short or the timestamp can't be parsed.
+func VehicleIssueFromRow(row []interface{}) (VehicleIssue, error) {
+	cells, err := cellStrings(row, VehicleIssueColumns)
+	if err != nil {
+		return VehicleIssue{}, err
+	}
+
+	ts, err := time.Parse(time.RFC3339, cells[6])
+	if err != nil {
+		return VehicleIssue{}, ErrMalformedRow
+	}
+
+	return VehicleIssue{
+		ID:          cells[0],
+		Vehicle:     cells[1],
+		Category:    cells[2],
+		Severity:    cells[3],
+		Description: cells[4],
+		Reporter:    cells[5],
+		Timestamp:   ts,
+		Status:      cells[7],
+	}, nil
+}
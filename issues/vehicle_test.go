@@ -0,0 +1,185 @@
+package issues
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewVehicleIssue(t *testing.T) {
+	tests := []struct {
+		name        string
+		vehicle     string
+		category    string
+		severity    string
+		description string
+		reporter    string
+		wantErr     error
+	}{
+		{
+			name:        "Valid issue",
+			vehicle:     "MSA1",
+			category:    VehicleCategoryTyres,
+			severity:    SeverityHigh,
+			description: "Front left tyre is flat",
+			reporter:    "mario.rossi",
+			wantErr:     nil,
+		},
+		{
+			name:        "Invalid vehicle",
+			vehicle:     "MSA 1",
+			category:    VehicleCategoryTyres,
+			severity:    SeverityHigh,
+			description: "Front left tyre is flat",
+			reporter:    "mario.rossi",
+			wantErr:     ErrInvalidSubject,
+		},
+		{
+			name:        "Station category on vehicle",
+			vehicle:     "MSA1",
+			category:    "heating",
+			severity:    SeverityHigh,
+			description: "Cold",
+			reporter:    "mario.rossi",
+			wantErr:     ErrInvalidCategory,
+		},
+		{
+			name:        "Invalid severity",
+			vehicle:     "MSA1",
+			category:    VehicleCategoryEngine,
+			severity:    "urgent",
+			description: "Strange noise",
+			reporter:    "mario.rossi",
+			wantErr:     ErrInvalidSeverity,
+		},
+		{
+			name:        "Blank description",
+			vehicle:     "MSA1",
+			category:    VehicleCategoryEngine,
+			severity:    SeverityLow,
+			description: "   ",
+			reporter:    "mario.rossi",
+			wantErr:     ErrBlankDescription,
+		},
+		{
+			name:        "Description too long",
+			vehicle:     "MSA1",
+			category:    VehicleCategoryEngine,
+			severity:    SeverityLow,
+			description: strings.Repeat("a", maxDescriptionLength+1),
+			reporter:    "mario.rossi",
+			wantErr:     ErrDescriptionTooLong,
+		},
+		{
+			name:        "Missing reporter",
+			vehicle:     "MSA1",
+			category:    VehicleCategoryEngine,
+			severity:    SeverityLow,
+			description: "Strange noise",
+			reporter:    "",
+			wantErr:     ErrMissingReporter,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vi, err := NewVehicleIssue(tt.vehicle, tt.category, tt.severity, tt.description, tt.reporter)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewVehicleIssue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if vi.ID == "" || vi.Timestamp.IsZero() || vi.Status != StatusOpen {
+				t.Errorf("NewVehicleIssue() = %+v, want generated ID, timestamp and open status", vi)
+			}
+		})
+	}
+}
+
+func TestVehicleIssueRowRoundTrip(t *testing.T) {
+	vi, err := NewVehicleIssue("MSA1", VehicleCategoryBodywork, SeverityMedium, "=HYPERLINK(\"x\")", "mario.rossi")
+	if err != nil {
+		t.Fatalf("NewVehicleIssue() error = %v", err)
+	}
+
+	row := vi.Row()
+	if len(row) != VehicleIssueColumns {
+		t.Fatalf("Row() has %d columns, want %d", len(row), VehicleIssueColumns)
+	}
+
+	// The sheet strips the leading apostrophe when reading the values back
+	read := make([]interface{}, len(row))
+	for i, c := range row {
+		s := c.(string)
+		if !strings.HasPrefix(s, "'") {
+			t.Fatalf("Row() cell %d = %q is not forced to text", i, s)
+		}
+		read[i] = strings.TrimPrefix(s, "'")
+	}
+
+	got, err := VehicleIssueFromRow(read)
+	if err != nil {
+		t.Fatalf("VehicleIssueFromRow() error = %v", err)
+	}
+	if got != vi {
+		t.Errorf("VehicleIssueFromRow() = %+v, want %+v", got, vi)
+	}
+}
+
+func TestVehicleIssueFromRow(t *testing.T) {
+	tests := []struct {
+		name    string
+		row     []interface{}
+		wantErr error
+	}{
+		{
+			name:    "Valid row",
+			row:     []interface{}{"id", "MSA1", "engine", "low", "desc", "user", "2024-01-02T10:00:00Z", "open"},
+			wantErr: nil,
+		},
+		{
+			name:    "Short row",
+			row:     []interface{}{"id", "MSA1", "engine"},
+			wantErr: ErrMalformedRow,
+		},
+		{
+			name:    "Bad timestamp",
+			row:     []interface{}{"id", "MSA1", "engine", "low", "desc", "user", "yesterday", "open"},
+			wantErr: ErrMalformedRow,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VehicleIssueFromRow(tt.row)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VehicleIssueFromRow() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !got.Timestamp.Equal(time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)) {
+				t.Errorf("VehicleIssueFromRow() timestamp = %v", got.Timestamp)
+			}
+		})
+	}
+}
+
+func TestStatusRange(t *testing.T) {
+	tests := []struct {
+		name string
+		tab  string
+		row  int
+		want string
+	}{
+		{name: "First data row", tab: VehicleIssueTab, row: 2, want: "Issues!H2:H2"},
+		{name: "Far row", tab: "Other", row: 1234, want: "Other!H1234:H1234"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StatusRange(tt.tab, tt.row); got != tt.want {
+				t.Errorf("StatusRange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
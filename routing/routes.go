@@ -1,6 +1,7 @@
 package routing
 
 import (
+	"aat-manager/authenticator"
 	"aat-manager/handlers"
 	"github.com/gofiber/fiber/v2"
 )
@@ -12,21 +13,75 @@ func SetupRoutes(app *fiber.App, handler handlers.Handler) {
 	})
 
 	// OAuth auth route
-	app.Get("/oauth_callback", handlers.OauthCallback)
+	app.Get("/oauth_callback", handler.OauthCallback)
+
+	// Public keys verifying the issued JWTs
+	app.Get("/.well-known/jwks.json", handlers.JWKS)
 
 	// Login routes
 	login := app.Group("/login")
 	login.Get("/", func(ctx *fiber.Ctx) error {
 		return ctx.Status(fiber.StatusOK).SendString("Login root")
 	})
-	login.Post("/", handler.GetMailAndSendBackOtp)
-	login.Post("/checkotp", handler.GetOtpAndAuthenticate)
+	login.Post("/", handlers.VerifyCSRFToken, handler.GetMailAndSendBackOtp)
+	login.Post("/checkotp", handlers.VerifyCSRFToken, handler.GetOtpAndAuthenticate)
+	login.Post("/totp", handlers.VerifyCSRFToken, handler.LoginWithTotp)
+	login.Get("/magic", handler.MagicLogin)
+	login.Get("/google", handler.GoogleLogin)
+	login.Get("/google/callback", handler.GoogleCallback)
 
 	//Api routes
 	api := app.Group("/api/v1")
 
 	protected := api.Group("/", handlers.JWTAuthenticationMiddleware)
+
+	// Routes open to API keys, each one declares the scopes it accepts
+	protected.Get("/vehicles/:id/issues", handlers.RequireScope(authenticator.ScopeIssuesRead, authenticator.ScopeVehiclesRead), handler.GetVehicleIssues)
+	protected.Post("/vehicles/:id/issues", handlers.RequireScope(authenticator.ScopeIssuesWrite), handler.PostVehicleIssue)
+	protected.Get("/stations/:id/issues", handlers.RequireScope(authenticator.ScopeIssuesRead), handler.GetStationIssues)
+	protected.Post("/stations/:id/issues", handlers.RequireScope(authenticator.ScopeIssuesWrite), handler.PostStationIssue)
+
+	// API keys are refused by the routes registered below
+	protected.Use(handlers.RefuseAPIKeys)
+
 	protected.Get("/", func(ctx *fiber.Ctx) error {
 		return ctx.Status(fiber.StatusOK).SendString("Protected root")
 	})
+
+	// Profile of the current user
+	protected.Get("/me", handler.Me)
+
+	// Sessions of the current user
+	protected.Post("/logout", handler.Logout)
+	protected.Get("/sessions", handler.ListSessions)
+
+	// Authenticator app login of the current user
+	protected.Post("/totp/enroll", handler.EnrollTotp)
+	protected.Post("/totp/confirm", handler.ConfirmTotp)
+	protected.Delete("/totp", handler.DisableTotp)
+
+	// Vehicle and station issues closing
+	vehicles := protected.Group("/vehicles")
+	vehicles.Post("/:id/issues/:issue/close", handlers.RequireRole(authenticator.RoleManager), handler.CloseVehicleIssue)
+	stations := protected.Group("/stations")
+	stations.Post("/:id/issues/:issue/close", handlers.RequireRole(authenticator.RoleManager), handler.CloseStationIssue)
+
+	// Manager only administration
+	admin := protected.Group("/admin", handlers.RequireRole(authenticator.RoleManager))
+	admin.Get("/users", handler.ListUsers)
+	admin.Patch("/users/:email", handler.UpdateUser)
+	admin.Post("/users/:email/promote", handler.PromoteUser)
+	admin.Post("/users/:email/demote", handler.DemoteUser)
+	admin.Delete("/users/:email/sessions", handler.RevokeUserSessions)
+	admin.Get("/apikeys", handler.ListAPIKeys)
+	admin.Post("/apikeys", handler.CreateAPIKey)
+	admin.Delete("/apikeys/:id", handler.RevokeAPIKey)
+	admin.Get("/login-policy", handler.GetLoginPolicy)
+	admin.Post("/login-policy", handler.AddLoginPolicyEntry)
+	admin.Delete("/login-policy/:kind/:value", handler.DeleteLoginPolicyEntry)
+	admin.Get("/google/status", handler.GetGoogleStatus)
+	admin.Get("/google/token", handler.GetGoogleToken)
+	admin.Delete("/google/token", handler.DeleteGoogleToken)
+	admin.Get("/auth-events", handler.GetAuthEvents)
+	admin.Get("/metrics", handler.GetMetrics)
 }
@@ -1,24 +1,55 @@
 package main
 
 import (
+	"aat-manager/authenticator"
 	"aat-manager/db"
 	"aat-manager/gsuite"
 	"aat-manager/handlers"
 	"aat-manager/routing"
 	"aat-manager/utils"
+	"context"
+	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"log"
+	"os"
 	"strconv"
+	"strings"
 )
 
 func main() {
+	// Run command line subcommands, like migrate, instead of the server
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
 	// Check that all env variable are set
 	utils.CheckEnvCompliance()
 
-	// Initialize in memory db for OTP storage
-	memoryDb := db.NewDB()
+	// Apply the pending schema migrations, unless they are run by the migrate subcommand
+	migrateOnStart, err := strconv.ParseBool(utils.ReadEnvOrDefault(utils.MIGRATEONSTART, "true"))
+	if err != nil {
+		log.Fatalf("Error reading %s:\t%s\n", utils.MIGRATEONSTART, err)
+	}
+	if migrateOnStart {
+		if _, err := (db.Migrations{}).MigrateUp(context.Background()); err != nil {
+			log.Fatalf("Error migrating database schema:\t%s\n", err)
+		}
+	}
+
+	// Initialize OTP store according to env, in memory by default
+	otpStore, err := db.NewOtpStore(utils.ReadEnvOrDefault(utils.OTPSTORE, db.OtpStoreMemory))
+	if err != nil {
+		log.Fatalf("Error initializing OTP store:\t%s\n", err)
+	}
+	defer otpStore.Close()
+
+	// Authorized domains besides AUTHDOMAIN, allowed and blocked addresses are managed in Postgres
+	authenticator.UseMailPolicyStore(db.LoginPolicies{})
 
 	// Create postgres db conn pool and ping DB
 
@@ -31,33 +62,96 @@ func main() {
 	var handler handlers.Handler
 	// Enable Google service according to env flag
 	if googleServiceEnable {
-		mailService, err := gsuite.MailService{}.New()
+		config, err := gsuite.APIConfig()
+		if err != nil {
+			log.Fatalf("Unable to parse client secret file to config:\t%s\n", err)
+		}
+
+		// Services are bound once the integration is authorized, in web auth mode through the OAuth callback,
+		// whose state is shared by the instances through the OTP store
+		gsuite.UseStateStore(otpStore)
+		google := gsuite.NewManager(config)
+		google.Start()
+
+		mailService, err := gsuite.MailService{}.New(google)
 		if err != nil {
 			log.Fatalf("Error initializing mail service:\t%s\n", err)
 		}
 
+		// Sheet service is lazy initialized on first use
+		sheetService := gsuite.NewSheetService(google)
+
 		// Create handler to setup routes
-		handler.InitializeService(memoryDb, mailService, true)
+		handler.InitializeService(otpStore, google, mailService, sheetService, true)
 
 	} else {
-		handler.InitializeService(nil, gsuite.MailService{}, false)
+		handler.InitializeService(nil, nil, gsuite.MailService{}, nil, false)
+	}
+
+	// OTP requests and authenticator app logins rate limits share the OTP store, authenticator app logins
+	// are available without the Google service
+	if err := handler.InitializeRateLimits(otpStore); err != nil {
+		log.Fatalf("Error initializing rate limits:\t%s\n", err)
 	}
 
-	// Fiber app definition
-	app := fiber.New()
+	// Fiber app definition, reading the client IP from the load balancer header if any
+	appConfig, err := proxyConfig()
+	if err != nil {
+		log.Fatalf("Error reading proxy config:\t%s\n", err)
+	}
+	app := fiber.New(appConfig)
 
 	// Google verify file
 	app.Static("/", "./public/googleverify")
 
-	// Login files
+	// Login files, served with the CSRF cookie checked by the login form posts
+	app.Use("/login", handlers.IssueCSRFToken)
 	app.Static("/login", "./public/login")
 
 	// Apply middleware to the app
 	app.Use(logger.New())
-	app.Use(cors.New())
+
+	// Cross origin requests are refused unless their origin is explicitly allowed
+	if origins := utils.ReadEnvOrDefault(utils.CORSALLOWORIGINS, ""); origins != "" {
+		app.Use(cors.New(cors.Config{
+			AllowOrigins:     origins,
+			AllowHeaders:     "Origin, Content-Type, Accept, Authorization, " + handlers.APIKeyHeader + ", " + handlers.CSRFHeader,
+			ExposeHeaders:    handlers.RenewedTokenHeader,
+			AllowCredentials: origins != "*",
+		}))
+	}
 
 	routing.SetupRoutes(app, handler)
 
 	port := utils.ReadEnvOrPanic(utils.PORT)
 	app.Listen(":" + port)
 }
+
+// proxyConfig returns the app config reading the client IP from the PROXYHEADER header, only for requests
+// coming from the TRUSTEDPROXIES load balancers. Behind a load balancer the remote address is the balancer one,
+// so the per IP rate limits would be shared by every client.
+// Without PROXYHEADER the remote address is used.
+func proxyConfig() (fiber.Config, error) {
+	header := utils.ReadEnvOrDefault(utils.PROXYHEADER, "")
+	if header == "" {
+		return fiber.Config{}, nil
+	}
+
+	var proxies []string
+	for _, proxy := range strings.Split(utils.ReadEnvOrDefault(utils.TRUSTEDPROXIES, ""), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	// Any client could set the header otherwise
+	if len(proxies) == 0 {
+		return fiber.Config{}, fmt.Errorf("%s is required with %s", utils.TRUSTEDPROXIES, utils.PROXYHEADER)
+	}
+
+	return fiber.Config{
+		ProxyHeader:             header,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          proxies,
+		EnableIPValidation:      true,
+	}, nil
+}
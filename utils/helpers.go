@@ -4,6 +4,7 @@ import (
 	"github.com/joho/godotenv"
 	"log"
 	"os"
+	"sync"
 )
 
 const (
@@ -11,7 +12,7 @@ const (
 	PGRESCONNSTRING   = "POSTGRESCONNSTRING" // Postgres connection string
 	AESSECRET         = "AESSECRET"          // AES Secret for encode/decode
 	JWTSECRET         = "JWTSECRET"          // Secret for JWT signing
-	JWTEXPIREINMONTH  = "JWTEXPIREM"         // JWT expiration in month
+	OTPSECRET         = "OTPSECRET"          // Secret keying the stored OTP hashes and the magic links
 	AUTHORIZEDDOMAIN  = "AUTHDOMAIN"         // Authorized e-mail domain for login
 	OTPLENGTH         = "OTPLENGTH"          // Length of the generated numerical OTP in character
 	WITHGOOGLESERVICE = "WITHGSERVICE"       // If true enable Google API Integration
@@ -19,6 +20,26 @@ const (
 	GOOGLECREDENTIAL  = "GSECRET"            // Google API credential JSON
 	VEHICLESHEETID    = "VEHICLESHEETID"     // Sheet ID for vehicle issue report
 	STATIONSHEETID    = "STATIONSHEETID"     // Sheet ID for station issue report
+
+	// Optional variables, see ReadEnvOrDefault
+	OTPSTORE            = "OTPSTORE"            // Optional, OTP store backend: memory (default) or postgres
+	JWTKEYS             = "JWTKEYS"             // Optional, JSON array of JWT signing keys
+	JWTACTIVEKID        = "JWTACTIVEKID"        // Optional, ID of the JWT signing key in use, required with JWTKEYS
+	REDIRECTALLOWLIST   = "REDIRECTALLOWLIST"   // Optional, comma separated origins allowed as absolute redirect after login
+	CORSALLOWORIGINS    = "CORSALLOWORIGINS"    // Optional, comma separated origins allowed to call the API cross origin
+	OTPRATELIMITIP      = "OTPRATELIMITIP"      // Optional, OTP requests allowed per client IP as <count>/<window>, default 20/1h
+	OTPRATELIMITMAILBOX = "OTPRATELIMITMAILBOX" // Optional, OTP requests allowed per mailbox as <count>/<window>, default 5/1h
+	TOTPRATELIMIT       = "TOTPRATELIMIT"       // Optional, authenticator app login attempts allowed per mailbox as <count>/<window>, default 10/15m
+	MAGICLINKURL        = "MAGICLINKURL"        // Optional, public base URL of the app, enables the magic link in the OTP mail
+	GOOGLELOGINREDIRECT = "GOOGLELOGINREDIRECT" // Optional, sign in with Google callback URL, default <request base URL>/login/google/callback
+	JWTACCESSTTL        = "JWTACCESSTTL"        // Optional, access token lifetime, renewed while the session is valid, default 15m
+	SESSIONIDLE         = "SESSIONIDLE"         // Optional, time without requests after which a session expires, default 72h
+	SESSIONMAXAGE       = "SESSIONMAXAGE"       // Optional, absolute session lifetime, default 720h
+	MIGRATEONSTART      = "MIGRATEONSTART"      // Optional, if true (default) apply the pending schema migrations at startup
+	AESKEYS             = "AESKEYS"             // Optional, JSON array of AES keys encrypting the stored secrets
+	AESACTIVEKID        = "AESACTIVEKID"        // Optional, ID of the AES key encrypting new secrets, required with AESKEYS
+	PROXYHEADER         = "PROXYHEADER"         // Optional, header carrying the client IP set by the load balancer, like X-Real-IP
+	TRUSTEDPROXIES      = "TRUSTEDPROXIES"      // Optional, comma separated IPs or CIDRs of the load balancers, required with PROXYHEADER
 )
 
 // CheckEnvCompliance verifies that all required environment variables are set.
@@ -30,7 +51,7 @@ func CheckEnvCompliance() {
 		PGRESCONNSTRING,
 		AESSECRET,
 		JWTSECRET,
-		JWTEXPIREINMONTH,
+		OTPSECRET,
 		AUTHORIZEDDOMAIN,
 		OTPLENGTH,
 		WITHGOOGLESERVICE,
@@ -46,8 +67,29 @@ func CheckEnvCompliance() {
 	}
 }
 
+// dotenvCache records the load of the .env file, see loadDotenv
+var dotenvCache struct {
+	mux    sync.Mutex
+	loaded bool
+	err    error
+}
+
+// loadDotenv loads the .env file with godotenv.Load(), without overriding the variables already set, and returns its error.
+// The file is read only on the first call, later calls return the first result: optional variables left unset,
+// read on every request, don't read the file from disk again.
+func loadDotenv() error {
+	dotenvCache.mux.Lock()
+	defer dotenvCache.mux.Unlock()
+
+	if !dotenvCache.loaded {
+		dotenvCache.err = godotenv.Load()
+		dotenvCache.loaded = true
+	}
+	return dotenvCache.err
+}
+
 // ReadEnvOrPanic reads the value of the specified environment variable with the given name.
-// If the variable is not set in the environment, it attempts to load the values from a .env file using godotenv.Load().
+// If the variable is not set in the environment, it attempts to load the values from a .env file, see loadDotenv.
 // If the .env file cannot be loaded or the variable is still not set, it panics with an appropriate error message.
 func ReadEnvOrPanic(name string) string {
 	var res string
@@ -55,7 +97,7 @@ func ReadEnvOrPanic(name string) string {
 	res, ok := os.LookupEnv(name)
 	if !ok {
 		log.Printf("Can't find env: %s, looking for .env file", name)
-		err := godotenv.Load()
+		err := loadDotenv()
 		if err != nil {
 			log.Panicf("Error loading .env file:\t%s", err)
 		}
@@ -68,3 +110,20 @@ func ReadEnvOrPanic(name string) string {
 
 	return res
 }
+
+// ReadEnvOrDefault reads the value of the specified optional environment variable with the given name.
+// If the variable is not set in the environment, it attempts to load the values from a .env file, see loadDotenv.
+// If the variable is still not set, the given default value is returned.
+func ReadEnvOrDefault(name string, def string) string {
+	res, ok := os.LookupEnv(name)
+	if !ok {
+		_ = loadDotenv()
+
+		res, ok = os.LookupEnv(name)
+		if !ok {
+			return def
+		}
+	}
+
+	return res
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -42,3 +43,80 @@ func TestReadEnvOrPanic(t *testing.T) {
 		})
 	}
 }
+
+func TestReadEnvOrDefault(t *testing.T) {
+	cases := []struct {
+		name     string
+		envName  string
+		envValue string
+		def      string
+		want     string
+	}{
+		{
+			name:     "Environment variable exists",
+			envName:  "EXISTING_OPTIONAL_VARIABLE",
+			envValue: "value",
+			def:      "default",
+			want:     "value",
+		},
+		{
+			name:    "Environment variable does not exist",
+			envName: "NON_EXISTING_OPTIONAL_VARIABLE",
+			def:     "default",
+			want:    "default",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.envValue != "" {
+				os.Setenv(tt.envName, tt.envValue)
+				defer os.Unsetenv(tt.envName)
+			}
+			if got := ReadEnvOrDefault(tt.envName, tt.def); got != tt.want {
+				t.Errorf("ReadEnvOrDefault() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadEnvOrDefaultLoadsDotenvOnce(t *testing.T) {
+	const name = "DOTENV_ONCE_VARIABLE"
+	resetDotenv := func() {
+		dotenvCache.mux.Lock()
+		defer dotenvCache.mux.Unlock()
+		dotenvCache.loaded = false
+		dotenvCache.err = nil
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd() error = %v", err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Chdir() error = %v", err)
+	}
+	resetDotenv()
+	defer func() {
+		os.Unsetenv(name)
+		os.Chdir(wd)
+		resetDotenv()
+	}()
+
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte(name+"=first\n"), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if got := ReadEnvOrDefault(name, "default"); got != "first" {
+		t.Fatalf("ReadEnvOrDefault() = %v, want first", got)
+	}
+
+	// The file isn't read again
+	os.Unsetenv(name)
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte(name+"=second\n"), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if got := ReadEnvOrDefault(name, "default"); got != "default" {
+		t.Errorf("ReadEnvOrDefault() = %v, want default", got)
+	}
+}